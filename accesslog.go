@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 访问日志：每个请求一行，写入 -access-log 指定的文件，与调试日志互不影响。
+// 格式为 combined（Apache/Nginx Combined Log Format，末尾追加耗时、模型和 token 数）或 json。
+// 请求路径不含查询参数，避免 ?api_key= 之类的密钥写入日志
+const (
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+)
+
+type accessLogFile struct {
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+var accessLog accessLogFile
+
+func openAccessLog() error {
+	switch config().AccessLogFormat {
+	case accessLogCombined, accessLogJSON:
+	default:
+		return fmt.Errorf("invalid -access-log-format %q, expected %s or %s", config().AccessLogFormat, accessLogCombined, accessLogJSON)
+	}
+	if config().AccessLog == "" {
+		return nil
+	}
+	accessLog.mu.Lock()
+	defer accessLog.mu.Unlock()
+	return accessLog.openLocked()
+}
+
+func (l *accessLogFile) openLocked() error {
+	f, err := os.OpenFile(config().AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// 超过 -access-log-max-size 时轮转：access.log.1 -> access.log.2 ...，最多保留 -access-log-backups 个
+func (l *accessLogFile) rotateLocked() error {
+	l.file.Close()
+	l.file = nil
+	if config().AccessLogBackups <= 0 {
+		os.Remove(config().AccessLog)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", config().AccessLog, config().AccessLogBackups))
+		for i := config().AccessLogBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", config().AccessLog, i), fmt.Sprintf("%s.%d", config().AccessLog, i+1))
+		}
+		if err := os.Rename(config().AccessLog, config().AccessLog+".1"); err != nil {
+			return err
+		}
+	}
+	return l.openLocked()
+}
+
+func (l *accessLogFile) write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	maxSize := int64(config().AccessLogMaxSize) << 20
+	if maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > maxSize {
+		if err := l.rotateLocked(); err != nil {
+			logf(slog.LevelError, tr("access_log_write_failed"), err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		logf(slog.LevelError, tr("access_log_write_failed"), err)
+	}
+}
+
+// 处理函数通过 recordUsage 把模型和 token 数回填到访问日志条目
+type accessLogUsage struct {
+	mu     sync.Mutex
+	model  string
+	tokens int
+}
+
+type accessLogContextKey struct{}
+
+func noteAccessUsage(ctx context.Context, model string, tokens int) {
+	if u, _ := ctx.Value(accessLogContextKey{}).(*accessLogUsage); u != nil {
+		u.mu.Lock()
+		u.model = model
+		u.tokens += tokens
+		u.mu.Unlock()
+	}
+}
+
+type accessLogRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *accessLogRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *accessLogRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *accessLogRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"duration_ms"`
+	Key        string    `json:"key,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Tokens     int       `json:"tokens"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+func (e accessLogEntry) combined() string {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %d %q %q %.3f %q %d\n",
+		e.RemoteAddr, dash(e.Key), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.Path+" "+e.Protocol, e.Status, e.Bytes,
+		dash(e.Referer), dash(e.UserAgent), float64(e.DurationMs)/1000, dash(e.Model), e.Tokens)
+}
+
+// 包在请求 ID 中间件外层，响应头中的 X-Request-ID 在处理结束后读取；未设置 -access-log 时不做任何处理
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config().AccessLog == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		usage := &accessLogUsage{}
+		rec := &accessLogRecorder{ResponseWriter: w}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = 499
+			}
+			entry := accessLogEntry{
+				Time:       start,
+				RequestID:  w.Header().Get("X-Request-ID"),
+				RemoteAddr: clientIP(r).String(),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Protocol:   r.Proto,
+				Status:     status,
+				Bytes:      rec.bytes,
+				DurationMs: time.Since(start).Milliseconds(),
+				Referer:    r.Referer(),
+				UserAgent:  r.UserAgent(),
+			}
+			if identity := clientIdentity(r); identity != "" {
+				entry.Key = usageLabel(identity)
+			}
+			usage.mu.Lock()
+			entry.Model, entry.Tokens = usage.model, usage.tokens
+			usage.mu.Unlock()
+
+			if config().AccessLogFormat == accessLogJSON {
+				line, _ := json.Marshal(entry)
+				accessLog.write(append(line, '\n'))
+			} else {
+				accessLog.write([]byte(entry.combined()))
+			}
+		}()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, usage)))
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	balanceRoundRobin  = "round-robin"
+	balanceLeastLoaded = "least-loaded"
+	balanceSticky      = "sticky"
+)
+
+// 账号池中的一组 Cloudflare 凭据；AuthToken 为空表示使用 -token/-token-file 的令牌（支持热更新）
+type poolAccount struct {
+	AccountID    string
+	AuthToken    string
+	inflight     int
+	ejectedUntil time.Time
+}
+
+var accountPool struct {
+	mu       sync.Mutex
+	accounts []*poolAccount
+	next     int
+}
+
+// 解析 -accounts，格式为 "account:token,account:token"；-id/-token 配置的主账号排在最前面
+func loadAccountPool() error {
+	switch config().AccountBalance {
+	case balanceRoundRobin, balanceLeastLoaded, balanceSticky:
+	default:
+		return fmt.Errorf("invalid -account-balance %q, expected %s, %s or %s", config().AccountBalance, balanceRoundRobin, balanceLeastLoaded, balanceSticky)
+	}
+	var accounts []*poolAccount
+	if config().AccountID != "" && currentAuthToken() != "" {
+		accounts = append(accounts, &poolAccount{AccountID: config().AccountID})
+	}
+	for _, item := range strings.Split(config().Accounts, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, token, ok := strings.Cut(item, ":")
+		id, token = strings.TrimSpace(id), strings.TrimSpace(token)
+		if !ok || id == "" || token == "" {
+			return fmt.Errorf("invalid -accounts entry, expected account:token")
+		}
+		accounts = append(accounts, &poolAccount{AccountID: id, AuthToken: token})
+	}
+
+	// 重新加载时保留未变化账号的剔除状态和进行中的请求数
+	accountPool.mu.Lock()
+	defer accountPool.mu.Unlock()
+	for i, a := range accounts {
+		for _, old := range accountPool.accounts {
+			if old.AccountID == a.AccountID && old.AuthToken == a.AuthToken {
+				accounts[i] = old
+			}
+		}
+	}
+	accountPool.accounts = accounts
+	return nil
+}
+
+func accountPoolSize() int {
+	accountPool.mu.Lock()
+	defer accountPool.mu.Unlock()
+	return len(accountPool.accounts)
+}
+
+func accountPoolTokens() []string {
+	accountPool.mu.Lock()
+	defer accountPool.mu.Unlock()
+	tokens := make([]string, 0, len(accountPool.accounts))
+	for _, a := range accountPool.accounts {
+		tokens = append(tokens, a.AuthToken)
+	}
+	return tokens
+}
+
+// 每个请求第一次访问上游时选定账号，之后都使用同一账号，直到该账号被移出轮换
+type accountSlot struct {
+	mu      sync.Mutex
+	account *poolAccount
+	// sticky 模式下的会话标识，由处理函数在第一次访问上游之前设置
+	affinity string
+}
+
+type accountContextKey struct{}
+
+// 账号池有多个账号时为每个请求准备账号槽位，请求结束后释放占用，供 least-loaded 统计；
+// 账号数在重新加载配置后可能变化，因此按请求判断
+func accountPoolMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accountPoolSize() <= 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slot := &accountSlot{}
+		r = r.WithContext(context.WithValue(r.Context(), accountContextKey{}, slot))
+		defer func() {
+			if slot.account != nil {
+				accountPool.mu.Lock()
+				slot.account.inflight--
+				metrics.set("gptoss2api_account_inflight", float64(slot.account.inflight), "account", slot.account.AccountID)
+				accountPool.mu.Unlock()
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 租户指定了账号时不使用账号池；后台任务没有槽位，使用第一个账号；账号池为空时返回 nil，使用 -id/-token 的默认账号
+func pooledAccount(ctx context.Context) *poolAccount {
+	if t := requestTenant(ctx); t != nil && t.AccountID != "" {
+		return nil
+	}
+	slot, _ := ctx.Value(accountContextKey{}).(*accountSlot)
+	if slot == nil {
+		accountPool.mu.Lock()
+		defer accountPool.mu.Unlock()
+		if len(accountPool.accounts) == 0 {
+			return nil
+		}
+		return accountPool.accounts[0]
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.account == nil {
+		slot.account = pickAccount(ctx, slot.affinity)
+	}
+	return slot.account
+}
+
+// 记录请求所属的会话，-account-balance=sticky 时同一会话固定使用同一账号，提高上游提示词缓存的命中率。
+// 优先使用 X-Session-ID 请求头和请求中的 user 字段（与客户端密钥一起计算，不同密钥之间互不影响），
+// 都没有时用第一条 system 消息和第一条 user 消息作为会话指纹：同一对话的后续轮次开头相同
+func setAccountAffinity(r *http.Request, user string, messages []Message) {
+	if config().AccountBalance != balanceSticky {
+		return
+	}
+	slot, _ := r.Context().Value(accountContextKey{}).(*accountSlot)
+	if slot == nil {
+		return
+	}
+	h := fnv.New64a()
+	switch {
+	case r.Header.Get("X-Session-ID") != "":
+		fmt.Fprintf(h, "session\x00%s\x00%s", requestKeyID(r), r.Header.Get("X-Session-ID"))
+	case user != "":
+		fmt.Fprintf(h, "user\x00%s\x00%s", requestKeyID(r), user)
+	default:
+		seen := map[string]bool{}
+		for _, msg := range messages {
+			if (msg.Role == "system" || msg.Role == "user") && !seen[msg.Role] {
+				seen[msg.Role] = true
+				content, _ := json.Marshal(msg.Content)
+				fmt.Fprintf(h, "%s\x00%s\x00", msg.Role, content)
+			}
+		}
+		if len(seen) == 0 {
+			return
+		}
+	}
+	slot.mu.Lock()
+	slot.affinity = strconv.FormatUint(h.Sum64(), 16)
+	slot.mu.Unlock()
+}
+
+// 最高随机权重（rendezvous）哈希：每个会话在所有账号中有固定的排序，
+// 首选账号被剔除或额度用完时落到下一个，恢复后回到首选账号；增删账号只影响涉及的会话
+func stickyScore(affinity, accountID string) uint64 {
+	sum := sha256.Sum256([]byte(affinity + "\x00" + accountID))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// 跳过被暂时剔除和当日额度已用完的账号；全部不可用时选剔除最早到期的账号，不直接拒绝请求
+func pickAccount(ctx context.Context, affinity string) *poolAccount {
+	accountPool.mu.Lock()
+	if len(accountPool.accounts) == 0 {
+		// 重新加载配置清空了账号池，由调用方回退到默认账号
+		accountPool.mu.Unlock()
+		return nil
+	}
+	candidates := make([]*poolAccount, 0, len(accountPool.accounts))
+	now := time.Now()
+	for _, a := range accountPool.accounts {
+		if now.After(a.ejectedUntil) {
+			candidates = append(candidates, a)
+		}
+	}
+	accountPool.mu.Unlock()
+
+	// 额度检查需要访问共享存储，放在锁外进行
+	available := candidates[:0]
+	for _, a := range candidates {
+		if !accountBudgetUsedUp(ctx, a.AccountID) {
+			available = append(available, a)
+		}
+	}
+
+	accountPool.mu.Lock()
+	defer accountPool.mu.Unlock()
+	var chosen *poolAccount
+	switch {
+	case len(available) == 0:
+		for _, a := range accountPool.accounts {
+			if chosen == nil || a.ejectedUntil.Before(chosen.ejectedUntil) {
+				chosen = a
+			}
+		}
+	case config().AccountBalance == balanceSticky && affinity != "":
+		for _, a := range available {
+			if chosen == nil || stickyScore(affinity, a.AccountID) > stickyScore(affinity, chosen.AccountID) {
+				chosen = a
+			}
+		}
+		if len(available) < len(accountPool.accounts) {
+			var preferred *poolAccount
+			for _, a := range accountPool.accounts {
+				if preferred == nil || stickyScore(affinity, a.AccountID) > stickyScore(affinity, preferred.AccountID) {
+					preferred = a
+				}
+			}
+			if preferred != chosen {
+				metrics.inc("gptoss2api_account_sticky_fallbacks_total", "account", preferred.AccountID)
+			}
+		}
+	case config().AccountBalance == balanceLeastLoaded:
+		for _, a := range available {
+			if chosen == nil || a.inflight < chosen.inflight {
+				chosen = a
+			}
+		}
+	default:
+		chosen = available[accountPool.next%len(available)]
+		accountPool.next++
+	}
+	if chosen == nil {
+		return nil
+	}
+	chosen.inflight++
+	metrics.set("gptoss2api_account_inflight", float64(chosen.inflight), "account", chosen.AccountID)
+	return chosen
+}
+
+// 401/403 说明令牌被吊销或权限不足，429 说明账号额度耗尽，均在 -account-eject 时间内移出轮换
+func recordAccountResult(ctx context.Context, statusCode int) {
+	if statusCode != http.StatusUnauthorized && statusCode != http.StatusForbidden && statusCode != http.StatusTooManyRequests {
+		return
+	}
+	if accountOverride(ctx) != nil {
+		return
+	}
+	a := pooledAccount(ctx)
+	if a == nil || accountPoolSize() <= 1 {
+		return
+	}
+	ejectAccount(a, fmt.Sprint(statusCode))
+	// 释放槽位，请求的下一次重试会换用其他账号
+	if slot, _ := ctx.Value(accountContextKey{}).(*accountSlot); slot != nil {
+		slot.mu.Lock()
+		if slot.account == a {
+			accountPool.mu.Lock()
+			a.inflight--
+			accountPool.mu.Unlock()
+			slot.account = nil
+		}
+		slot.mu.Unlock()
+	}
+	logf(slog.LevelWarn, tr("account_ejected"), a.AccountID, statusCode, config().AccountEject)
+}
+
+// status 为上游状态码，健康检查失败时为 probe
+func ejectAccount(a *poolAccount, status string) {
+	accountPool.mu.Lock()
+	a.ejectedUntil = time.Now().Add(config().AccountEject)
+	accountPool.mu.Unlock()
+	metrics.inc("gptoss2api_account_ejections_total", "account", a.AccountID, "status", status)
+}
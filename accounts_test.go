@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setAccountPool(t *testing.T, accounts ...*poolAccount) {
+	t.Helper()
+	accountPool.mu.Lock()
+	old := accountPool.accounts
+	accountPool.accounts = accounts
+	accountPool.mu.Unlock()
+	t.Cleanup(func() {
+		accountPool.mu.Lock()
+		accountPool.accounts = old
+		accountPool.mu.Unlock()
+	})
+}
+
+func TestPooledAccountEmptyPool(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AccountID = "default"
+		c.AccountBalance = balanceRoundRobin
+	})
+	setAccountPool(t)
+	// 槽位由中间件在账号池还有多个账号时安装，随后重新加载配置清空了账号池
+	ctx := context.WithValue(context.Background(), accountContextKey{}, &accountSlot{})
+	if a := pickAccount(ctx, ""); a != nil {
+		t.Fatalf("pickAccount = %+v, want nil", a)
+	}
+	if got := upstreamAccountID(ctx); got != "default" {
+		t.Errorf("upstreamAccountID = %q, want default", got)
+	}
+}
+
+// 按账号 ID 返回固定状态码的上游
+type probeTransport map[string]int
+
+func (p probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := http.StatusOK
+	for id, code := range p {
+		if strings.Contains(req.URL.Path, "/accounts/"+id+"/") {
+			status = code
+		}
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+}
+
+func TestProbeUpstreamAccountPool(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    probeTransport
+		wantOpen    bool
+		wantEjected []string
+	}{
+		{
+			name:     "all accounts healthy",
+			statuses: probeTransport{},
+		},
+		{
+			name:        "one account fails",
+			statuses:    probeTransport{"b": http.StatusUnauthorized},
+			wantEjected: []string{"b"},
+		},
+		{
+			name:     "all accounts fail",
+			statuses: probeTransport{"a": http.StatusUnauthorized, "b": http.StatusForbidden},
+			wantOpen: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.AccountEject = time.Minute
+				c.BreakerCooldown = time.Minute
+			})
+			a, b := &poolAccount{AccountID: "a", AuthToken: "ta"}, &poolAccount{AccountID: "b", AuthToken: "tb"}
+			setAccountPool(t, a, b)
+			upstreamClients.once.Do(func() {})
+			plain := upstreamClients.plain
+			upstreamClients.plain = &http.Client{Transport: tt.statuses}
+			t.Cleanup(func() {
+				upstreamClients.plain = plain
+				breaker.recordSuccess()
+			})
+
+			probeUpstream()
+
+			if open := !breaker.allow(); open != tt.wantOpen {
+				t.Errorf("breaker open = %v, want %v", open, tt.wantOpen)
+			}
+			var ejected []string
+			for _, acc := range []*poolAccount{a, b} {
+				if time.Now().Before(acc.ejectedUntil) {
+					ejected = append(ejected, acc.AccountID)
+				}
+			}
+			if strings.Join(ejected, ",") != strings.Join(tt.wantEjected, ",") {
+				t.Errorf("ejected = %v, want %v", ejected, tt.wantEjected)
+			}
+		})
+	}
+}
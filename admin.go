@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 运行时配置管理接口，挂载在 /admin 下，需要 -admin-key。设置了 -admin-port 或 -admin-listen 时只在该端口提供，
+// 便于只对内网开放。请求带 ?persist=true 时把修改写回配置文件（或 -keys-file/-key-limits 文件）
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/replay/", handleReplay)
+	mux.HandleFunc("/admin/purge", handlePurge)
+	mux.HandleFunc("/admin/config", handleAdminConfig)
+	mux.HandleFunc("/admin/aliases/", handleAdminAlias)
+	mux.HandleFunc("/admin/keys/", handleAdminKey)
+	mux.HandleFunc("/admin/limits", handleAdminLimits)
+	mux.HandleFunc("/admin/limits/", handleAdminLimits)
+	mux.HandleFunc("/admin/log-level", handleAdminLogLevel)
+	mux.HandleFunc("/admin/dashboard", handleDashboard)
+	mux.HandleFunc("/admin/dashboard/data", handleDashboardData)
+}
+
+func startAdminServer() {
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux)
+	listen := config().AdminListen
+	if listen == "" {
+		listen = ":" + config().AdminPort
+	}
+	for _, addr := range strings.Split(listen, ",") {
+		if strings.TrimSpace(addr) == listenSystemd {
+			log.Fatal("-admin-listen=systemd would claim every socket-activated socket, use systemd:<name> with the FileDescriptorName= of the admin socket")
+		}
+	}
+	listeners, err := openListeners(listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Printf(tr("admin_listening"), listenerAddrs(listeners))
+		srv := &http.Server{Handler: accessLogMiddleware(requestIDMiddleware(recoverMiddleware(ipFilterMiddleware(mux)))), ReadHeaderTimeout: 10 * time.Second}
+		if err := serveListeners(srv, listeners); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+type adminConfigView struct {
+	Aliases  map[string]string `json:"aliases"`
+	Keys     []string          `json:"keys"`
+	Limits   adminLimitsView   `json:"limits"`
+	LogLevel string            `json:"log_level"`
+}
+
+type adminLimitsView struct {
+	Default KeyLimit            `json:"default"`
+	Keys    map[string]KeyLimit `json:"keys"`
+}
+
+// 客户端密钥只返回 ID，不返回密钥本身
+func currentAdminConfig() adminConfigView {
+	credentials.mu.RLock()
+	ids := make([]string, 0, len(credentials.clientKeys)+1)
+	if credentials.clientKey != "" {
+		ids = append(ids, defaultKeyID)
+	}
+	for _, id := range credentials.clientKeys {
+		ids = append(ids, id)
+	}
+	credentials.mu.RUnlock()
+	sort.Strings(ids)
+
+	keyLimitsMu.RLock()
+	limits := make(map[string]KeyLimit, len(keyLimits))
+	for id, limit := range keyLimits {
+		limits[id] = limit
+	}
+	keyLimitsMu.RUnlock()
+
+	return adminConfigView{
+		Aliases:  modelAliasSnapshot(),
+		Keys:     ids,
+		Limits:   adminLimitsView{Default: KeyLimit{RPM: config().KeyRPM, TPD: config().KeyTPD}, Keys: limits},
+		LogLevel: strings.ToLower(logLevel.Level().String()),
+	}
+}
+
+// 所有管理接口共用的认证和方法检查，修改成功后返回最新配置
+func adminPreamble(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if !authorizeAdmin(r) {
+		writeUnauthorized(w)
+		return false
+	}
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	return false
+}
+
+func writeAdminConfig(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentAdminConfig())
+}
+
+// 要求写回但没有可写的文件时，在修改内存中的配置之前拒绝请求
+func adminCannotPersist(w http.ResponseWriter, r *http.Request, path, flagName string) bool {
+	if r.URL.Query().Get("persist") != "true" || path != "" {
+		return false
+	}
+	writeError(w, http.StatusBadRequest, "invalid_request", "persist=true requires "+flagName)
+	return true
+}
+
+func adminPersist(w http.ResponseWriter, r *http.Request, persist func() error) bool {
+	if r.URL.Query().Get("persist") != "true" {
+		return true
+	}
+	if err := persist(); err != nil {
+		writeError(w, http.StatusInternalServerError, "persist_failed", err.Error())
+		return false
+	}
+	return true
+}
+
+// GET /admin/config
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !adminPreamble(w, r, http.MethodGet) {
+		return
+	}
+	writeAdminConfig(w)
+}
+
+// PUT /admin/aliases/{alias} {"model": "@cf/..."}，DELETE /admin/aliases/{alias}
+func handleAdminAlias(w http.ResponseWriter, r *http.Request) {
+	if !adminPreamble(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	if adminCannotPersist(w, r, config().ConfigFile, "-config") {
+		return
+	}
+	alias := strings.TrimPrefix(r.URL.Path, "/admin/aliases/")
+	if alias == "" || strings.ContainsAny(alias, "=,") {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid alias name")
+		return
+	}
+	if r.Method == http.MethodPut {
+		var body struct {
+			Model string `json:"model"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) != nil || body.Model == "" || strings.ContainsAny(body.Model, "=,") {
+			writeErrorParam(w, http.StatusBadRequest, "invalid_request", "model is required", "model")
+			return
+		}
+		modelAliasesMu.Lock()
+		modelAliases[alias] = body.Model
+		modelAliasesMu.Unlock()
+	} else {
+		modelAliasesMu.Lock()
+		_, ok := modelAliases[alias]
+		delete(modelAliases, alias)
+		modelAliasesMu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "Alias not found")
+			return
+		}
+	}
+	log.Printf(tr("admin_changed"), "alias", alias)
+	if adminPersist(w, r, persistModelAliases) {
+		writeAdminConfig(w)
+	}
+}
+
+// PUT /admin/keys/{id} {"key": "sk-..."}，DELETE /admin/keys/{id} 立即吊销
+func handleAdminKey(w http.ResponseWriter, r *http.Request) {
+	if !adminPreamble(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	if adminCannotPersist(w, r, config().KeysFile+config().ConfigFile, "-keys-file or -config") {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/admin/keys/")
+	if id == "" || id == defaultKeyID || strings.ContainsAny(id, ":,") {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid key ID")
+		return
+	}
+	var key string
+	if r.Method == http.MethodPut {
+		var body struct {
+			Key string `json:"key"`
+		}
+		if json.NewDecoder(r.Body).Decode(&body) != nil || body.Key == "" || strings.ContainsAny(body.Key, ":,") {
+			writeErrorParam(w, http.StatusBadRequest, "invalid_request", "key is required", "key")
+			return
+		}
+		key = body.Key
+	}
+
+	credentials.mu.Lock()
+	found := false
+	for existing, existingID := range credentials.clientKeys {
+		if existingID == id {
+			delete(credentials.clientKeys, existing)
+			found = true
+		}
+	}
+	if key != "" {
+		if credentials.clientKeys == nil {
+			credentials.clientKeys = make(map[string]string)
+		}
+		credentials.clientKeys[key] = id
+	}
+	credentials.mu.Unlock()
+	if r.Method == http.MethodDelete && !found {
+		writeError(w, http.StatusNotFound, "not_found", "Key not found")
+		return
+	}
+	log.Printf(tr("admin_changed"), "key", id)
+	if adminPersist(w, r, persistClientKeys) {
+		writeAdminConfig(w)
+	}
+}
+
+// PUT /admin/limits {"rpm": 60, "tpd": 100000} 修改默认限额；
+// PUT /admin/limits/{id} 为单个密钥设置限额（还可以带 default_max_tokens 和 max_tokens_cap），DELETE /admin/limits/{id} 恢复默认
+func handleAdminLimits(w http.ResponseWriter, r *http.Request) {
+	if !adminPreamble(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/admin/limits"), "/")
+	if id == "" && adminCannotPersist(w, r, config().ConfigFile, "-config") || id != "" && adminCannotPersist(w, r, config().KeyLimitsFile, "-key-limits") {
+		return
+	}
+	var limit KeyLimit
+	if r.Method == http.MethodPut {
+		if json.NewDecoder(r.Body).Decode(&limit) != nil || limit.RPM < 0 || limit.TPD < 0 || limit.DefaultMaxTokens < 0 || limit.MaxTokensCap < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Body must be {\"rpm\": number, \"tpd\": number, \"default_max_tokens\": integer, \"max_tokens_cap\": integer}")
+			return
+		}
+		if id == "" && (limit.DefaultMaxTokens != 0 || limit.MaxTokensCap != 0) {
+			// 全局的 max_tokens 设置由 -default-max-tokens 和 -max-tokens-cap 控制
+			writeError(w, http.StatusBadRequest, "invalid_request", "default_max_tokens and max_tokens_cap can only be set per key via /admin/limits/{id}")
+			return
+		}
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodDelete:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	case id == "":
+		updateConfig(func(c *Config) {
+			c.KeyRPM, c.KeyTPD = limit.RPM, limit.TPD
+		})
+		log.Printf(tr("admin_changed"), "limits", "default")
+		if !adminPersist(w, r, persistDefaultKeyLimits) {
+			return
+		}
+	default:
+		keyLimitsMu.Lock()
+		_, ok := keyLimits[id]
+		if r.Method == http.MethodPut {
+			keyLimits[id] = limit
+		} else {
+			delete(keyLimits, id)
+		}
+		keyLimitsMu.Unlock()
+		if r.Method == http.MethodDelete && !ok {
+			writeError(w, http.StatusNotFound, "not_found", "No limits configured for this key")
+			return
+		}
+		log.Printf(tr("admin_changed"), "limits", id)
+		if !adminPersist(w, r, persistKeyLimits) {
+			return
+		}
+	}
+	writeAdminConfig(w)
+}
+
+// PUT /admin/log-level {"level": "debug"}
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !adminPreamble(w, r, http.MethodPut) {
+		return
+	}
+	if adminCannotPersist(w, r, config().ConfigFile, "-config") {
+		return
+	}
+	var body struct {
+		Level string `json:"level"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if err := setLogLevel(body.Level); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "level")
+		return
+	}
+	updateConfig(func(c *Config) { c.LogLevel = body.Level })
+	log.Printf(tr("admin_changed"), "log-level", body.Level)
+	if adminPersist(w, r, func() error { return persistConfigValue("log-level", body.Level) }) {
+		writeAdminConfig(w)
+	}
+}
+
+// 内置别名不写入配置文件；删除内置别名只在本次运行中生效
+func persistModelAliases() error {
+	var items []string
+	for alias, target := range modelAliasSnapshot() {
+		if builtinModelAliases[alias] != target {
+			items = append(items, alias+"="+target)
+		}
+	}
+	sort.Strings(items)
+	return persistConfigValue("model-aliases", strings.Join(items, ","))
+}
+
+// 配置了 -keys-file 时写回该文件，否则写入配置文件的 keys
+func persistClientKeys() error {
+	credentials.mu.RLock()
+	byID := make(map[string]string, len(credentials.clientKeys))
+	for key, id := range credentials.clientKeys {
+		byID[id] = key
+	}
+	credentials.mu.RUnlock()
+
+	if config().KeysFile != "" {
+		data, _ := json.MarshalIndent(byID, "", "  ")
+		return writeFileAtomic(config().KeysFile, append(data, '\n'))
+	}
+	items := make([]string, 0, len(byID))
+	for id, key := range byID {
+		items = append(items, id+":"+key)
+	}
+	sort.Strings(items)
+	return persistConfigValue("keys", strings.Join(items, ","))
+}
+
+func persistDefaultKeyLimits() error {
+	if err := persistConfigValue("key-rpm", strconv.FormatFloat(config().KeyRPM, 'f', -1, 64)); err != nil {
+		return err
+	}
+	return persistConfigValue("key-tpd", strconv.FormatFloat(config().KeyTPD, 'f', -1, 64))
+}
+
+func persistKeyLimits() error {
+	keyLimitsMu.RLock()
+	data, _ := json.MarshalIndent(keyLimits, "", "  ")
+	keyLimitsMu.RUnlock()
+	return writeFileAtomic(config().KeyLimitsFile, append(data, '\n'))
+}
+
+// 更新配置文件中的一个参数，同一参数的其他写法（下划线、大小写、别名）一并替换。
+// 文件按原格式重写，YAML 中的注释不会保留
+func persistConfigValue(name, value string) error {
+	values, err := readConfigFile(config().ConfigFile)
+	if err != nil {
+		return err
+	}
+	for key := range values {
+		normalized := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		if alias, ok := configKeyAliases[normalized]; ok {
+			normalized = alias
+		}
+		if normalized == name {
+			delete(values, key)
+		}
+	}
+	values[name] = value
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var data []byte
+	if ext := strings.ToLower(filepath.Ext(config().ConfigFile)); ext == ".json" {
+		data, _ = json.MarshalIndent(values, "", "  ")
+		data = append(data, '\n')
+	} else {
+		var b strings.Builder
+		for _, key := range keys {
+			v := values[key]
+			if strings.ContainsAny(v, "#:'\"[\\") || strings.TrimSpace(v) != v || strconv.Quote(v) != `"`+v+`"` {
+				v = strconv.Quote(v)
+			}
+			fmt.Fprintf(&b, "%s: %s\n", key, v)
+		}
+		data = []byte(b.String())
+	}
+	return writeFileAtomic(config().ConfigFile, data)
+}
+
+// 先写临时文件再改名，文件监听方不会读到写了一半的内容
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
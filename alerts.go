@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 告警统计窗口内的最少请求数，避免少量请求时错误率失真
+const alertMinRequests = 10
+
+type alertMonitor struct {
+	mu             sync.Mutex
+	windowStart    time.Time
+	requests       int
+	failures       int
+	upstreamErrors int
+	quotaExhausted int
+	lastSent       map[string]time.Time
+}
+
+var alerts = &alertMonitor{lastSent: make(map[string]time.Time)}
+
+// 记录一次上游调用结果，并检查是否触发告警
+func (a *alertMonitor) record(statusCode int, err error) {
+	if config().AlertWebhook == "" {
+		return
+	}
+
+	a.mu.Lock()
+	now := time.Now()
+	if now.Sub(a.windowStart) > config().AlertWindow {
+		a.windowStart = now
+		a.requests, a.failures, a.upstreamErrors, a.quotaExhausted = 0, 0, 0, 0
+	}
+
+	a.requests++
+	if statusCode != http.StatusOK {
+		a.failures++
+	}
+	if statusCode == 0 || statusCode >= 500 {
+		a.upstreamErrors++
+	}
+	if statusCode == http.StatusTooManyRequests {
+		a.quotaExhausted++
+	}
+
+	var fired []string
+	var messages []string
+	if a.requests >= alertMinRequests && config().AlertErrorRate > 0 {
+		rate := float64(a.failures) / float64(a.requests)
+		if rate >= config().AlertErrorRate {
+			fired = append(fired, "error_rate")
+			messages = append(messages, fmt.Sprintf(tr("alert_error_rate"), rate*100, a.failures, a.requests, config().AlertErrorRate*100))
+		}
+	}
+	if config().AlertUpstreamFailures > 0 && a.upstreamErrors >= config().AlertUpstreamFailures {
+		fired = append(fired, "upstream_failures")
+		messages = append(messages, fmt.Sprintf(tr("alert_upstream"), a.upstreamErrors, config().AlertUpstreamFailures))
+	}
+	if a.quotaExhausted > 0 {
+		fired = append(fired, "quota_exhausted")
+		messages = append(messages, fmt.Sprintf(tr("alert_quota"), a.quotaExhausted))
+	}
+
+	// 同类告警在冷却时间内只发送一次
+	var toSend []int
+	for i, kind := range fired {
+		if now.Sub(a.lastSent[kind]) < config().AlertCooldown {
+			continue
+		}
+		a.lastSent[kind] = now
+		toSend = append(toSend, i)
+	}
+	a.mu.Unlock()
+
+	for _, i := range toSend {
+		go sendAlert(fired[i], messages[i])
+	}
+}
+
+func sendAlert(kind, message string) {
+	text := fmt.Sprintf("[gptoss2api] %s (window %s)", message, config().AlertWindow)
+	generic := map[string]interface{}{
+		"alert":     kind,
+		"message":   message,
+		"timestamp": time.Now().Unix(),
+	}
+	if err := postWebhook(config().AlertWebhook, text, generic); err != nil {
+		logf(slog.LevelWarn, tr("alert_send_failed"), err)
+		return
+	}
+	log.Printf(tr("alert_sent"), message)
+}
+
+// 根据 webhook 地址选择 Slack、Discord 或通用 JSON 格式，通用格式直接发送 generic
+func postWebhook(url, text string, generic map[string]interface{}) error {
+	var payload interface{}
+	switch {
+	case strings.Contains(url, "hooks.slack.com"):
+		payload = map[string]interface{}{"text": text}
+	case strings.Contains(url, "discord.com/api/webhooks"), strings.Contains(url, "discordapp.com/api/webhooks"):
+		payload = map[string]interface{}{"content": text}
+	default:
+		payload = generic
+	}
+
+	body, _ := json.Marshal(payload)
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{Proxy: egressProxy, DisableKeepAlives: true}}
+	resp, err := client.Post(url, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
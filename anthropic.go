@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Anthropic Messages API 请求，只支持文本内容块
+type AnthropicRequest struct {
+	Model         string             `json:"model"`
+	System        json.RawMessage    `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Stream        bool               `json:"stream,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Thinking      *AnthropicThinking `json:"thinking,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type AnthropicThinking struct {
+	Type string `json:"type"`
+}
+
+type AnthropicContentBlock struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Thinking string `json:"thinking,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type AnthropicResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   string                  `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// 与 Anthropic 一致的错误类型
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+func anthropicErrorEnvelope(status int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    anthropicErrorType(status),
+			"message": message,
+		},
+	}
+}
+
+// /v1/messages 使用 Anthropic 的 {"type": "error", "error": {...}} 格式，Anthropic SDK 可以直接解析
+func writeAnthropicError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anthropicErrorEnvelope(status, message))
+}
+
+func writeAnthropicUpstreamError(w http.ResponseWriter, err error) {
+	status, _, message := upstreamErrorStatus(err)
+	writeAnthropicError(w, status, message)
+}
+
+// system 和消息内容可以是字符串，也可以是内容块数组
+func anthropicText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text, nil
+	}
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("content must be a string or an array of content blocks")
+	}
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, block.Text)
+		case "thinking", "redacted_thinking":
+			// 多轮对话中客户端回传的推理内容不再发给上游
+		default:
+			return "", fmt.Errorf("content block type %s is not supported", block.Type)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// 转换为 OpenAI 格式的请求，复用聊天接口的模型选择、max_tokens 策略和上游调用
+func convertAnthropicRequest(req AnthropicRequest) (OpenAIRequest, error) {
+	if req.MaxTokens <= 0 {
+		return OpenAIRequest{}, fmt.Errorf("max_tokens is required")
+	}
+	if len(req.Messages) == 0 {
+		return OpenAIRequest{}, fmt.Errorf("messages must not be empty")
+	}
+	openaiReq := OpenAIRequest{
+		Model:         req.Model,
+		Stream:        req.Stream,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		MaxTokens:     &req.MaxTokens,
+		ReasoningMode: reasoningStrip,
+	}
+	if req.Thinking != nil && req.Thinking.Type == "enabled" {
+		openaiReq.ReasoningMode = reasoningContent
+	}
+	system, err := anthropicText(req.System)
+	if err != nil {
+		return OpenAIRequest{}, err
+	}
+	if system != "" {
+		openaiReq.Messages = append(openaiReq.Messages, Message{Role: "system", Content: system})
+	}
+	for _, msg := range req.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return OpenAIRequest{}, fmt.Errorf("message role must be user or assistant")
+		}
+		text, err := anthropicText(msg.Content)
+		if err != nil {
+			return OpenAIRequest{}, err
+		}
+		openaiReq.Messages = append(openaiReq.Messages, Message{Role: msg.Role, Content: text})
+	}
+	return openaiReq, nil
+}
+
+func anthropicStopReason(finishReason, matchedStop string) string {
+	if matchedStop != "" {
+		return "stop_sequence"
+	}
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "content_filter":
+		return "refusal"
+	case "tool_calls":
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+func anthropicMessageID(id string) string {
+	if id == "" {
+		return fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	}
+	return "msg_" + strings.TrimPrefix(id, "resp_")
+}
+
+// POST /v1/messages：Anthropic Messages API 兼容接口，便于只支持 Claude 的客户端直接使用 gpt-oss
+func handleAnthropicMessages(w http.ResponseWriter, r *http.Request) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeAnthropicError(w, http.StatusUnauthorized, "invalid x-api-key")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAnthropicError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err := checkKeyLimits(w, r); err != nil {
+		writeAnthropicError(w, http.StatusTooManyRequests, err.Message)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			metrics.inc("gptoss2api_body_too_large_total")
+			writeAnthropicError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the maximum size of %d MB", config().MaxBodySize))
+		} else {
+			writeAnthropicError(w, http.StatusBadRequest, "Failed to read request body")
+		}
+		return
+	}
+	reqLog.Body(tr("user_request"), string(body))
+
+	var req AnthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	openaiReq, err := convertAnthropicRequest(req)
+	if err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	setAccountAffinity(r, "", openaiReq.Messages)
+
+	if !breaker.allow() {
+		writeAnthropicError(w, http.StatusServiceUnavailable, "Upstream temporarily unavailable")
+		return
+	}
+	if accountBudgetExhausted(r.Context()) {
+		writeAnthropicError(w, http.StatusTooManyRequests, "Daily neuron allowance for the upstream account is exhausted")
+		return
+	}
+	if req.Stream {
+		key := clientIdentity(r)
+		if !streams.acquire(key) {
+			writeAnthropicError(w, http.StatusTooManyRequests, "Too many concurrent streams for this API key")
+			return
+		}
+		defer streams.release(key)
+	}
+
+	model := resolveModel(r.Context(), openaiReq.Model)
+	applyModelDefaults(w, openaiReq.Model, model, &openaiReq)
+	applyMaxTokensPolicy(r, &openaiReq)
+	trimContext(w, r, model, &openaiReq)
+	r, model, provider := routeProvider(r, model)
+	if provider != nil {
+		writeAnthropicError(w, http.StatusBadRequest, fmt.Sprintf("Model %s is only available on /v1/chat/completions", openaiReq.Model))
+		return
+	}
+	cfReq := convertToCloudflareRequest(openaiReq, model)
+	if err := checkContextLength(cfReq.Model, openaiReq); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Stream {
+		streamAnthropicMessage(w, r, req, openaiReq, cfReq, reqLog)
+		return
+	}
+
+	upstreamStart := time.Now()
+	cfResp, rawCFJSON, shared, err := callCloudflareAPICoalesced(cfReq, r.Context())
+	recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		writeAnthropicUpstreamError(w, err)
+		return
+	}
+	reqLog.Body(tr("upstream_raw"), rawCFJSON)
+
+	openaiResp := convertToOpenAIResponse(cfResp, openaiReq)
+	choice := openaiResp.Choices[0]
+	text, _ := choice.Message.Content.(string)
+	text, matchedStop := truncateAtStop(text, req.StopSequences)
+
+	resp := AnthropicResponse{
+		ID:         anthropicMessageID(cfResp.ID),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      cfReq.Model,
+		Content:    []AnthropicContentBlock{},
+		StopReason: anthropicStopReason(choice.FinishReason, matchedStop),
+		Usage: AnthropicUsage{
+			InputTokens:  openaiResp.Usage.PromptTokens,
+			OutputTokens: openaiResp.Usage.CompletionTokens,
+		},
+	}
+	if matchedStop != "" {
+		resp.StopSequence = &matchedStop
+	}
+	if choice.Message.ReasoningContent != "" {
+		resp.Content = append(resp.Content, AnthropicContentBlock{Type: "thinking", Thinking: choice.Message.ReasoningContent})
+	}
+	resp.Content = append(resp.Content, AnthropicContentBlock{Type: "text", Text: text})
+
+	recordUsage(r, cfReq.Model, openaiResp.Usage, nil)
+	if !shared {
+		recordNeurons(r.Context(), cfReq.Model, openaiResp.Usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(resp)
+}
+
+// 按 Anthropic 的 "event: <type>" + "data: {...}" 格式写出 SSE，并跟踪当前打开的内容块
+type anthropicEventWriter struct {
+	w         http.ResponseWriter
+	index     int
+	openBlock string
+	heartbeat *sseHeartbeat
+}
+
+func (a *anthropicEventWriter) event(name string, payload map[string]interface{}) {
+	a.heartbeat.stop()
+	payload["type"] = name
+	fmt.Fprintf(a.w, "event: %s\ndata: ", name)
+	enc := json.NewEncoder(a.w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(payload)
+	a.w.Write([]byte("\n"))
+	a.w.(http.Flusher).Flush()
+}
+
+// 内容块类型变化时先关闭上一个块，再打开新块
+func (a *anthropicEventWriter) delta(blockType, text string) {
+	if a.openBlock != blockType {
+		a.closeBlock()
+		a.openBlock = blockType
+		block := map[string]interface{}{"type": blockType, blockType: ""}
+		a.event("content_block_start", map[string]interface{}{"index": a.index, "content_block": block})
+	}
+	a.event("content_block_delta", map[string]interface{}{
+		"index": a.index,
+		"delta": map[string]interface{}{"type": blockType + "_delta", blockType: text},
+	})
+}
+
+func (a *anthropicEventWriter) closeBlock() {
+	if a.openBlock == "" {
+		return
+	}
+	a.event("content_block_stop", map[string]interface{}{"index": a.index})
+	a.openBlock = ""
+	a.index++
+}
+
+func streamAnthropicMessage(w http.ResponseWriter, r *http.Request, req AnthropicRequest, openaiReq OpenAIRequest, cfReq CloudflareRequest, reqLog *requestLog) {
+	ctx := r.Context()
+	upstreamStart := time.Now()
+	resp, estimated, err := openCloudflareStream(cfReq, ctx)
+	if err != nil {
+		recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		writeAnthropicUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+	defer trackPhase(ctx, "streaming", time.Now())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	out := &anthropicEventWriter{w: w}
+	out.event("message_start", map[string]interface{}{
+		"message": map[string]interface{}{
+			"id":            anthropicMessageID(""),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         cfReq.Model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         AnthropicUsage{InputTokens: countMessageTokens(openaiReq.Messages)},
+		},
+	})
+
+	// Anthropic 的心跳是 ping 事件，客户端会忽略它
+	out.heartbeat = startSSEHeartbeat(w, []byte("event: ping\ndata: {\"type\": \"ping\"}\n\n"))
+	defer out.heartbeat.stop()
+
+	var content strings.Builder
+	var final *CloudflareResponse
+	stops := &stopMatcher{stops: req.StopSequences}
+	readErr := readCloudflareEvents(resp.Body, func(event cloudflareStreamEvent, data string) bool {
+		switch event.Type {
+		case "response.reasoning_text.delta":
+			if openaiReq.ReasoningMode == reasoningContent {
+				out.delta("thinking", event.Delta)
+			}
+		case "response.output_text.delta":
+			if text := stops.feed(event.Delta); text != "" {
+				content.WriteString(text)
+				out.delta("text", text)
+			}
+		case "response.completed", "response.incomplete":
+			if event.Response == nil {
+				event.Response = &CloudflareResponse{}
+			}
+			final = event.Response
+			reqLog.Body(tr("upstream_raw"), data)
+		case "response.failed", "error":
+			err = fmt.Errorf("API stream failed: %s", data)
+		}
+		return final == nil && err == nil && !stops.stopped && ctx.Err() == nil
+	})
+	if err == nil {
+		err = readErr
+	}
+	if ctx.Err() != nil {
+		recordModelResult(cfReq.Model, nil, time.Since(upstreamStart))
+		recordClientDisconnect(ctx, r, reqLog, cfReq.Model, openaiReq.Messages, content.String())
+		return
+	}
+	recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		reqLog.Printf(tr("upstream_raw"), err.Error())
+		status, _, message := upstreamErrorStatus(err)
+		out.event("error", anthropicErrorEnvelope(status, message))
+		return
+	}
+	if stops.stopped {
+		final = &CloudflareResponse{Usage: estimateUsage(openaiReq.Messages, content.String())}
+	} else if text := stops.flush(); text != "" {
+		out.delta("text", text)
+	}
+	out.closeBlock()
+
+	final.Usage = fillMissingUsage(final.Usage, openaiReq.Messages, content.String())
+	usage := Usage{
+		PromptTokens:     final.Usage.PromptTokens,
+		CompletionTokens: final.Usage.CompletionTokens,
+		TotalTokens:      final.Usage.TotalTokens,
+	}
+	reportUpstreamTokens(usage.TotalTokens, estimated)
+	recordUsage(r, cfReq.Model, usage, nil)
+	recordNeurons(ctx, cfReq.Model, usage)
+
+	var stopSequence interface{}
+	if stops.matched != "" {
+		stopSequence = stops.matched
+	}
+	out.event("message_delta", map[string]interface{}{
+		"delta": map[string]interface{}{
+			"stop_reason":   anthropicStopReason(cloudflareFinishReason(final), stops.matched),
+			"stop_sequence": stopSequence,
+		},
+		"usage": AnthropicUsage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens},
+	})
+	out.event("message_stop", map[string]interface{}{})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertAnthropicRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "string system and content",
+			req:  `{"model":"claude","system":"be brief","max_tokens":100,"temperature":0.5,"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"bye"}]}`,
+			want: `{"model":"claude","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"bye"}],
+				"temperature":0.5,"max_tokens":100,"reasoning_mode":"strip"}`,
+		},
+		{
+			name: "content blocks, thinking blocks dropped",
+			req: `{"model":"claude","system":[{"type":"text","text":"a"},{"type":"text","text":"b"}],"max_tokens":10,"stream":true,"thinking":{"type":"enabled"},
+				"messages":[{"role":"user","content":[{"type":"text","text":"q"}]},{"role":"assistant","content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"answer"}]}]}`,
+			want: `{"model":"claude","messages":[{"role":"system","content":"a\nb"},{"role":"user","content":"q"},{"role":"assistant","content":"answer"}],
+				"stream":true,"max_tokens":10,"reasoning_mode":"reasoning_content"}`,
+		},
+		{
+			name:    "max_tokens is required",
+			req:     `{"model":"claude","messages":[{"role":"user","content":"hi"}]}`,
+			wantErr: "max_tokens is required",
+		},
+		{
+			name:    "empty messages",
+			req:     `{"model":"claude","max_tokens":10,"messages":[]}`,
+			wantErr: "messages must not be empty",
+		},
+		{
+			name:    "system role inside messages",
+			req:     `{"model":"claude","max_tokens":10,"messages":[{"role":"system","content":"hi"}]}`,
+			wantErr: "message role must be user or assistant",
+		},
+		{
+			name:    "unsupported content block",
+			req:     `{"model":"claude","max_tokens":10,"messages":[{"role":"user","content":[{"type":"image"}]}]}`,
+			wantErr: "content block type image is not supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req AnthropicRequest
+			if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+				t.Fatal(err)
+			}
+			got, err := convertAnthropicRequest(req)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
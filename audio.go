@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// OpenAI 音频上传上限为 25MB
+const maxAudioUploadSize = 25 << 20
+
+type WhisperWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type WhisperSegment struct {
+	Start            float64       `json:"start"`
+	End              float64       `json:"end"`
+	Text             string        `json:"text"`
+	Temperature      float64       `json:"temperature"`
+	AvgLogprob       float64       `json:"avg_logprob"`
+	CompressionRatio float64       `json:"compression_ratio"`
+	NoSpeechProb     float64       `json:"no_speech_prob"`
+	Words            []WhisperWord `json:"words"`
+}
+
+type WhisperResult struct {
+	Text              string           `json:"text"`
+	WordCount         int              `json:"word_count"`
+	Words             []WhisperWord    `json:"words"`
+	Segments          []WhisperSegment `json:"segments"`
+	VTT               string           `json:"vtt"`
+	TranscriptionInfo struct {
+		Language string  `json:"language"`
+		Duration float64 `json:"duration"`
+	} `json:"transcription_info"`
+}
+
+type VerboseSegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+type VerboseTranscription struct {
+	Task     string           `json:"task"`
+	Language string           `json:"language"`
+	Duration float64          `json:"duration"`
+	Text     string           `json:"text"`
+	Segments []VerboseSegment `json:"segments,omitempty"`
+	Words    []WhisperWord    `json:"words,omitempty"`
+}
+
+func handleAudioTranscriptions(w http.ResponseWriter, r *http.Request) {
+	handleAudio(w, r, "transcribe")
+}
+
+func handleAudioTranslations(w http.ResponseWriter, r *http.Request) {
+	handleAudio(w, r, "translate")
+}
+
+func handleAudio(w http.ResponseWriter, r *http.Request, task string) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxAudioUploadSize); err != nil {
+		if isBodyTooLarge(err) {
+			writeBodyTooLarge(w)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid multipart form")
+		return
+	}
+	audio, err := readFormFile(r, "file")
+	if err != nil || audio == nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "file is required")
+		return
+	}
+
+	format := r.FormValue("response_format")
+	if format == "" {
+		format = "json"
+	}
+	switch format {
+	case "json", "text", "srt", "vtt", "verbose_json":
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request", "response_format must be one of json, text, srt, verbose_json, vtt")
+		return
+	}
+
+	// timestamp_granularities[] 默认只返回 segment 级时间戳
+	granularities := r.MultipartForm.Value["timestamp_granularities[]"]
+	if len(granularities) == 0 {
+		granularities = r.MultipartForm.Value["timestamp_granularities"]
+	}
+	wantWords, wantSegments := false, len(granularities) == 0
+	for _, g := range granularities {
+		switch g {
+		case "word":
+			wantWords = true
+		case "segment":
+			wantSegments = true
+		default:
+			writeError(w, http.StatusBadRequest, "invalid_request", "timestamp_granularities must be word or segment")
+			return
+		}
+	}
+
+	language := r.FormValue("language")
+	if task == "translate" {
+		// 翻译接口固定输出英文，不接受 language 参数
+		language = ""
+	}
+	reqLog.Printf(tr("audio_request"), task, format, language, len(audio))
+
+	if rejectIfCircuitOpen(w) {
+		return
+	}
+
+	model := workersAIModel(r.FormValue("model"), config().AudioModel)
+	if task == "translate" && !whisperSupportsTask(model) {
+		// 旧版 whisper 只会转写原语言，不能冒充翻译结果返回
+		writeErrorParam(w, http.StatusBadRequest, "unsupported_model", fmt.Sprintf("Model %s does not support translation, use @cf/openai/whisper-large-v3-turbo", model), "model")
+		return
+	}
+	payload := convertToCloudflareWhisperRequest(model, audio, task, language, r.FormValue("prompt"))
+	body, _, err := callCloudflareRun(r.Context(), model, payload)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+
+	var cfResp struct {
+		Result WhisperResult `json:"result"`
+	}
+	if err := json.Unmarshal(body, &cfResp); err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	result := cfResp.Result
+
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(strings.TrimSpace(result.Text)))
+	case "srt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(buildSRT(result)))
+	case "vtt":
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		if result.VTT != "" {
+			w.Write([]byte(result.VTT))
+		} else {
+			w.Write([]byte(buildVTT(result)))
+		}
+	case "verbose_json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(convertToVerboseTranscription(result, task, language, wantWords, wantSegments))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(map[string]interface{}{"text": strings.TrimSpace(result.Text)})
+	}
+}
+
+// 只有 whisper-large-v3-turbo 支持 task 参数，旧版 whisper 只能转写原语言
+func whisperSupportsTask(model string) bool {
+	return strings.Contains(model, "turbo")
+}
+
+// whisper-large-v3-turbo 接收 base64 音频并支持 task/language，旧版 whisper 只接收 uint8 数组
+func convertToCloudflareWhisperRequest(model string, audio []byte, task, language, prompt string) map[string]interface{} {
+	if !whisperSupportsTask(model) {
+		return map[string]interface{}{"audio": bytesToIntArray(audio)}
+	}
+
+	payload := map[string]interface{}{
+		"audio": base64.StdEncoding.EncodeToString(audio),
+		"task":  task,
+	}
+	if language != "" {
+		payload["language"] = language
+	}
+	if prompt != "" {
+		payload["initial_prompt"] = prompt
+	}
+	return payload
+}
+
+func convertToVerboseTranscription(result WhisperResult, task, language string, wantWords, wantSegments bool) VerboseTranscription {
+	if result.TranscriptionInfo.Language != "" {
+		language = result.TranscriptionInfo.Language
+	}
+	verbose := VerboseTranscription{
+		Task:     task,
+		Language: language,
+		Duration: result.TranscriptionInfo.Duration,
+		Text:     strings.TrimSpace(result.Text),
+	}
+
+	for i, seg := range result.Segments {
+		if wantSegments {
+			verbose.Segments = append(verbose.Segments, VerboseSegment{
+				ID:               i,
+				Start:            seg.Start,
+				End:              seg.End,
+				Text:             seg.Text,
+				Tokens:           []int{},
+				Temperature:      seg.Temperature,
+				AvgLogprob:       seg.AvgLogprob,
+				CompressionRatio: seg.CompressionRatio,
+				NoSpeechProb:     seg.NoSpeechProb,
+			})
+		}
+		if wantWords {
+			verbose.Words = append(verbose.Words, seg.Words...)
+		}
+		if seg.End > verbose.Duration {
+			verbose.Duration = seg.End
+		}
+	}
+	// 旧版 whisper 没有 segments，只有顶层 words，整段文本作为一个 segment 返回
+	if len(result.Segments) == 0 {
+		if wantWords {
+			verbose.Words = result.Words
+		}
+		if wantSegments && verbose.Text != "" {
+			segment := VerboseSegment{Text: verbose.Text, Tokens: []int{}}
+			if n := len(result.Words); n > 0 {
+				segment.Start, segment.End = result.Words[0].Start, result.Words[n-1].End
+			}
+			verbose.Segments = []VerboseSegment{segment}
+			verbose.Duration = max(verbose.Duration, segment.End)
+		}
+	}
+	return verbose
+}
+
+// 优先使用 segments 生成字幕，没有时退化为按词生成
+func subtitleCues(result WhisperResult) []WhisperWord {
+	var cues []WhisperWord
+	for _, seg := range result.Segments {
+		cues = append(cues, WhisperWord{Word: strings.TrimSpace(seg.Text), Start: seg.Start, End: seg.End})
+	}
+	if len(cues) == 0 {
+		for _, word := range result.Words {
+			cues = append(cues, WhisperWord{Word: strings.TrimSpace(word.Word), Start: word.Start, End: word.End})
+		}
+	}
+	return cues
+}
+
+func buildSRT(result WhisperResult) string {
+	var sb strings.Builder
+	for i, cue := range subtitleCues(result) {
+		fmt.Fprintf(&sb, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","), cue.Word)
+	}
+	return sb.String()
+}
+
+func buildVTT(result WhisperResult) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	for _, cue := range subtitleCues(result) {
+		fmt.Fprintf(&sb, "%s --> %s\n%s\n\n", formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), cue.Word)
+	}
+	return sb.String()
+}
+
+// SRT 使用逗号分隔毫秒，VTT 使用点号
+func formatTimestamp(seconds float64, sep string) string {
+	ms := int(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
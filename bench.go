@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type benchResult struct {
+	latency          time.Duration
+	ttft             time.Duration
+	completionTokens int
+	err              error
+}
+
+// gptoss2api bench：并发压测上游或代理本身，输出延迟分位数、首字延迟和吞吐
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("target", "upstream", "Benchmark Target (upstream or proxy)")
+	proxyURL := fs.String("url", "http://127.0.0.1:10000", "Proxy Base URL (target=proxy)")
+	proxyKey := fs.String("key", "", "Proxy Client Key (target=proxy)")
+	concurrency := fs.Int("c", 4, "Concurrent Requests")
+	total := fs.Int("n", 20, "Total Requests")
+	prompt := fs.String("prompt", "Write a short paragraph about the ocean.", "Prompt")
+	stream := fs.Bool("stream", true, "Use Streaming (target=proxy)")
+	fs.StringVar(&flagValues.AccountID, "id", "", "Cloudflare Account ID")
+	fs.StringVar(&flagValues.Model, "model", "@cf/openai/gpt-oss-120b", "Cloudflare Model")
+	fs.StringVar(&flagValues.AuthToken, "token", "", "Cloudflare Auth Token")
+	fs.Parse(args)
+	credentials.authToken = config().AuthToken
+
+	if *target == "upstream" && config().AuthToken == "" {
+		fmt.Fprintln(os.Stderr, tr("missing_token"))
+		os.Exit(1)
+	}
+
+	jobs := make(chan struct{})
+	results := make(chan benchResult, *total)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				if *target == "proxy" {
+					results <- benchProxy(*proxyURL, *proxyKey, *prompt, *stream)
+				} else {
+					results <- benchUpstream(*prompt)
+				}
+			}
+		}()
+	}
+	for i := 0; i < *total; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+	elapsed := time.Since(start)
+
+	var latencies, ttfts []time.Duration
+	var tokens, failures int
+	for res := range results {
+		if res.err != nil {
+			failures++
+			fmt.Fprintf(os.Stderr, "error: %v\n", res.err)
+			continue
+		}
+		latencies = append(latencies, res.latency)
+		ttfts = append(ttfts, res.ttft)
+		tokens += res.completionTokens
+	}
+
+	fmt.Printf("target=%s model=%s concurrency=%d requests=%d failures=%d elapsed=%s\n",
+		*target, config().Model, *concurrency, *total, failures, elapsed.Round(time.Millisecond))
+	if len(latencies) == 0 {
+		return
+	}
+	fmt.Printf("latency  p50=%s p90=%s p99=%s\n", percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99))
+	fmt.Printf("ttft     p50=%s p90=%s p99=%s\n", percentile(ttfts, 50), percentile(ttfts, 90), percentile(ttfts, 99))
+	fmt.Printf("throughput %.1f req/s, %.1f completion tokens/s\n",
+		float64(len(latencies))/elapsed.Seconds(), float64(tokens)/elapsed.Seconds())
+}
+
+func percentile(values []time.Duration, p int) time.Duration {
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx].Round(time.Millisecond)
+}
+
+// 上游调用不是流式的，首字延迟等于总延迟
+func benchUpstream(prompt string) benchResult {
+	cfReq := CloudflareRequest{
+		Model: config().Model,
+		Input: []map[string]interface{}{{"role": "user", "content": prompt}},
+	}
+	start := time.Now()
+	cfResp, _, err := callCloudflareAPI(cfReq, context.Background())
+	if err != nil {
+		return benchResult{err: err}
+	}
+	latency := time.Since(start)
+	return benchResult{latency: latency, ttft: latency, completionTokens: cfResp.Usage.CompletionTokens}
+}
+
+func benchProxy(baseURL, key, prompt string, stream bool) benchResult {
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"model":    config().Model,
+		"messages": []map[string]interface{}{{"role": "user", "content": prompt}},
+		"stream":   stream,
+	})
+	httpReq, _ := http.NewRequest("POST", strings.TrimRight(baseURL, "/")+"/v1/chat/completions", strings.NewReader(string(reqBody)))
+	httpReq.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return benchResult{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return benchResult{err: fmt.Errorf("proxy returned %s", resp.Status)}
+	}
+
+	if !stream {
+		var openaiResp OpenAIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&openaiResp); err != nil {
+			return benchResult{err: err}
+		}
+		latency := time.Since(start)
+		return benchResult{latency: latency, ttft: latency, completionTokens: openaiResp.Usage.CompletionTokens}
+	}
+
+	var res benchResult
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "data: ")
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+		}
+		if json.Unmarshal([]byte(line), &chunk) != nil {
+			continue
+		}
+		if res.ttft == 0 && len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			res.ttft = time.Since(start)
+		}
+		if chunk.Usage != nil {
+			res.completionTokens = chunk.Usage.CompletionTokens
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return benchResult{err: err}
+	}
+	res.latency = time.Since(start)
+	if res.ttft == 0 {
+		res.ttft = res.latency
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// 每百万 token 消耗的 neuron 数（输入、输出），按 Cloudflare 公布的价格换算
+var modelNeurons = map[string][2]float64{
+	"@cf/openai/gpt-oss-120b": {31818, 68182},
+	"@cf/openai/gpt-oss-20b":  {18182, 27273},
+}
+
+// 按账号统计当天（UTC，与 Cloudflare 每日额度重置时间一致）已消耗的 neuron，
+// 计数保存在共享存储中，多副本部署时整个集群共用同一额度
+func neuronBudgetKey(account string) string {
+	return "neurons:" + account + ":" + time.Now().UTC().Format("2006-01-02")
+}
+
+func accountNeuronLimit(ctx context.Context) float64 {
+	if t := requestTenant(ctx); t != nil && t.NeuronDailyLimit > 0 {
+		return t.NeuronDailyLimit
+	}
+	return config().NeuronDailyLimit
+}
+
+// 账号达到当日额度后停止向其发送请求，直到 UTC 零点重置
+func accountBudgetExhausted(ctx context.Context) bool {
+	return accountBudgetUsedUp(ctx, upstreamAccountID(ctx))
+}
+
+func accountBudgetUsedUp(ctx context.Context, account string) bool {
+	limit := accountNeuronLimit(ctx)
+	if limit <= 0 {
+		return false
+	}
+	value, _, err := store.Get(neuronBudgetKey(account))
+	if err != nil {
+		return false
+	}
+	used, _ := strconv.ParseFloat(value, 64)
+	return used >= limit
+}
+
+func recordNeurons(ctx context.Context, model string, usage Usage) {
+	rate, ok := modelNeurons[model]
+	if !ok {
+		return
+	}
+	neurons := (float64(usage.PromptTokens)*rate[0] + float64(usage.CompletionTokens)*rate[1]) / 1e6
+	account := upstreamAccountID(ctx)
+
+	used, err := store.Incr(neuronBudgetKey(account), neurons, 48*time.Hour)
+	if err != nil {
+		return
+	}
+	metrics.set("gptoss2api_account_neurons_used", used, "account", account)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 非流式响应缓存：相同账号、相同上游请求和相同输出处理参数在 -cache-ttl 内直接返回缓存结果，
+// 不再调用 Cloudflare。使用 Redis 存储时各副本共享缓存，否则使用进程内 LRU
+type cacheEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+type lruCache struct {
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+var responseCache = &lruCache{order: list.New(), items: make(map[string]*list.Element)}
+
+func (c *lruCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *lruCache) set(key, value string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = &cacheEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	for c.order.Len() > max(config().CacheSize, 1) {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func cacheEnabled() bool {
+	return config().CacheTTL > 0
+}
+
+// Cache-Control: no-cache 跳过查找但仍写入新结果，no-store 完全不使用缓存；
+// 无法设置请求头的客户端可以在请求体中用 "cache" 字段传同样的取值，两者任一要求跳过即跳过
+func cacheDirectives(r *http.Request, openaiReq OpenAIRequest) (lookup, save bool) {
+	value := strings.ToLower(r.Header.Get("Cache-Control")) + "," + openaiReq.Cache
+	save = !strings.Contains(value, "no-store")
+	lookup = save && !strings.Contains(value, "no-cache")
+	return lookup, save
+}
+
+func validateCacheDirective(value string) error {
+	switch value {
+	case "", "no-cache", "no-store":
+		return nil
+	}
+	return fmt.Errorf("cache must be no-cache or no-store")
+}
+
+// X-Cache 保留兼容；经过 CDN 时 X-Cache 可能被 CDN 自己的缓存状态覆盖，X-Proxy-Cache 只表示代理的缓存
+func setCacheStatus(w http.ResponseWriter, hit bool) {
+	status := "MISS"
+	if hit {
+		status = "HIT"
+	}
+	w.Header().Set("X-Cache", status)
+	w.Header().Set("X-Proxy-Cache", status)
+}
+
+// 上游请求体已包含模型、消息和采样参数，再加上只在本地生效的推理内容处理方式（按请求和 -reasoning-mode 解析后的结果）、
+// 停止序列和写入缓存结果的页脚，修改 -reasoning-mode 或 -footer 并重新加载后不会返回旧格式的结果。
+// 账号池中的账号可以互换，只区分租户或请求指定的账号；不能用 upstreamAccountID，
+// 它会为查缓存从账号池中选出一个账号，命中缓存时白白占用轮询顺序
+func responseCacheKey(ctx context.Context, openaiReq OpenAIRequest, cfReq CloudflareRequest) string {
+	account := ""
+	if a := accountOverride(ctx); a != nil {
+		account = a.AccountID
+	} else if t := requestTenant(ctx); t != nil {
+		account = t.AccountID
+	}
+	body, _ := json.Marshal(struct {
+		Account       string            `json:"account"`
+		Request       CloudflareRequest `json:"request"`
+		ReasoningMode string            `json:"reasoning_mode"`
+		Stop          StopSequences     `json:"stop"`
+		Footer        string            `json:"footer"`
+	}{account, cfReq, reasoningMode(openaiReq), openaiReq.Stop, config().Footer})
+	sum := sha256.Sum256(body)
+	return "cache:" + hex.EncodeToString(sum[:])
+}
+
+func getCachedResponse(key string) (OpenAIResponse, bool) {
+	var value string
+	var ok bool
+	if store.Shared() {
+		value, ok, _ = store.Get(key)
+	} else {
+		value, ok = responseCache.get(key)
+	}
+	var resp OpenAIResponse
+	if !ok || json.Unmarshal([]byte(value), &resp) != nil || len(resp.Choices) == 0 {
+		metrics.inc("gptoss2api_cache_requests_total", "result", "miss")
+		return OpenAIResponse{}, false
+	}
+	metrics.inc("gptoss2api_cache_requests_total", "result", "hit")
+	return resp, true
+}
+
+func putCachedResponse(key string, resp OpenAIResponse) {
+	data, _ := json.Marshal(resp)
+	if store.Shared() {
+		store.Set(key, string(data), config().CacheTTL)
+		return
+	}
+	responseCache.set(key, string(data), config().CacheTTL)
+}
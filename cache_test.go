@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheDirectives(t *testing.T) {
+	tests := []struct {
+		header, body string
+		lookup, save bool
+	}{
+		{"", "", true, true},
+		{"max-age=0", "", true, true},
+		{"no-cache", "", false, true},
+		{"No-Cache", "", false, true},
+		{"no-store", "", false, false},
+		{"no-cache, no-store", "", false, false},
+		{"", "no-cache", false, true},
+		{"", "no-store", false, false},
+		{"no-cache", "no-store", false, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if tt.header != "" {
+			r.Header.Set("Cache-Control", tt.header)
+		}
+		lookup, save := cacheDirectives(r, OpenAIRequest{Cache: tt.body})
+		if lookup != tt.lookup || save != tt.save {
+			t.Errorf("Cache-Control %q, cache %q: lookup=%v save=%v, want lookup=%v save=%v", tt.header, tt.body, lookup, save, tt.lookup, tt.save)
+		}
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	cfReq := CloudflareRequest{Model: "m", Input: "hi"}
+	base := responseCacheKey(context.Background(), OpenAIRequest{}, cfReq)
+	tests := []struct {
+		name   string
+		req    OpenAIRequest
+		update func(*Config)
+		same   bool
+	}{
+		{"explicit default reasoning mode", OpenAIRequest{ReasoningMode: reasoningThinkTags}, func(c *Config) {}, true},
+		{"reasoning mode from flag", OpenAIRequest{}, func(c *Config) { c.ReasoningMode = reasoningStrip }, false},
+		{"footer", OpenAIRequest{}, func(c *Config) { c.Footer = "AI generated" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.update)
+			if got := responseCacheKey(context.Background(), tt.req, cfReq); (got == base) != tt.same {
+				t.Errorf("key equal to default = %v, want %v", got == base, tt.same)
+			}
+		})
+	}
+}
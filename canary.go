@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// 灰度发布：按比例把流量从当前模型切到新模型，并分别统计错误率和延迟
+func selectModel(ctx context.Context) string {
+	model := upstreamModel(ctx)
+	if config().CanaryModel != "" && config().CanaryPercent > 0 && rand.Float64()*100 < config().CanaryPercent {
+		return config().CanaryModel
+	}
+	return model
+}
+
+// 按模型记录请求结果，便于对比灰度模型和原模型
+func recordModelResult(model string, err error, latency time.Duration) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metrics.inc("gptoss2api_model_requests_total", "model", model, "result", result)
+	if err == nil {
+		metrics.observe("gptoss2api_model_duration_seconds", latency, "model", model)
+		dashboard.recordLatency(latency)
+	}
+}
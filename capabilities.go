@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// 模型能力描述，用于在调用上游前拒绝模型不支持的功能
+type ModelCapabilities struct {
+	Tools         bool `json:"tools"`
+	Vision        bool `json:"vision"`
+	JSONSchema    bool `json:"json_schema"`
+	ContextWindow int  `json:"context_window"`
+}
+
+var modelCapabilities = map[string]ModelCapabilities{
+	"@cf/openai/gpt-oss-120b": {Tools: true, JSONSchema: true, ContextWindow: 128000},
+	"@cf/openai/gpt-oss-20b":  {Tools: true, JSONSchema: true, ContextWindow: 128000},
+	// 图片输入经 -vision-model 处理时使用的默认视觉模型
+	"@cf/meta/llama-3.2-11b-vision-instruct": {Vision: true, ContextWindow: 128000},
+}
+
+// 配置文件中的条目会覆盖内置默认值
+func loadCapabilities() error {
+	if config().CapabilitiesFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(config().CapabilitiesFile)
+	if err != nil {
+		return err
+	}
+	var loaded map[string]ModelCapabilities
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	for model, caps := range loaded {
+		modelCapabilities[model] = caps
+	}
+	return nil
+}
+
+type capabilityError struct {
+	Param   string
+	Message string
+}
+
+func (e *capabilityError) Error() string { return e.Message }
+
+// 未登记能力的模型不做校验，交给上游判断
+func validateCapabilities(model string, body []byte) *capabilityError {
+	caps, ok := modelCapabilities[model]
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Tools     []interface{} `json:"tools"`
+		Functions []interface{} `json:"functions"`
+		Messages  []struct {
+			Content interface{} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	if !caps.Tools && (len(req.Tools) > 0 || len(req.Functions) > 0) {
+		return &capabilityError{"tools", fmt.Sprintf("Model %s does not support tools/function calling", model)}
+	}
+	if !caps.Vision {
+		for i, msg := range req.Messages {
+			parts, _ := msg.Content.([]interface{})
+			for _, part := range parts {
+				if p, _ := part.(map[string]interface{}); p != nil && (p["type"] == "image_url" || p["type"] == "input_image") {
+					return &capabilityError{fmt.Sprintf("messages[%d].content", i), fmt.Sprintf("Model %s does not support image inputs", model)}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	cassetteRecord = "record"
+	cassetteReplay = "replay"
+)
+
+// 录制与回放：record 模式把发往 Cloudflare 的每个请求和完整响应（包括流式事件）写入 -cassette 目录，
+// replay 模式按相同的请求从目录中取出响应，不再访问网络，可以用真实的上游数据为转换逻辑编写回归测试。
+// 文件中不保存请求头，URL 中的账号 ID 替换为 {account}，正文经过与日志相同的密钥脱敏
+type cassette struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   string `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status      int    `json:"status"`
+		ContentType string `json:"content_type"`
+		Body        string `json:"body,omitempty"`
+		// 图片、音频等二进制响应以 base64 保存
+		BodyBase64 string `json:"body_base64,omitempty"`
+	} `json:"response"`
+}
+
+type cassetteTransport struct {
+	next http.RoundTripper
+}
+
+var cassetteAccountPattern = regexp.MustCompile(`^(/client/v4/accounts/|/v1/)[^/]*/`)
+
+func validateCassette() error {
+	switch config().CassetteMode {
+	case "":
+		if config().Cassette != "" {
+			return fmt.Errorf("-cassette requires -cassette-mode=%s or %s", cassetteRecord, cassetteReplay)
+		}
+		return nil
+	case cassetteRecord, cassetteReplay:
+		if config().Cassette == "" {
+			return fmt.Errorf("-cassette-mode requires -cassette")
+		}
+		return os.MkdirAll(config().Cassette, 0700)
+	}
+	return fmt.Errorf("invalid -cassette-mode %q, expected %s or %s", config().CassetteMode, cassetteRecord, cassetteReplay)
+}
+
+// 不同账号录制的请求回放时同样能匹配
+func cassetteURL(req *http.Request) string {
+	url := req.URL.Scheme + "://" + req.URL.Host + cassetteAccountPattern.ReplaceAllString(req.URL.Path, "${1}{account}/")
+	if req.URL.RawQuery != "" {
+		url += "?" + req.URL.RawQuery
+	}
+	return url
+}
+
+func cassettePath(method, url, body string) string {
+	sum := sha256.Sum256([]byte(method + " " + url + "\n" + body))
+	return filepath.Join(config().Cassette, hex.EncodeToString(sum[:12])+".json")
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isCloudflareHost(req.URL.Host) {
+		return t.next.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	var c cassette
+	c.Request.Method = req.Method
+	c.Request.URL = cassetteURL(req)
+	c.Request.Body = redactSecrets(string(body))
+	file := cassettePath(c.Request.Method, c.Request.URL, c.Request.Body)
+
+	if config().CassetteMode == cassetteReplay {
+		return replayCassette(req, file, c.Request.URL)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	c.Response.Status = resp.StatusCode
+	c.Response.ContentType = resp.Header.Get("Content-Type")
+	// 流式响应边转发边记录，读到结尾或结束事件时写入文件；没读完就关闭的响应（如客户端中途断开）不保存
+	resp.Body = &cassetteRecorder{body: resp.Body, cassette: c, file: file}
+	return resp, nil
+}
+
+func replayCassette(req *http.Request, file, url string) (*http.Response, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if strings.HasSuffix(req.URL.Path, "/models/search") {
+			// 健康检查没有录制时视为正常，避免回放时触发熔断
+			return mockJSON(req, map[string]interface{}{"success": true, "result": []interface{}{}}), nil
+		}
+		logf(slog.LevelWarn, tr("cassette_missing"), req.Method, url)
+		return nil, fmt.Errorf("cassette: no recorded response for %s %s", req.Method, url)
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %v", file, err)
+	}
+	body := []byte(c.Response.Body)
+	if c.Response.BodyBase64 != "" {
+		body, _ = base64.StdEncoding.DecodeString(c.Response.BodyBase64)
+	}
+	resp := mockResponse(req, c.Response.ContentType, io.NopCloser(bytes.NewReader(body)))
+	resp.StatusCode = c.Response.Status
+	resp.Status = fmt.Sprintf("%d %s", c.Response.Status, http.StatusText(c.Response.Status))
+	return resp, nil
+}
+
+type cassetteRecorder struct {
+	body     io.ReadCloser
+	buf      bytes.Buffer
+	cassette cassette
+	file     string
+	once     sync.Once
+}
+
+func (r *cassetteRecorder) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.buf.Write(p[:n])
+	if err == io.EOF {
+		r.save()
+	}
+	return n, err
+}
+
+// 流式转发读到 response.completed 等结束事件后就关闭响应，不会读到 EOF，此时同样保存
+func (r *cassetteRecorder) Close() error {
+	if strings.HasPrefix(r.cassette.Response.ContentType, "text/event-stream") && streamFinished(r.buf.Bytes()) {
+		r.save()
+	}
+	return r.body.Close()
+}
+
+func streamFinished(data []byte) bool {
+	for _, event := range []string{"response.completed", "response.incomplete", "response.failed"} {
+		if bytes.Contains(data, []byte(`"type":"`+event+`"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *cassetteRecorder) save() {
+	r.once.Do(func() {
+		c := r.cassette
+		contentType := c.Response.ContentType
+		if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "application/octet-stream") {
+			c.Response.BodyBase64 = base64.StdEncoding.EncodeToString(r.buf.Bytes())
+		} else {
+			c.Response.Body = redactSecrets(r.buf.String())
+		}
+		data, _ := json.MarshalIndent(c, "", "  ")
+		if err := writeFileAtomic(r.file, data); err != nil {
+			logf(slog.LevelWarn, tr("cassette_write_failed"), err)
+		}
+	})
+}
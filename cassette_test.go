@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testdata/cassettes 中是 Cloudflare Responses API 的录制响应，测试离线回放，覆盖从请求转换到响应转换的完整路径。
+// 请求转换改变后文件名（请求的哈希）随之变化，需要用 -cassette-mode=record 重新录制
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("tests must not access the network")
+}
+
+func replayCassettes(t *testing.T) {
+	t.Helper()
+	withConfig(t, func(c *Config) {
+		c.CassetteMode = cassetteReplay
+		c.Cassette = "testdata/cassettes"
+		c.Model = "@cf/openai/gpt-oss-120b"
+		c.ReasoningMode = reasoningThinkTags
+		c.AccountID = "test-account"
+		c.AuthMethods = "bearer"
+		c.MaxChoices = 8
+		c.LogSampleRate = 1
+		c.SystemPromptMode = systemPromptPrepend
+	})
+	upstreamClients.once.Do(func() {})
+	plain, stream := upstreamClients.plain, upstreamClients.stream
+	upstreamClients.plain = &http.Client{Transport: &cassetteTransport{next: offlineTransport{}}}
+	upstreamClients.stream = &http.Client{Transport: &cassetteTransport{next: offlineTransport{}}}
+	t.Cleanup(func() {
+		upstreamClients.plain, upstreamClients.stream = plain, stream
+	})
+}
+
+func TestCassetteReplay(t *testing.T) {
+	replayCassettes(t)
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        []string
+	}{
+		{
+			name:        "chat completion with reasoning",
+			body:        `{"model":"@cf/openai/gpt-oss-120b","messages":[{"role":"user","content":"What is the capital of France?"}]}`,
+			contentType: "application/json",
+			want: []string{
+				`"object":"chat.completion"`,
+				`"content":"<think>The user asks a simple geography question.</think>\nThe capital of France is Paris."`,
+				`"finish_reason":"stop"`,
+				`"usage":{"prompt_tokens":74,"completion_tokens":19,"total_tokens":93}`,
+			},
+		},
+		{
+			name:        "streamed chat completion with usage",
+			body:        `{"model":"@cf/openai/gpt-oss-120b","stream":true,"stream_options":{"include_usage":true},"reasoning_mode":"reasoning_content","messages":[{"role":"user","content":"What is the capital of France?"}]}`,
+			contentType: "text/event-stream",
+			want: []string{
+				`"delta":{"reasoning_content":"The user asks a simple geography question."}`,
+				`"delta":{"content":"The capital"}`,
+				`"delta":{"content":" of France is Paris."}`,
+				`"finish_reason":"stop"`,
+				`"usage":{"completion_tokens":19,"prompt_tokens":74,"total_tokens":93}`,
+				"data: [DONE]",
+			},
+		},
+		{
+			name: "function call",
+			body: `{"model":"@cf/openai/gpt-oss-120b","messages":[{"role":"user","content":"What's the weather in Paris?"}],
+				"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}}}]}`,
+			contentType: "application/json",
+			want: []string{
+				`"content":null`,
+				`"tool_calls":[{"id":"call_8f2c","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]`,
+				`"finish_reason":"tool_calls"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handleChatCompletions(w, r)
+			body := w.Body.String()
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, body)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+				t.Errorf("Content-Type %q, want %q", got, tt.contentType)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("response does not contain %s:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
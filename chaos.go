@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// 故障注入模式：用于让客户端验证重试和流恢复逻辑，生产环境不要开启
+var chaosStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+func chaosEnabled() bool {
+	return config().ChaosLatency > 0 || config().ChaosErrorRate > 0 || config().ChaosDropRate > 0
+}
+
+// 在调用上游前注入随机延迟，并按概率返回合成的上游错误
+func chaosUpstreamFault(ctx context.Context) (int, error) {
+	if config().ChaosLatency > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(config().ChaosLatency)))):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	if config().ChaosErrorRate > 0 && rand.Float64() < config().ChaosErrorRate {
+		status := chaosStatuses[rand.Intn(len(chaosStatuses))]
+		return status, &upstreamError{Status: status, Message: fmt.Sprintf("chaos injected upstream error %d", status)}
+	}
+	return 0, nil
+}
+
+// 返回流式响应中断开连接的数据块序号（小于 length），-1 表示不中断
+func chaosStreamDropPoint(length int) int {
+	if config().ChaosDropRate <= 0 || length == 0 || rand.Float64() >= config().ChaosDropRate {
+		return -1
+	}
+	return rand.Intn(length)
+}
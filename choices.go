@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// n > 1：上游每次只生成一个回复，按 n 并行发起请求，结果按 index 合并为多个选项。
+// 用量为各次请求之和（提示词按 n 次计），与上游实际消耗一致
+func validateChoiceCount(n *int) error {
+	if n == nil {
+		return nil
+	}
+	// 每个选项单独调用上游，同时占用同样多的并发槽位，不能超过 -max-concurrent
+	limit := config().MaxChoices
+	if config().MaxConcurrent > 0 {
+		limit = min(limit, config().MaxConcurrent)
+	}
+	if *n < 1 || *n > limit {
+		return fmt.Errorf("n must be between 1 and %d", limit)
+	}
+	return nil
+}
+
+func choiceCount(openaiReq OpenAIRequest) int {
+	if openaiReq.N == nil {
+		return 1
+	}
+	return *openaiReq.N
+}
+
+// 生成单个选项，主上游失败时按故障转移策略改用备用上游；各选项独立调用，不参与重复请求合并
+func completeChoice(ctx context.Context, openaiReq OpenAIRequest, cfReq CloudflareRequest) (OpenAIResponse, bool, error) {
+	upstreamStart := time.Now()
+	var resp OpenAIResponse
+	err := primaryAvailable()
+	if err == nil {
+		resp, err = defaultProvider.Call(ctx, openaiReq, cfReq.Model)
+		recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+	}
+	if shouldFailover(ctx, err) {
+		openaiReq.N = nil
+		resp, err := callFallbackChat(ctx, openaiReq, cfReq)
+		return resp, false, err
+	}
+	if err != nil {
+		return OpenAIResponse{}, true, err
+	}
+	if isJSONMode(openaiReq) {
+		resp, err = enforceResponseFormat(ctx, defaultProvider, openaiReq, cfReq.Model, resp)
+	}
+	return resp, true, err
+}
+
+func addUsage(total *Usage, usage Usage) {
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+}
+
+// 非流式 n > 1：任一选项失败时整体返回错误，已成功的选项仍计入用量
+func handleMultipleChoices(w http.ResponseWriter, r *http.Request, openaiReq OpenAIRequest, cfReq CloudflareRequest, reqLog *requestLog) {
+	n := choiceCount(openaiReq)
+	results := make([]OpenAIResponse, n)
+	primary := make([]bool, n)
+	errs := make([]error, n)
+	upstreamStart := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], primary[i], errs[i] = completeChoice(r.Context(), openaiReq, cfReq)
+		}(i)
+	}
+	wg.Wait()
+	upstreamLatency := time.Since(upstreamStart)
+
+	var usage, primaryUsage Usage
+	var firstErr error
+	merged := OpenAIResponse{Object: "chat.completion", Model: cfReq.Model, Choices: make([]Choice, 0, n)}
+	for i, resp := range results {
+		addUsage(&usage, resp.Usage)
+		if primary[i] {
+			addUsage(&primaryUsage, resp.Usage)
+		}
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		if merged.ID == "" {
+			merged.ID, merged.Created = resp.ID, resp.Created
+		}
+		choice := resp.Choices[0]
+		choice.Index = i
+		merged.Choices = append(merged.Choices, choice)
+	}
+	recordUsage(r, cfReq.Model, usage, firstErr)
+	recordNeurons(r.Context(), cfReq.Model, primaryUsage)
+	if firstErr != nil {
+		reqLog.Printf(tr("upstream_raw"), firstErr.Error())
+		writeUpstreamError(w, firstErr)
+		return
+	}
+	merged.Usage = usage
+	applyFooter(&merged, openaiReq)
+	if config().Timings {
+		merged.Timings = newTimings(r, upstreamLatency, usage.CompletionTokens)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(merged)
+}
+
+// 流式 n > 1：并行打开 n 个上游流，各选项的数据块按到达顺序交错写出，index 区分选项。
+// 不做故障转移，任一上游流打开失败时整体返回错误
+func streamMultipleChoices(w http.ResponseWriter, r *http.Request, openaiReq OpenAIRequest, cfReq CloudflareRequest, reqLog *requestLog) {
+	ctx := r.Context()
+	n := choiceCount(openaiReq)
+	upstreamStart := time.Now()
+	resps := make([]*http.Response, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i], errs[i] = defaultProvider.Stream(ctx, openaiReq, cfReq.Model)
+		}(i)
+	}
+	wg.Wait()
+	for _, resp := range resps {
+		if resp != nil {
+			defer resp.Body.Close()
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+			recordUsage(r, cfReq.Model, Usage{}, err)
+			writeUpstreamError(w, err)
+			return
+		}
+	}
+	defer trackPhase(ctx, "streaming", time.Now())
+
+	w.Header().Set("X-Upstream-Backend", backendPrimary)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	out := &chunkWriter{
+		w:         w,
+		id:        fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		model:     cfReq.Model,
+		created:   time.Now().Unix(),
+		heartbeat: startSSEHeartbeat(w, ssePing),
+	}
+	defer out.heartbeat.stop()
+	out.includeUsage = wantsStreamUsage(openaiReq)
+	var mu sync.Mutex
+	send := func(index int, delta map[string]interface{}, finishReason interface{}, extra map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() == nil {
+			out.sendChoice(index, delta, finishReason, extra)
+		}
+	}
+
+	usages := make([]Usage, n)
+	contents := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			usages[i], contents[i], errs[i] = relayChoiceStream(ctx, resps[i], i, openaiReq, send, nil)
+			if errs[i] == nil {
+				reportStreamTokens(resps[i], usages[i].TotalTokens)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var usage Usage
+	var streamErr error
+	for i := range usages {
+		addUsage(&usage, usages[i])
+		if errs[i] != nil && streamErr == nil {
+			streamErr = errs[i]
+		}
+	}
+	if ctx.Err() != nil {
+		recordModelResult(cfReq.Model, nil, time.Since(upstreamStart))
+		recordClientDisconnect(ctx, r, reqLog, cfReq.Model, openaiReq.Messages, strings.Join(contents, ""))
+		return
+	}
+	upstreamLatency := time.Since(upstreamStart)
+	recordModelResult(cfReq.Model, streamErr, upstreamLatency)
+	recordUsage(r, cfReq.Model, usage, streamErr)
+	recordNeurons(ctx, cfReq.Model, usage)
+	if streamErr != nil {
+		reqLog.Printf(tr("upstream_raw"), streamErr.Error())
+		status, code, message := upstreamErrorStatus(streamErr)
+		out.write(errorEnvelope(status, code, message, ""))
+		return
+	}
+	if out.includeUsage {
+		out.sendUsage(usage)
+	}
+	w.Write([]byte("data: [DONE]\n\n"))
+	w.(http.Flusher).Flush()
+}
+
+// relayChoiceStream 的可选回调，n = 1 的流式聊天用来更新响应 ID、记录日志和附加耗时统计
+type relayHooks struct {
+	// 每个上游事件在转换之前调用
+	onEvent func(event cloudflareStreamEvent, data string)
+	// 返回结束块中附加的顶层字段
+	finishExtra func(usage Usage) map[string]interface{}
+}
+
+// 转发单个选项的上游流，以该选项的结束块收尾；返回用量和已输出的正文。
+// 推理内容按 reasoning_mode 包在 <think></think> 中、放入 reasoning_content 增量或丢弃。
+// n = 1 和 n > 1 的流式聊天共用这一个转换流程，hooks 可以为 nil
+func relayChoiceStream(ctx context.Context, resp *http.Response, index int, openaiReq OpenAIRequest, send func(int, map[string]interface{}, interface{}, map[string]interface{}), hooks *relayHooks) (Usage, string, error) {
+	var content strings.Builder
+	var final *CloudflareResponse
+	var err error
+	mode := reasoningMode(openaiReq)
+	inReasoning := false
+	stops := &stopMatcher{stops: openaiReq.Stop}
+	chunker := newDeltaChunker(ctx, openaiReq, func(text string) {
+		content.WriteString(text)
+		send(index, map[string]interface{}{"content": text}, nil, nil)
+	})
+	emit := chunker.write
+	tools := newToolCallStreamer()
+	readErr := readCloudflareEvents(resp.Body, func(event cloudflareStreamEvent, data string) bool {
+		if hooks != nil && hooks.onEvent != nil {
+			hooks.onEvent(event, data)
+		}
+		if deltas := tools.event(event); deltas != nil {
+			// 先把缓冲中的文本发出，保持与上游相同的顺序
+			chunker.flush()
+			send(index, map[string]interface{}{"tool_calls": deltas}, nil, nil)
+		}
+		switch event.Type {
+		case "response.reasoning_text.delta":
+			switch mode {
+			case reasoningContent:
+				send(index, map[string]interface{}{"reasoning_content": event.Delta}, nil, nil)
+			case reasoningThinkTags:
+				if !inReasoning {
+					inReasoning = true
+					emit("<think>")
+				}
+				emit(event.Delta)
+			}
+		case "response.output_text.delta":
+			if inReasoning {
+				inReasoning = false
+				emit("</think>\n")
+			}
+			if text := stops.feed(event.Delta); text != "" {
+				emit(text)
+			}
+		case "response.refusal.delta":
+			send(index, map[string]interface{}{"refusal": event.Delta}, nil, nil)
+		case "response.completed", "response.incomplete":
+			if event.Response == nil {
+				event.Response = &CloudflareResponse{}
+			}
+			final = event.Response
+		case "response.failed", "error":
+			err = fmt.Errorf("API stream failed: %s", data)
+		}
+		return final == nil && err == nil && !stops.stopped && ctx.Err() == nil
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err != nil || ctx.Err() != nil {
+		return Usage{}, content.String(), err
+	}
+	if stops.stopped {
+		// 命中停止序列后直接结束，不再等待上游生成剩余内容，用量按估算值记录
+		chunker.flush()
+		final = &CloudflareResponse{Usage: estimateUsage(openaiReq.Messages, content.String())}
+	} else if text := stops.flush(); text != "" {
+		emit(text)
+	}
+	if inReasoning {
+		emit("</think>\n")
+	}
+
+	finishReason := cloudflareFinishReason(final)
+	toolCalls := extractToolCalls(final.Output)
+	if len(toolCalls) == 0 && config().Footer != "" && !isJSONMode(openaiReq) {
+		emit("\n\n" + config().Footer)
+	}
+	chunker.flush()
+	if len(toolCalls) > 0 {
+		finishReason = "tool_calls"
+		if deltas := tools.finish(toolCalls); deltas != nil {
+			send(index, map[string]interface{}{"tool_calls": deltas}, nil, nil)
+		}
+	}
+	final.Usage = fillMissingUsage(final.Usage, openaiReq.Messages, content.String())
+	usage := Usage{
+		PromptTokens:     final.Usage.PromptTokens,
+		CompletionTokens: final.Usage.CompletionTokens,
+		TotalTokens:      final.Usage.TotalTokens,
+	}
+	var extra map[string]interface{}
+	if hooks != nil && hooks.finishExtra != nil {
+		extra = hooks.finishExtra(usage)
+	}
+	send(index, map[string]interface{}{}, finishReason, extra)
+	return usage, content.String(), nil
+}
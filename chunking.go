@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 流式正文的分块方式：upstream 按上游数据块原样转发（默认），rune 每个字符一块，word 每个词一块，
+// 数字 N 每 N 个字符一块，message 整条回复合并为一块在结束时发送
+const (
+	chunkUpstream = "upstream"
+	chunkRune     = "rune"
+	chunkWord     = "word"
+	chunkMessage  = "message"
+)
+
+func validateStreamChunking(chunking string) error {
+	switch chunking {
+	case "", chunkUpstream, chunkRune, chunkWord, chunkMessage:
+		return nil
+	}
+	if n, err := strconv.Atoi(chunking); err == nil && n > 0 {
+		return nil
+	}
+	return fmt.Errorf("stream_chunking must be upstream, rune, word, message or a positive number of characters")
+}
+
+func validateStreamDelay(delayMs *int) error {
+	if delayMs != nil && (*delayMs < 0 || *delayMs > 1000) {
+		return fmt.Errorf("stream_delay_ms must be between 0 and 1000")
+	}
+	return nil
+}
+
+// 重新切分正文增量后交给 out 写出；块与块之间按 delay 间隔，客户端断开时不再等待
+type deltaChunker struct {
+	ctx     context.Context
+	mode    string
+	size    int
+	delay   time.Duration
+	pending strings.Builder
+	sent    bool
+	out     func(string)
+}
+
+// 请求中的 stream_chunking/stream_delay_ms 优先于 -stream-chunking/-stream-delay
+func newDeltaChunker(ctx context.Context, openaiReq OpenAIRequest, out func(string)) *deltaChunker {
+	c := &deltaChunker{ctx: ctx, mode: config().StreamChunking, delay: config().StreamDelay, out: out}
+	if openaiReq.StreamChunking != "" {
+		c.mode = openaiReq.StreamChunking
+	}
+	if openaiReq.StreamDelayMs != nil {
+		c.delay = time.Duration(*openaiReq.StreamDelayMs) * time.Millisecond
+	}
+	if n, err := strconv.Atoi(c.mode); err == nil && n > 0 {
+		c.size = n
+	}
+	return c
+}
+
+func (c *deltaChunker) write(text string) {
+	switch {
+	case c.size > 0:
+		c.pending.WriteString(text)
+		for utf8.RuneCountInString(c.pending.String()) >= c.size {
+			buffered := c.pending.String()
+			cut := 0
+			for i := 0; i < c.size; i++ {
+				_, width := utf8.DecodeRuneInString(buffered[cut:])
+				cut += width
+			}
+			c.pending.Reset()
+			c.pending.WriteString(buffered[cut:])
+			c.emit(buffered[:cut])
+		}
+	case c.mode == chunkRune:
+		for _, r := range text {
+			c.emit(string(r))
+		}
+	case c.mode == chunkWord:
+		// 词与其后的空白一起发送；最后一个词可能还没结束，留到下一段或 flush
+		c.pending.WriteString(text)
+		buffered := c.pending.String()
+		start, inSpace := 0, false
+		for i, r := range buffered {
+			space := unicode.IsSpace(r)
+			if inSpace && !space {
+				c.emit(buffered[start:i])
+				start = i
+			}
+			inSpace = space
+		}
+		c.pending.Reset()
+		c.pending.WriteString(buffered[start:])
+	case c.mode == chunkMessage:
+		c.pending.WriteString(text)
+	default:
+		c.emit(text)
+	}
+}
+
+// 上游结束后发送缓冲中剩余的内容
+func (c *deltaChunker) flush() {
+	if c.pending.Len() > 0 {
+		text := c.pending.String()
+		c.pending.Reset()
+		c.emit(text)
+	}
+}
+
+func (c *deltaChunker) emit(text string) {
+	if text == "" || c.ctx.Err() != nil {
+		return
+	}
+	if c.sent && c.delay > 0 {
+		timer := time.NewTimer(c.delay)
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+	c.sent = true
+	c.out(text)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// 受信任代理和按 IP 的访问控制列表，SIGHUP 重新加载配置时整体替换
+var ipFilters struct {
+	mu      sync.RWMutex
+	trusted []*net.IPNet
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+}
+
+func loadIPFilters() error {
+	trusted, err := parseCIDRList(config().TrustedProxies)
+	if err != nil {
+		return err
+	}
+	allow, err := parseCIDRList(config().IPAllow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseCIDRList(config().IPDeny)
+	if err != nil {
+		return err
+	}
+	ipFilters.mu.Lock()
+	ipFilters.trusted, ipFilters.allow, ipFilters.deny = trusted, allow, deny
+	ipFilters.mu.Unlock()
+	return nil
+}
+
+// 解析逗号分隔的 CIDR 列表，单个 IP 视为 /32 或 /128
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", item, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 只有直连地址属于受信任代理时才采信 X-Forwarded-For/X-Real-IP，
+// X-Forwarded-For 从右向左跳过受信任代理，取第一个不受信任的地址
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil && (host == "" || host == "@") {
+		// Unix 套接字的对端是本机进程，按回环地址处理；经本机反向代理转发时把 127.0.0.1 加入 -trusted-proxies
+		remote = net.IPv4(127, 0, 0, 1)
+	}
+	ipFilters.mu.RLock()
+	trustedProxies := ipFilters.trusted
+	ipFilters.mu.RUnlock()
+	if remote == nil || !ipInNets(remote, trustedProxies) {
+		return remote
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if !ipInNets(ip, trustedProxies) || i == 0 {
+				return ip
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	return remote
+}
+
+// 按 -ip-allow/-ip-deny 过滤请求，判断使用经过受信任代理解析后的客户端地址；
+// 拒绝列表优先，配置了允许列表时不在其中的地址一律拒绝
+func ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ipFilters.mu.RLock()
+		allow, deny := ipFilters.allow, ipFilters.deny
+		ipFilters.mu.RUnlock()
+		if len(allow) == 0 && len(deny) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if ip == nil || ipInNets(ip, deny) || (len(allow) > 0 && !ipInNets(ip, allow)) {
+			metrics.inc("gptoss2api_ip_blocked_total")
+			writeError(w, http.StatusForbidden, "ip_not_allowed", "Access from your IP address is not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
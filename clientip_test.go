@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		{name: "direct connection", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "forwarded headers ignored from untrusted peers", remoteAddr: "203.0.113.7:5000", xff: "198.51.100.1", realIP: "198.51.100.2", want: "203.0.113.7"},
+		{name: "single trusted proxy", trusted: "10.0.0.0/8", remoteAddr: "10.0.0.1:5000", xff: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed left-most hop skipped", trusted: "10.0.0.0/8", remoteAddr: "10.0.0.1:5000", xff: "1.2.3.4, 198.51.100.1, 10.0.0.2", want: "198.51.100.1"},
+		{name: "all hops trusted", trusted: "10.0.0.0/8", remoteAddr: "10.0.0.1:5000", xff: "10.0.0.3, 10.0.0.2", want: "10.0.0.3"},
+		{name: "invalid hop falls back to X-Real-IP", trusted: "10.0.0.0/8", remoteAddr: "10.0.0.1:5000", xff: "garbage", realIP: "198.51.100.2", want: "198.51.100.2"},
+		{name: "trusted proxy without headers", trusted: "10.0.0.1", remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+		{name: "IPv6", trusted: "::1", remoteAddr: "[::1]:5000", xff: "2001:db8::1", want: "2001:db8::1"},
+		{name: "unix socket peer", remoteAddr: "@", want: "127.0.0.1"},
+		{name: "unix socket behind local proxy", trusted: "127.0.0.1", remoteAddr: "", xff: "198.51.100.1", want: "198.51.100.1"},
+	}
+	// 子测试结束后参数已经恢复，按恢复后的参数重新加载
+	t.Cleanup(func() { loadIPFilters() })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.TrustedProxies = tt.trusted })
+			if err := loadIPFilters(); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(r); got.String() != tt.want {
+				t.Errorf("clientIP = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
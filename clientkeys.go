@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// -key 配置的单个共享密钥对应的密钥 ID
+const defaultKeyID = "default"
+
+// 解析 -keys 参数，格式为 "id:key,id:key"
+func parseClientKeys(list string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(item, ":")
+		if !ok || id == "" || key == "" {
+			return nil, fmt.Errorf("invalid -keys entry %q, expected id:key", item)
+		}
+		keys[key] = id
+	}
+	return keys, nil
+}
+
+// 读取 JSON 格式的密钥文件 {"id": "key", ...}，返回 key → id 的映射
+func readClientKeysFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var byID map[string]string
+	if err := json.Unmarshal(data, &byID); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	keys := make(map[string]string, len(byID))
+	for id, key := range byID {
+		if key != "" {
+			keys[key] = id
+		}
+	}
+	return keys, nil
+}
+
+func loadClientKeys() (map[string]string, error) {
+	keys, err := parseClientKeys(config().ClientKeys)
+	if err != nil {
+		return nil, err
+	}
+	if config().KeysFile != "" {
+		fileKeys, err := readClientKeysFile(config().KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, id := range fileKeys {
+			keys[key] = id
+		}
+	}
+	return keys, nil
+}
+
+// 密钥文件变化后整体替换，文件中删除的密钥立即失效
+func reloadClientKeysFile(modTimes map[string]time.Time) {
+	if config().KeysFile == "" {
+		return
+	}
+	info, err := os.Stat(config().KeysFile)
+	if err != nil || info.ModTime().Equal(modTimes[config().KeysFile]) {
+		return
+	}
+	keys, err := loadClientKeys()
+	if err != nil {
+		// 文件写到一半时解析会失败，保留旧密钥等待下一次轮询
+		return
+	}
+	modTimes[config().KeysFile] = info.ModTime()
+
+	credentials.mu.Lock()
+	credentials.clientKeys = keys
+	credentials.mu.Unlock()
+	log.Printf(tr("credential_reloaded"), config().KeysFile)
+}
+
+func clientKeysConfigured() bool {
+	credentials.mu.RLock()
+	defer credentials.mu.RUnlock()
+	return len(credentials.clientKeys) > 0
+}
+
+// 返回密钥对应的 ID，未登记的密钥返回空字符串
+func lookupClientKeyID(key string) string {
+	if key == "" {
+		return ""
+	}
+	credentials.mu.RLock()
+	defer credentials.mu.RUnlock()
+	if id, ok := credentials.clientKeys[key]; ok {
+		return id
+	}
+	if key == credentials.clientKey {
+		return defaultKeyID
+	}
+	return ""
+}
+
+func isClientKeyID(identity string) bool {
+	if identity == defaultKeyID {
+		return true
+	}
+	credentials.mu.RLock()
+	defer credentials.mu.RUnlock()
+	for _, id := range credentials.clientKeys {
+		if id == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// 当前请求所用密钥的 ID，用于日志和统计
+func requestKeyID(r *http.Request) string {
+	return lookupClientKeyID(presentedClientKey(r))
+}
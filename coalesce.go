@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// 合并同时到达的相同非流式请求：第一个请求发起上游调用，其余请求等待并共享结果，
+// 常见于客户端重试和重复提交。上游调用不随任何一个请求取消，由 -upstream-timeout 限制总时长；
+// 每个请求只等待到自己的连接断开为止
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+var inflight = &flightGroup{calls: make(map[string]*flightCall)}
+
+// 返回值 shared 表示结果来自其他请求发起的上游调用
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (interface{}, error)) (value interface{}, shared bool, err error) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if !shared {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go func() {
+			call.value, call.err = fn()
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, shared, call.err
+	case <-ctx.Done():
+		return nil, shared, ctx.Err()
+	}
+}
+
+// 相同账号、相同上游请求体视为同一请求
+func coalesceKey(ctx context.Context, req CloudflareRequest) string {
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(append([]byte(upstreamAccountID(ctx)+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// 上游调用不继承发起者的取消，但保留请求上下文中的账号等信息
+func coalescedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	callCtx := context.WithoutCancel(ctx)
+	if config().UpstreamTimeout > 0 {
+		return context.WithTimeout(callCtx, config().UpstreamTimeout)
+	}
+	return callCtx, func() {}
+}
+
+type cloudflareResult struct {
+	resp *CloudflareResponse
+	raw  string
+}
+
+func callCloudflareAPICoalesced(req CloudflareRequest, ctx context.Context) (*CloudflareResponse, string, bool, error) {
+	if !config().Coalesce {
+		resp, raw, err := callCloudflareAPI(req, ctx)
+		return resp, raw, false, err
+	}
+	value, shared, err := inflight.do(ctx, coalesceKey(ctx, req), func() (interface{}, error) {
+		callCtx, cancel := coalescedContext(ctx)
+		defer cancel()
+		resp, raw, err := callCloudflareAPI(req, callCtx)
+		return cloudflareResult{resp, raw}, err
+	})
+	if shared {
+		metrics.inc("gptoss2api_coalesced_requests_total")
+	}
+	result, _ := value.(cloudflareResult)
+	return result.resp, result.raw, shared, err
+}
+
+// 聊天接口经 Provider 调用上游时的请求合并。共享的是转换后的响应，
+// 键中除了上游请求体还要包含影响转换结果的推理内容处理方式和停止序列
+func providerCoalesceKey(ctx context.Context, p Provider, openaiReq OpenAIRequest, model string) string {
+	body, _ := json.Marshal(struct {
+		Request       interface{}   `json:"request"`
+		ReasoningMode string        `json:"reasoning_mode"`
+		Stop          StopSequences `json:"stop"`
+	}{p.Convert(openaiReq, model), reasoningMode(openaiReq), openaiReq.Stop})
+	sum := sha256.Sum256(append([]byte("chat\n"+upstreamAccountID(ctx)+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+func callProviderCoalesced(ctx context.Context, p Provider, openaiReq OpenAIRequest, model string) (OpenAIResponse, bool, error) {
+	if !config().Coalesce {
+		resp, err := p.Call(ctx, openaiReq, model)
+		return resp, false, err
+	}
+	value, shared, err := inflight.do(ctx, providerCoalesceKey(ctx, p, openaiReq, model), func() (interface{}, error) {
+		callCtx, cancel := coalescedContext(ctx)
+		defer cancel()
+		return p.Call(callCtx, openaiReq, model)
+	})
+	if shared {
+		metrics.inc("gptoss2api_coalesced_requests_total")
+	}
+	resp, _ := value.(OpenAIResponse)
+	// 调用方会就地修改选项（页脚、JSON 修复），每个请求使用自己的副本
+	resp.Choices = append([]Choice(nil), resp.Choices...)
+	return resp, shared, err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProviderCoalesceKey(t *testing.T) {
+	ctx := context.Background()
+	base := OpenAIRequest{Messages: []Message{{Role: "user", Content: "hi"}}}
+	key := providerCoalesceKey(ctx, defaultProvider, base, "m")
+	tests := []struct {
+		name string
+		req  OpenAIRequest
+		same bool
+	}{
+		{"identical request", base, true},
+		{"local stream chunking", OpenAIRequest{Messages: base.Messages, StreamChunking: "word"}, true},
+		{"different reasoning mode", OpenAIRequest{Messages: base.Messages, ReasoningMode: reasoningContent}, false},
+		{"different stop sequences", OpenAIRequest{Messages: base.Messages, Stop: StopSequences{"\n"}}, false},
+		{"different messages", OpenAIRequest{Messages: []Message{{Role: "user", Content: "hello"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := providerCoalesceKey(ctx, defaultProvider, tt.req, "m"); (got == key) != tt.same {
+				t.Errorf("key equal = %v, want %v", got == key, tt.same)
+			}
+		})
+	}
+}
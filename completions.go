@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 旧版文本补全请求，只支持文本 prompt
+type CompletionRequest struct {
+	Model         string          `json:"model"`
+	Prompt        json.RawMessage `json:"prompt"`
+	Suffix        string          `json:"suffix,omitempty"`
+	MaxTokens     *int            `json:"max_tokens,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *StreamOptions  `json:"stream_options,omitempty"`
+	Echo          bool            `json:"echo,omitempty"`
+	Stop          StopSequences   `json:"stop,omitempty"`
+	User          string          `json:"user,omitempty"`
+}
+
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason interface{} `json:"finish_reason"`
+}
+
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+// prompt 可以是字符串或字符串数组，数组中的各段按行拼接为一条用户消息；不支持 token ID 数组
+func completionPrompt(raw json.RawMessage) (string, error) {
+	var prompt string
+	if json.Unmarshal(raw, &prompt) == nil {
+		return prompt, nil
+	}
+	var parts []string
+	if err := json.Unmarshal(raw, &parts); err != nil || len(parts) == 0 {
+		return "", fmt.Errorf("prompt must be a string or an array of strings")
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// gpt-oss 是对话模型，用系统提示要求它续写用户给出的文本；有 suffix 时只输出前后文之间的内容
+func convertCompletionRequest(req CompletionRequest, prompt string) OpenAIRequest {
+	instruction := "Continue the text provided by the user. Output only the continuation, without repeating the text or adding any commentary."
+	if req.Suffix != "" {
+		instruction = "Write the text that belongs between the user's text and the following suffix. Output only the inserted text, without repeating the text or the suffix or adding any commentary.\nSuffix:\n" + req.Suffix
+	}
+	return OpenAIRequest{
+		Model: req.Model,
+		Messages: []Message{
+			{Role: "system", Content: instruction},
+			{Role: "user", Content: prompt},
+		},
+		Stream:        req.Stream,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		MaxTokens:     req.MaxTokens,
+		StreamOptions: req.StreamOptions,
+		Stop:          req.Stop,
+		ReasoningMode: reasoningStrip,
+	}
+}
+
+func completionID(id string) string {
+	if id == "" {
+		id = fmt.Sprint(time.Now().UnixNano())
+	}
+	return "cmpl-" + strings.TrimPrefix(id, "resp_")
+}
+
+// POST /v1/completions：旧版文本补全接口，便于只支持该接口的工具和评测框架使用
+func handleCompletions(w http.ResponseWriter, r *http.Request) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
+		return
+	}
+
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+	reqLog.Body(tr("user_request"), string(body))
+	if config().Lenient {
+		body = normalizeLenientJSON(body)
+	}
+
+	var req CompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	prompt, err := completionPrompt(req.Prompt)
+	if err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "prompt")
+		return
+	}
+	if err := validateStopSequences(req.Stop); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "stop")
+		return
+	}
+
+	setAccountAffinity(r, req.User, []Message{{Role: "user", Content: prompt}})
+
+	if rejectIfCircuitOpen(w) {
+		return
+	}
+	if accountBudgetExhausted(r.Context()) {
+		writeError(w, http.StatusTooManyRequests, "account_budget_exhausted", "Daily neuron allowance for the upstream account is exhausted")
+		return
+	}
+	if req.Stream {
+		key := clientIdentity(r)
+		if !streams.acquire(key) {
+			writeError(w, http.StatusTooManyRequests, "too_many_streams", "Too many concurrent streams for this API key")
+			return
+		}
+		defer streams.release(key)
+	}
+
+	openaiReq := convertCompletionRequest(req, prompt)
+	model := resolveModel(r.Context(), openaiReq.Model)
+	applyModelDefaults(w, openaiReq.Model, model, &openaiReq)
+	applyMaxTokensPolicy(r, &openaiReq)
+	r, model, provider := routeProvider(r, model)
+	if provider != nil {
+		writeErrorParam(w, http.StatusBadRequest, "unsupported_model", fmt.Sprintf("Model %s is only available on /v1/chat/completions", openaiReq.Model), "model")
+		return
+	}
+	cfReq := convertToCloudflareRequest(openaiReq, model)
+	if err := checkContextLength(cfReq.Model, openaiReq); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "context_length_exceeded", err.Error(), "prompt")
+		return
+	}
+
+	if req.Stream {
+		streamCompletion(w, r, req, prompt, openaiReq, cfReq, reqLog)
+		return
+	}
+
+	upstreamStart := time.Now()
+	cfResp, rawCFJSON, shared, err := callCloudflareAPICoalesced(cfReq, r.Context())
+	recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	reqLog.Body(tr("upstream_raw"), rawCFJSON)
+
+	openaiResp := convertToOpenAIResponse(cfResp, openaiReq)
+	text, _ := openaiResp.Choices[0].Message.Content.(string)
+	if req.Echo {
+		text = prompt + text
+	}
+	recordUsage(r, cfReq.Model, openaiResp.Usage, nil)
+	if !shared {
+		recordNeurons(r.Context(), cfReq.Model, openaiResp.Usage)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(CompletionResponse{
+		ID:      completionID(cfResp.ID),
+		Object:  "text_completion",
+		Created: cfResp.Created,
+		Model:   cfReq.Model,
+		Choices: []CompletionChoice{{Text: text, FinishReason: openaiResp.Choices[0].FinishReason}},
+		Usage:   &openaiResp.Usage,
+	})
+}
+
+// 以 text_completion 数据块逐段转发续写内容，echo 时先发送原始 prompt
+func streamCompletion(w http.ResponseWriter, r *http.Request, req CompletionRequest, prompt string, openaiReq OpenAIRequest, cfReq CloudflareRequest, reqLog *requestLog) {
+	ctx := r.Context()
+	upstreamStart := time.Now()
+	resp, estimated, err := openCloudflareStream(cfReq, ctx)
+	if err != nil {
+		recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+	defer trackPhase(ctx, "streaming", time.Now())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	heartbeat := startSSEHeartbeat(w, ssePing)
+	defer heartbeat.stop()
+	chunk := CompletionResponse{ID: completionID(""), Object: "text_completion", Created: time.Now().Unix(), Model: cfReq.Model}
+	write := func(choices []CompletionChoice, usage *Usage) {
+		chunk.Choices = choices
+		chunk.Usage = usage
+		heartbeat.stop()
+		w.Write([]byte("data: "))
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(chunk)
+		w.Write([]byte("\n"))
+		w.(http.Flusher).Flush()
+	}
+	emit := func(text string, finishReason interface{}) {
+		write([]CompletionChoice{{Text: text, FinishReason: finishReason}}, nil)
+	}
+	if req.Echo {
+		emit(prompt, nil)
+	}
+
+	var content strings.Builder
+	var final *CloudflareResponse
+	stops := &stopMatcher{stops: req.Stop}
+	readErr := readCloudflareEvents(resp.Body, func(event cloudflareStreamEvent, data string) bool {
+		switch event.Type {
+		case "response.output_text.delta":
+			if text := stops.feed(event.Delta); text != "" {
+				content.WriteString(text)
+				emit(text, nil)
+			}
+		case "response.completed", "response.incomplete":
+			if event.Response == nil {
+				event.Response = &CloudflareResponse{}
+			}
+			final = event.Response
+			reqLog.Body(tr("upstream_raw"), data)
+		case "response.failed", "error":
+			err = fmt.Errorf("API stream failed: %s", data)
+		}
+		return final == nil && err == nil && !stops.stopped && ctx.Err() == nil
+	})
+	if err == nil {
+		err = readErr
+	}
+	if ctx.Err() != nil {
+		recordModelResult(cfReq.Model, nil, time.Since(upstreamStart))
+		recordClientDisconnect(ctx, r, reqLog, cfReq.Model, openaiReq.Messages, content.String())
+		return
+	}
+	recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		reqLog.Printf(tr("upstream_raw"), err.Error())
+		status, code, message := upstreamErrorStatus(err)
+		heartbeat.stop()
+		w.Write([]byte("data: "))
+		json.NewEncoder(w).Encode(errorEnvelope(status, code, message, ""))
+		w.Write([]byte("\n"))
+		w.(http.Flusher).Flush()
+		return
+	}
+	finishReason := "stop"
+	if stops.stopped {
+		final = &CloudflareResponse{Usage: estimateUsage(openaiReq.Messages, content.String())}
+	} else {
+		if text := stops.flush(); text != "" {
+			emit(text, nil)
+		}
+		finishReason = cloudflareFinishReason(final)
+	}
+
+	final.Usage = fillMissingUsage(final.Usage, openaiReq.Messages, content.String())
+	usage := Usage{
+		PromptTokens:     final.Usage.PromptTokens,
+		CompletionTokens: final.Usage.CompletionTokens,
+		TotalTokens:      final.Usage.TotalTokens,
+	}
+	reportUpstreamTokens(usage.TotalTokens, estimated)
+	recordUsage(r, cfReq.Model, usage, nil)
+	recordNeurons(ctx, cfReq.Model, usage)
+
+	emit("", finishReason)
+	if includeUsage {
+		write([]CompletionChoice{}, &usage)
+	}
+	heartbeat.stop()
+	w.Write([]byte("data: [DONE]\n\n"))
+	w.(http.Flusher).Flush()
+}
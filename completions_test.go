@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCompletionPrompt(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: `"once upon a time"`, want: "once upon a time"},
+		{raw: `["line one","line two"]`, want: "line one\nline two"},
+		{raw: `[]`, wantErr: true},
+		{raw: `[1,2,3]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := completionPrompt(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("completionPrompt(%s) = %q, %v", tt.raw, got, err)
+		}
+	}
+}
+
+func TestConvertCompletionRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        string
+		wantSystem string
+		want       string
+	}{
+		{
+			name:       "continuation",
+			req:        `{"model":"gpt-oss-120b","prompt":"x","max_tokens":16,"temperature":0,"stream":true,"stream_options":{"include_usage":true},"stop":"\n"}`,
+			wantSystem: "Continue the text provided by the user.",
+			want:       `{"model":"gpt-oss-120b","messages":null,"stream":true,"temperature":0,"max_tokens":16,"stream_options":{"include_usage":true},"stop":["\n"],"reasoning_mode":"strip"}`,
+		},
+		{
+			name:       "insertion with suffix",
+			req:        `{"model":"gpt-oss-120b","prompt":"x","suffix":"}\n"}`,
+			wantSystem: "Write the text that belongs between the user's text and the following suffix.",
+			want:       `{"model":"gpt-oss-120b","messages":null,"reasoning_mode":"strip"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req CompletionRequest
+			if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+				t.Fatal(err)
+			}
+			got := convertCompletionRequest(req, "def main():")
+			if len(got.Messages) != 2 || got.Messages[1].Role != "user" || got.Messages[1].Content != "def main():" {
+				t.Fatalf("unexpected messages %+v", got.Messages)
+			}
+			system, _ := got.Messages[0].Content.(string)
+			if got.Messages[0].Role != "system" || !strings.HasPrefix(system, tt.wantSystem) {
+				t.Errorf("system message %q, want prefix %q", system, tt.wantSystem)
+			}
+			if req.Suffix != "" && !strings.HasSuffix(system, "\nSuffix:\n"+req.Suffix) {
+				t.Errorf("system message %q does not end with the suffix", system)
+			}
+			got.Messages = nil
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 实例级的并发上限：同时调用上游的请求超过 -max-concurrent 时按到达顺序排队，
+// 队列超过 -queue-size 或等待超过 -queue-timeout 时返回 429。n > 1 的聊天请求会并行调用上游 n 次，
+// 一次占用 n 个槽位，全部空出时才轮到它，避免多个请求各持有一部分槽位互相等待
+type concurrencyLimiter struct {
+	mu      sync.Mutex
+	active  int
+	waiters *list.List
+	// 最近的请求占用槽位的平均时长（指数加权），用于估算排队等待时间
+	avgHold time.Duration
+}
+
+// 排队中的请求当前的位置（从 1 开始）和预计等待时间
+type queueStatus struct {
+	Position int
+	Wait     time.Duration
+}
+
+var upstreamConcurrency = &concurrencyLimiter{waiters: list.New()}
+
+type queueWaiter struct {
+	ready chan struct{}
+	slots int
+}
+
+var (
+	errQueueFull    = fmt.Errorf("queue full")
+	errQueueTimeout = fmt.Errorf("queue timeout")
+)
+
+// 需要排队时，onWait 在入队时调用一次，之后每隔 -queue-status-interval 调用一次，均在调用方的 goroutine 中执行
+func (l *concurrencyLimiter) acquire(ctx context.Context, slots int, onWait func(queueStatus)) error {
+	l.mu.Lock()
+	if l.active+slots <= config().MaxConcurrent && l.waiters.Len() == 0 {
+		l.active += slots
+		l.updateMetricsLocked()
+		l.mu.Unlock()
+		return nil
+	}
+	if l.waiters.Len() >= config().QueueSize {
+		l.mu.Unlock()
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	el := l.waiters.PushBack(&queueWaiter{ready: ready, slots: slots})
+	status := l.statusLocked(el)
+	l.updateMetricsLocked()
+	l.mu.Unlock()
+
+	defer trackPhase(ctx, "queue", time.Now())
+	if onWait != nil {
+		onWait(status)
+	}
+	var tick <-chan time.Time
+	if onWait != nil && config().QueueStatusInterval > 0 {
+		ticker := time.NewTicker(config().QueueStatusInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	timer := time.NewTimer(config().QueueTimeout)
+	defer timer.Stop()
+	var err error
+wait:
+	for {
+		select {
+		case <-ready:
+			return nil
+		case <-tick:
+			l.mu.Lock()
+			status := l.statusLocked(el)
+			l.mu.Unlock()
+			onWait(status)
+		case <-timer.C:
+			err = errQueueTimeout
+			break wait
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// 超时的同时刚好轮到本请求，槽位已经分配过来，需要归还
+		l.releaseLocked(slots)
+	default:
+		l.waiters.Remove(el)
+		// 队首离开后，后面占用槽位较少的请求可能已经可以开始
+		l.grantLocked()
+	}
+	return err
+}
+
+// held 为本次占用槽位的时长
+func (l *concurrencyLimiter) release(slots int, held time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.avgHold == 0 {
+		l.avgHold = held
+	} else {
+		l.avgHold += (held - l.avgHold) / 8
+	}
+	l.releaseLocked(slots)
+}
+
+// 排在前面的每个请求都要等一个槽位空出，-max-concurrent 个槽位按平均占用时长轮转。
+// 已经轮到的请求不在队列中，返回位置 0
+func (l *concurrencyLimiter) statusLocked(el *list.Element) queueStatus {
+	position := 1
+	for e := l.waiters.Front(); e != el; e = e.Next() {
+		if e == nil {
+			return queueStatus{}
+		}
+		position++
+	}
+	slots := time.Duration(max(config().MaxConcurrent, 1))
+	return queueStatus{Position: position, Wait: time.Duration(position) * l.avgHold / slots}
+}
+
+func (l *concurrencyLimiter) releaseLocked(slots int) {
+	l.active -= slots
+	l.grantLocked()
+}
+
+// 按到达顺序把空出的槽位分给队首，队首需要的槽位不够时后面的请求继续等待
+func (l *concurrencyLimiter) grantLocked() {
+	for front := l.waiters.Front(); front != nil; front = l.waiters.Front() {
+		waiter := front.Value.(*queueWaiter)
+		if l.active+waiter.slots > config().MaxConcurrent && l.active > 0 {
+			break
+		}
+		l.waiters.Remove(front)
+		l.active += waiter.slots
+		close(waiter.ready)
+	}
+	l.updateMetricsLocked()
+}
+
+func (l *concurrencyLimiter) updateMetricsLocked() {
+	metrics.set("gptoss2api_concurrent_requests", float64(l.active))
+	metrics.set("gptoss2api_queue_depth", float64(l.waiters.Len()))
+}
+
+// 包装会调用上游的接口；-max-concurrent 为 0 时不限制。按 IP 限流也在这里检查，
+// 被拒绝的请求不占用排队位置；未通过认证的请求直接交给处理函数按各自接口的格式返回 401，同样不排队。
+// 排过队的请求在响应头中带上入队时的位置和预计等待时间；设置了 -queue-status-interval 时，
+// 流式请求在排队期间就开始 SSE 响应，定期发送 queue_status 事件
+func limitConcurrency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rejectIfIPLimited(w, r) {
+			return
+		}
+		if config().MaxConcurrent <= 0 || !authorizeClient(r) {
+			next(w, r)
+			return
+		}
+		stream, choices := peekRequestOptions(r)
+		// n 超过 -max-concurrent 的请求由处理函数返回 400，这里只需保证不会永远等待
+		slots := min(max(choices, 1), config().MaxConcurrent)
+		var queued *queueStreamWriter
+		if config().QueueStatusInterval > 0 && stream {
+			queued = &queueStreamWriter{ResponseWriter: w}
+		}
+		first := true
+		err := upstreamConcurrency.acquire(r.Context(), slots, func(status queueStatus) {
+			if first {
+				first = false
+				w.Header().Set("X-Queue-Position", strconv.Itoa(status.Position))
+				w.Header().Set("X-Queue-Estimated-Wait-Ms", strconv.FormatInt(status.Wait.Milliseconds(), 10))
+			}
+			if queued != nil {
+				queued.sendStatus(status)
+			}
+		})
+		if queued != nil && queued.started {
+			w = queued
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			reason := "full"
+			if err == errQueueTimeout {
+				reason = "timeout"
+			}
+			metrics.inc("gptoss2api_queue_rejected_total", "reason", reason)
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, "server_busy", "Too many concurrent requests, please retry shortly")
+			return
+		}
+		start := time.Now()
+		defer func() { upstreamConcurrency.release(slots, time.Since(start)) }()
+		next(w, r)
+	}
+}
+
+// 只读取请求体中的 stream 和 n 字段，读到的内容放回请求体；multipart 请求（音频、图片编辑）不判断。
+// 字段按宽松的方式读取（"n": "2" 也算），与 -lenient 处理后的请求一致；
+// 读取出错（例如超过 -max-body-size）时同样放回，由处理函数报告
+func peekRequestOptions(r *http.Request) (stream bool, n int) {
+	if r.Body == nil || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return false, 1
+	}
+	body, err := io.ReadAll(r.Body)
+	rest := io.Reader(bytes.NewReader(body))
+	if err != nil {
+		rest = io.MultiReader(rest, errReader{err})
+	}
+	r.Body = io.NopCloser(rest)
+	var fields map[string]json.RawMessage
+	if err != nil || json.Unmarshal(body, &fields) != nil {
+		return false, 1
+	}
+	unquote := func(raw json.RawMessage) string {
+		return strings.Trim(string(raw), `"`)
+	}
+	stream = unquote(fields["stream"]) == "true"
+	n, err = strconv.Atoi(unquote(fields["n"]))
+	if err != nil {
+		n = 1
+	}
+	return stream, n
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// 排队期间已经以 200 和 text/event-stream 发出了响应头。之后处理函数写出的状态码被忽略，
+// 错误响应（writeError 一次写出的 JSON）改为 SSE 数据块，与流式响应中途出错时的格式一致
+type queueStreamWriter struct {
+	http.ResponseWriter
+	started bool
+	status  int
+}
+
+func (q *queueStreamWriter) sendStatus(status queueStatus) {
+	if !q.started {
+		q.started = true
+		h := q.ResponseWriter.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		q.ResponseWriter.WriteHeader(http.StatusOK)
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":              "queue_status",
+		"position":          status.Position,
+		"estimated_wait_ms": status.Wait.Milliseconds(),
+	})
+	fmt.Fprintf(q.ResponseWriter, "event: queue_status\ndata: %s\n\n", data)
+	q.Flush()
+}
+
+func (q *queueStreamWriter) WriteHeader(code int) {
+	if q.status == 0 {
+		q.status = code
+	}
+}
+
+func (q *queueStreamWriter) Write(b []byte) (int, error) {
+	if q.status < http.StatusBadRequest {
+		return q.ResponseWriter.Write(b)
+	}
+	if _, err := fmt.Fprintf(q.ResponseWriter, "data: %s\n\n", bytes.TrimSpace(b)); err != nil {
+		return 0, err
+	}
+	q.Flush()
+	return len(b), nil
+}
+
+func (q *queueStreamWriter) Flush() {
+	if f, ok := q.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 配置文件中更易读的键名，对应到命令行参数名
+var configKeyAliases = map[string]string{
+	"account-id": "id",
+	"auth-token": "token",
+	"client-key": "key",
+}
+
+// 环境变量名：GPTOSS2API_ 加上大写的参数名，连字符换成下划线，例如 -redis-addr 对应 GPTOSS2API_REDIS_ADDR
+func configEnvName(flagName string) string {
+	return "GPTOSS2API_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// 命令行中出现过的参数，在第一次应用配置时记录；之后 flag.Set 设置的参数也会被 flag.Visit 遍历，
+// 重新加载配置时不能再用它区分
+var commandLineFlags map[string]bool
+
+// 按"命令行参数 > 环境变量 > 配置文件 > 默认值"的优先级补全未在命令行指定的参数
+func applyConfigSources(configPath string) error {
+	if commandLineFlags == nil {
+		commandLineFlags = make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			commandLineFlags[f.Name] = true
+		})
+	}
+	explicit := commandLineFlags
+
+	if configPath == "" {
+		configPath = os.Getenv(configEnvName("config"))
+		flagValues.ConfigFile = configPath
+	}
+	if configPath != "" {
+		values, err := readConfigFile(configPath)
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+			if alias, ok := configKeyAliases[name]; ok {
+				name = alias
+			}
+			if flag.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("%s: unknown config key %q", configPath, key)
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("%s: invalid value for %q: %v", configPath, key, err)
+			}
+		}
+	}
+
+	var envErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		if !ok || explicit[f.Name] || f.Name == "config" || envErr != nil {
+			return
+		}
+		if err := flag.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("%s: %v", configEnvName(f.Name), err)
+		}
+	})
+	return envErr
+}
+
+// 读取 JSON 或 YAML 配置文件，返回参数名到字符串值的映射；列表值以逗号连接
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSONConfig(data)
+	case ".yaml", ".yml":
+		return parseYAMLConfig(data)
+	}
+	return nil, fmt.Errorf("%s: unsupported config format (use .json, .yaml or .yml)", path)
+}
+
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("config key %q: nested objects are not supported", key)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// 只支持扁平的 "key: value" 和 "- item" 列表，足以表达所有命令行参数，
+// 不引入第三方 YAML 库
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	var listKey string
+	var list []string
+	flushList := func() {
+		if listKey != "" {
+			values[listKey] = strings.Join(list, ",")
+		}
+		listKey, list = "", nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := stripYAMLComment(scanner.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			list = append(list, unquoteYAML(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+		if line != trimmed && (line[0] == ' ' || line[0] == '\t') {
+			return nil, fmt.Errorf("line %d: nested values are not supported", lineNo)
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNo)
+		}
+		flushList()
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if value == "" {
+			// 值为空时后续行可能是列表
+			listKey = key
+			continue
+		}
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			var items []string
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, unquoteYAML(item))
+				}
+			}
+			value = strings.Join(items, ",")
+		} else {
+			value = unquoteYAML(value)
+		}
+		values[key] = value
+	}
+	flushList()
+	return values, scanner.Err()
+}
+
+// 去掉行尾注释，引号内的 # 保留
+func stripYAMLComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteYAML(value string) string {
+	if len(value) >= 2 {
+		if value[0] == '"' && value[len(value)-1] == '"' {
+			// 双引号内支持 \" 和 \\ 等转义，与写回配置文件时的引用方式一致
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+			return value[1 : len(value)-1]
+		}
+		if value[0] == '\'' && value[len(value)-1] == '\'' {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
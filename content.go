@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpenAI 的内容分段格式：content 为 [{"type":"text","text":"..."}, {"type":"image_url",...}] 数组
+var textPartTypes = map[string]bool{"text": true, "input_text": true, "output_text": true, "refusal": true}
+var imagePartTypes = map[string]bool{"image_url": true, "input_image": true}
+
+var messageRoles = map[string]bool{"system": true, "developer": true, "user": true, "assistant": true, "tool": true, "function": true}
+
+// messages 不能为空，角色必须是 OpenAI 定义的几种之一
+func validateMessages(messages []Message) (string, error) {
+	if len(messages) == 0 {
+		return "messages", fmt.Errorf("messages must be a non-empty array")
+	}
+	for i, msg := range messages {
+		if !messageRoles[msg.Role] {
+			return fmt.Sprintf("messages[%d].role", i), fmt.Errorf("invalid role %q, expected one of system, developer, user, assistant, tool", msg.Role)
+		}
+	}
+	return "", nil
+}
+
+// 调用上游前检查内容分段，返回出错的参数路径；图片是否可用由模型能力校验决定
+func validateContentParts(messages []Message) (string, error) {
+	for i, msg := range messages {
+		switch content := msg.Content.(type) {
+		case nil, string:
+		case []interface{}:
+			for j, part := range content {
+				param := fmt.Sprintf("messages[%d].content[%d]", i, j)
+				p, _ := part.(map[string]interface{})
+				if p == nil {
+					return param, fmt.Errorf("content parts must be objects with a type")
+				}
+				partType, _ := p["type"].(string)
+				switch {
+				case textPartTypes[partType]:
+					if _, ok := partText(p); !ok {
+						return param, fmt.Errorf("content part of type %s requires a text string", partType)
+					}
+				case imagePartTypes[partType]:
+					if partImageURL(p) == "" {
+						return param, fmt.Errorf("content part of type %s requires an image url", partType)
+					}
+				default:
+					return param, fmt.Errorf("content part type %q is not supported", partType)
+				}
+			}
+		default:
+			return fmt.Sprintf("messages[%d].content", i), fmt.Errorf("content must be a string or an array of content parts")
+		}
+	}
+	return "", nil
+}
+
+func partText(p map[string]interface{}) (string, bool) {
+	if p["type"] == "refusal" {
+		text, ok := p["refusal"].(string)
+		return text, ok
+	}
+	text, ok := p["text"].(string)
+	return text, ok
+}
+
+// image_url 既可以是字符串，也可以是 {"url": "..."} 对象
+func partImageURL(p map[string]interface{}) string {
+	switch v := p["image_url"].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		url, _ := v["url"].(string)
+		return url
+	}
+	return ""
+}
+
+// 只含文本的分段拼接为字符串；含图片时转换为 Responses API 的 input_text/input_image 分段
+func normalizeContent(content interface{}) interface{} {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return content
+	}
+	var texts []string
+	var items []map[string]interface{}
+	hasImage := false
+	for _, part := range parts {
+		p, _ := part.(map[string]interface{})
+		if p == nil {
+			continue
+		}
+		if partType, _ := p["type"].(string); imagePartTypes[partType] {
+			hasImage = true
+			item := map[string]interface{}{"type": "input_image", "image_url": partImageURL(p)}
+			if image, ok := p["image_url"].(map[string]interface{}); ok && image["detail"] != nil {
+				item["detail"] = image["detail"]
+			} else if p["detail"] != nil {
+				item["detail"] = p["detail"]
+			}
+			items = append(items, item)
+			continue
+		}
+		text, _ := partText(p)
+		texts = append(texts, text)
+		items = append(items, map[string]interface{}{"type": "input_text", "text": text})
+	}
+	if hasImage {
+		return items
+	}
+	return strings.Join(texts, "\n")
+}
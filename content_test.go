@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "string", content: `"hi"`, want: `"hi"`},
+		{name: "text parts are joined", content: `[{"type":"text","text":"first"},{"type":"input_text","text":"second"}]`, want: `"first\nsecond"`},
+		{
+			name:    "image parts keep the order",
+			content: `[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"https://example.com/a.png","detail":"low"}}]`,
+			want:    `[{"type":"input_text","text":"what is this?"},{"type":"input_image","image_url":"https://example.com/a.png","detail":"low"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var content interface{}
+			if err := json.Unmarshal([]byte(tt.content), &content); err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, normalizeContent(content), tt.want)
+		})
+	}
+}
+
+func TestValidateContentParts(t *testing.T) {
+	tests := []struct {
+		content   string
+		wantParam string
+	}{
+		{content: `"hi"`},
+		{content: `[{"type":"text","text":"hi"},{"type":"image_url","image_url":"data:image/png;base64,AA=="}]`},
+		{content: `[{"type":"text"}]`, wantParam: "messages[0].content[0]"},
+		{content: `[{"type":"text","text":"a"},{"type":"image_url","image_url":{}}]`, wantParam: "messages[0].content[1]"},
+		{content: `[{"type":"audio"}]`, wantParam: "messages[0].content[0]"},
+		{content: `["hi"]`, wantParam: "messages[0].content[0]"},
+		{content: `42`, wantParam: "messages[0].content"},
+	}
+	for _, tt := range tests {
+		var content interface{}
+		json.Unmarshal([]byte(tt.content), &content)
+		param, err := validateContentParts([]Message{{Role: "user", Content: content}})
+		if param != tt.wantParam || (err != nil) != (tt.wantParam != "") {
+			t.Errorf("content %s: got %q, %v; want %q", tt.content, param, err, tt.wantParam)
+		}
+	}
+}
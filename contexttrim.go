@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contextTrimOff       = "off"
+	contextTrimOldest    = "oldest"
+	contextTrimSummarize = "summarize"
+)
+
+// 摘要本身的输出上限，避免摘要调用占用过多额度
+const contextSummaryMaxTokens = 512
+
+func validateContextTrim(mode string) error {
+	switch mode {
+	case contextTrimOff, contextTrimOldest, contextTrimSummarize:
+		return nil
+	}
+	return fmt.Errorf("invalid -context-trim %q, expected %s, %s or %s", mode, contextTrimOff, contextTrimOldest, contextTrimSummarize)
+}
+
+// 对话超出模型上下文窗口（扣除 max_tokens）时，从最早的非 system 消息开始删除，直到放得下为止；
+// 最后一条消息始终保留。summarize 模式下被删除的消息先交给模型压缩成一条摘要，摘要失败时退化为直接删除。
+// 删除的消息数通过 X-Context-Trimmed 响应头告知客户端；仍然放不下时交给 checkContextLength 返回 400
+func trimContext(w http.ResponseWriter, r *http.Request, model string, openaiReq *OpenAIRequest) {
+	if config().ContextTrim == contextTrimOff {
+		return
+	}
+	caps, ok := modelCapabilities[model]
+	if !ok || caps.ContextWindow <= 0 {
+		return
+	}
+	budget := caps.ContextWindow
+	if openaiReq.MaxCompletionTokens != nil {
+		budget -= *openaiReq.MaxCompletionTokens
+	} else if openaiReq.MaxTokens != nil {
+		budget -= *openaiReq.MaxTokens
+	}
+	if countMessageTokens(openaiReq.Messages) <= budget {
+		return
+	}
+
+	messages := openaiReq.Messages
+	var dropped []Message
+	for countMessageTokens(messages) > budget {
+		i := oldestTrimmable(messages)
+		if i < 0 {
+			break
+		}
+		dropped = append(dropped, messages[i])
+		messages = append(messages[:i:i], messages[i+1:]...)
+		// 对应的函数调用被删除后，孤立的工具结果也一并删除
+		for i < len(messages)-1 && messages[i].Role == "tool" {
+			dropped = append(dropped, messages[i])
+			messages = append(messages[:i:i], messages[i+1:]...)
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+
+	if config().ContextTrim == contextTrimSummarize {
+		if summary := summarizeMessages(r.Context(), model, dropped); summary != "" {
+			withSummary := insertAfterSystem(messages, Message{Role: "system", Content: "Summary of the earlier conversation:\n" + summary})
+			if countMessageTokens(withSummary) <= budget {
+				messages = withSummary
+			}
+		}
+	}
+	openaiReq.Messages = messages
+	w.Header().Set("X-Context-Trimmed", strconv.Itoa(len(dropped)))
+	metrics.inc("gptoss2api_context_trimmed_total", "mode", config().ContextTrim)
+}
+
+func oldestTrimmable(messages []Message) int {
+	for i, msg := range messages[:len(messages)-1] {
+		if msg.Role != "system" && msg.Role != "developer" {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertAfterSystem(messages []Message, msg Message) []Message {
+	i := 0
+	for i < len(messages) && (messages[i].Role == "system" || messages[i].Role == "developer") {
+		i++
+	}
+	result := append([]Message{}, messages[:i]...)
+	result = append(result, msg)
+	return append(result, messages[i:]...)
+}
+
+func summarizeMessages(ctx context.Context, model string, messages []Message) string {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		text, ok := normalizeContent(msg.Content).(string)
+		if !ok {
+			text = "[non-text content]"
+		}
+		for _, call := range msg.ToolCalls {
+			text += fmt.Sprintf("\n[called %s(%s)]", call.Function.Name, call.Function.Arguments)
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, text)
+	}
+	maxTokens := contextSummaryMaxTokens
+	req := OpenAIRequest{
+		Messages: []Message{
+			{Role: "system", Content: "Summarize the following conversation excerpt. Keep facts, decisions, names and open questions the rest of the conversation may depend on. Output only the summary."},
+			{Role: "user", Content: transcript.String()},
+		},
+		MaxTokens:     &maxTokens,
+		ReasoningMode: reasoningStrip,
+	}
+	cfResp, _, err := callCloudflareAPI(convertToCloudflareRequest(req, model), ctx)
+	if err != nil {
+		logf(slog.LevelWarn, tr("context_summary_failed"), err)
+		return ""
+	}
+	resp := convertToOpenAIResponse(cfResp, req)
+	recordNeurons(ctx, model, resp.Usage)
+	summary, _ := resp.Choices[0].Message.Content.(string)
+	return strings.TrimSpace(summary)
+}
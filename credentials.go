@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 运行时可替换的凭据，请求路径只通过 currentAuthToken/currentClientKey 读取
+var credentials struct {
+	mu        sync.RWMutex
+	authToken string
+	clientKey string
+	// 具名客户端密钥，key → id
+	clientKeys map[string]string
+}
+
+func currentAuthToken() string {
+	credentials.mu.RLock()
+	defer credentials.mu.RUnlock()
+	return credentials.authToken
+}
+
+func currentClientKey() string {
+	credentials.mu.RLock()
+	defer credentials.mu.RUnlock()
+	return credentials.clientKey
+}
+
+// 以命令行参数初始化，指定了文件时以文件内容为准；重新加载配置时也会调用，全部读取成功后才替换
+func initCredentials() error {
+	authToken, clientKey := config().AuthToken, config().ClientKey
+	if config().TokenFile != "" {
+		token, err := readCredentialFile(config().TokenFile)
+		if err != nil {
+			return err
+		}
+		authToken = token
+	}
+	if config().KeyFile != "" {
+		key, err := readCredentialFile(config().KeyFile)
+		if err != nil {
+			return err
+		}
+		clientKey = key
+	}
+	keys, err := loadClientKeys()
+	if err != nil {
+		return err
+	}
+	credentials.mu.Lock()
+	credentials.authToken = authToken
+	credentials.clientKey = clientKey
+	credentials.clientKeys = keys
+	credentials.mu.Unlock()
+	return nil
+}
+
+func readCredentialFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// 轮询文件修改时间，变化后原子替换内存中的凭据，无需重启
+func watchCredentialFiles() {
+	if config().TokenFile == "" && config().KeyFile == "" && config().KeysFile == "" {
+		return
+	}
+	go func() {
+		modTimes := map[string]time.Time{}
+		for _, path := range []string{config().TokenFile, config().KeyFile, config().KeysFile} {
+			if info, err := os.Stat(path); err == nil {
+				modTimes[path] = info.ModTime()
+			}
+		}
+		for {
+			time.Sleep(config().CredentialPoll)
+			reloadCredentialFile(config().TokenFile, modTimes, &credentials.authToken)
+			reloadCredentialFile(config().KeyFile, modTimes, &credentials.clientKey)
+			reloadClientKeysFile(modTimes)
+		}
+	}()
+}
+
+func reloadCredentialFile(path string, modTimes map[string]time.Time, target *string) {
+	if path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.ModTime().Equal(modTimes[path]) {
+		return
+	}
+	value, err := readCredentialFile(path)
+	// 轮换过程中文件可能短暂为空，此时保留旧凭据
+	if err != nil || value == "" {
+		return
+	}
+	modTimes[path] = info.ModTime()
+
+	credentials.mu.Lock()
+	*target = value
+	credentials.mu.Unlock()
+	log.Printf(tr("credential_reloaded"), path)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 管理面板使用的按分钟聚合数据，只保存在内存中，最多保留 24 小时；
+// 需要长期数据时仍应使用 -usage-file 或 /metrics
+const (
+	dashboardMinutes  = 24 * 60
+	dashboardTopKeys  = 8
+	dashboardOtherKey = "other"
+)
+
+type dashboardBucket struct {
+	Minute       int64
+	Requests     int
+	Errors       int
+	Tokens       map[string]int
+	LatencySum   time.Duration
+	LatencyCount int
+	LatencyMax   time.Duration
+}
+
+type dashboardSeries struct {
+	mu      sync.Mutex
+	buckets []dashboardBucket
+}
+
+var dashboard = &dashboardSeries{buckets: make([]dashboardBucket, dashboardMinutes)}
+
+// 环形缓冲区按分钟取模定位，槽位中是更早的数据时先清空
+func (d *dashboardSeries) bucketLocked(now time.Time) *dashboardBucket {
+	minute := now.Unix() / 60
+	b := &d.buckets[minute%dashboardMinutes]
+	if b.Minute != minute {
+		*b = dashboardBucket{Minute: minute}
+	}
+	return b
+}
+
+func (d *dashboardSeries) recordRequest(key string, tokens int, failed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := d.bucketLocked(time.Now())
+	b.Requests++
+	if failed {
+		b.Errors++
+	}
+	if tokens > 0 {
+		if b.Tokens == nil {
+			b.Tokens = make(map[string]int)
+		}
+		b.Tokens[key] += tokens
+	}
+}
+
+func (d *dashboardSeries) recordLatency(latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := d.bucketLocked(time.Now())
+	b.LatencySum += latency
+	b.LatencyCount++
+	if latency > b.LatencyMax {
+		b.LatencyMax = latency
+	}
+}
+
+type dashboardView struct {
+	Minutes      []int64          `json:"minutes"`
+	Requests     []int            `json:"requests"`
+	Errors       []int            `json:"errors"`
+	ErrorRate    []float64        `json:"error_rate"`
+	LatencyMs    []int64          `json:"latency_ms"`
+	LatencyMaxMs []int64          `json:"latency_max_ms"`
+	Tokens       map[string][]int `json:"tokens"`
+}
+
+// 按时间顺序返回最近 minutes 分钟的数据，没有请求的分钟补零；
+// 按 token 用量只保留前几个密钥，其余合并为 other
+func (d *dashboardSeries) view(now time.Time, minutes int) dashboardView {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	end := now.Unix() / 60
+	view := dashboardView{Tokens: make(map[string][]int)}
+	totals := make(map[string]int)
+	var window []*dashboardBucket
+	for minute := end - int64(minutes) + 1; minute <= end; minute++ {
+		b := &d.buckets[(minute%dashboardMinutes+dashboardMinutes)%dashboardMinutes]
+		if b.Minute != minute {
+			b = &dashboardBucket{Minute: minute}
+		}
+		window = append(window, b)
+		for key, tokens := range b.Tokens {
+			totals[key] += tokens
+		}
+	}
+
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	top := make(map[string]bool)
+	for i, key := range keys {
+		if i < dashboardTopKeys {
+			top[key] = true
+			view.Tokens[key] = make([]int, len(window))
+		} else if view.Tokens[dashboardOtherKey] == nil {
+			view.Tokens[dashboardOtherKey] = make([]int, len(window))
+		}
+	}
+
+	for i, b := range window {
+		view.Minutes = append(view.Minutes, b.Minute*60)
+		view.Requests = append(view.Requests, b.Requests)
+		view.Errors = append(view.Errors, b.Errors)
+		rate := 0.0
+		if b.Requests > 0 {
+			rate = float64(b.Errors) / float64(b.Requests)
+		}
+		view.ErrorRate = append(view.ErrorRate, rate)
+		var avg time.Duration
+		if b.LatencyCount > 0 {
+			avg = b.LatencySum / time.Duration(b.LatencyCount)
+		}
+		view.LatencyMs = append(view.LatencyMs, avg.Milliseconds())
+		view.LatencyMaxMs = append(view.LatencyMaxMs, b.LatencyMax.Milliseconds())
+		for key, tokens := range b.Tokens {
+			if top[key] {
+				view.Tokens[key][i] += tokens
+			} else {
+				view.Tokens[dashboardOtherKey][i] += tokens
+			}
+		}
+	}
+	return view
+}
+
+// GET /admin/dashboard：管理面板页面。页面本身不需要认证，管理密钥在页面中填写后
+// 保存在浏览器的 localStorage 中，用于请求 /admin/dashboard/data
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if config().AdminKey == "" {
+		writeError(w, http.StatusNotFound, "not_found", "Admin endpoints are disabled")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write([]byte(dashboardHTML))
+}
+
+// GET /admin/dashboard/data?minutes=60：面板使用的时间序列，minutes 最大 1440
+func handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	if !adminPreamble(w, r, http.MethodGet) {
+		return
+	}
+	minutes := 60
+	if value := r.URL.Query().Get("minutes"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > dashboardMinutes {
+			writeErrorParam(w, http.StatusBadRequest, "invalid_request", "minutes must be between 1 and "+strconv.Itoa(dashboardMinutes), "minutes")
+			return
+		}
+		minutes = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(dashboard.view(time.Now(), minutes))
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>gptoss2api dashboard</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; background: #f6f6f6; }
+header { display: flex; flex-wrap: wrap; gap: 12px; align-items: center; padding: 10px 16px; border-bottom: 1px solid #ddd; background: #fafafa; }
+header input[type=password] { width: 220px; }
+main { display: grid; grid-template-columns: repeat(auto-fit, minmax(420px, 1fr)); gap: 16px; padding: 16px; }
+section { background: #fff; border: 1px solid #ddd; border-radius: 6px; padding: 12px; }
+h2 { font-size: 14px; margin: 0 0 8px; }
+svg { width: 100%; height: 180px; }
+.legend { font-size: 12px; display: flex; flex-wrap: wrap; gap: 10px; margin-top: 6px; }
+.legend span::before { content: ""; display: inline-block; width: 10px; height: 10px; margin-right: 4px; background: var(--c); }
+.error { color: #b00020; padding: 0 16px; }
+</style>
+</head>
+<body>
+<header>
+  <strong>gptoss2api dashboard</strong>
+  <label>Admin key <input type="password" id="key" placeholder="admin key"></label>
+  <label>Range <select id="range">
+    <option value="60">1 hour</option>
+    <option value="360">6 hours</option>
+    <option value="1440">24 hours</option>
+  </select></label>
+  <span id="updated"></span>
+</header>
+<p class="error" id="error"></p>
+<main>
+  <section><h2>Requests / min</h2><svg id="requests"></svg><div class="legend" id="requests-legend"></div></section>
+  <section><h2>Error rate</h2><svg id="errors"></svg><div class="legend" id="errors-legend"></div></section>
+  <section><h2>Upstream latency (ms)</h2><svg id="latency"></svg><div class="legend" id="latency-legend"></div></section>
+  <section><h2>Tokens / min per key</h2><svg id="tokens"></svg><div class="legend" id="tokens-legend"></div></section>
+</main>
+<script>
+const $ = id => document.getElementById(id);
+const colors = ["#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#17becf"];
+
+$("key").value = localStorage.getItem("gptoss2api-admin-key") || "";
+$("key").onchange = () => { localStorage.setItem("gptoss2api-admin-key", $("key").value); refresh(); };
+$("range").onchange = refresh;
+
+function fmt(v) {
+  if (v >= 1e6) return (v / 1e6).toFixed(1) + "M";
+  if (v >= 1e3) return (v / 1e3).toFixed(1) + "k";
+  return Number.isInteger(v) ? String(v) : v.toFixed(2);
+}
+
+function chart(id, minutes, series) {
+  const svg = $(id), w = 600, h = 180, pad = 36;
+  svg.setAttribute("viewBox", "0 0 " + w + " " + h);
+  const max = Math.max(1e-9, ...series.flatMap(s => s.values));
+  const x = i => pad + (w - pad - 8) * (minutes.length > 1 ? i / (minutes.length - 1) : 0);
+  const y = v => h - 20 - (h - 30) * v / max;
+  let out = "<line x1='" + pad + "' y1='" + (h - 20) + "' x2='" + w + "' y2='" + (h - 20) + "' stroke='#ccc'/>";
+  out += "<text x='2' y='14' font-size='11' fill='#666'>" + fmt(max) + "</text>";
+  out += "<text x='2' y='" + (h - 20) + "' font-size='11' fill='#666'>0</text>";
+  [0, minutes.length - 1].forEach((i, n) => {
+    const t = new Date(minutes[i] * 1000).toLocaleTimeString([], {hour: "2-digit", minute: "2-digit"});
+    out += "<text x='" + x(i) + "' y='" + (h - 4) + "' font-size='11' fill='#666' text-anchor='" + (n ? "end" : "start") + "'>" + t + "</text>";
+  });
+  const legend = [];
+  series.forEach((s, n) => {
+    const c = colors[n % colors.length];
+    const points = s.values.map((v, i) => x(i).toFixed(1) + "," + y(v).toFixed(1)).join(" ");
+    out += "<polyline fill='none' stroke='" + c + "' stroke-width='1.5' points='" + points + "'/>";
+    const span = document.createElement("span");
+    span.style.setProperty("--c", c);
+    span.textContent = s.name + " (" + s.summary + ")";
+    legend.push(span);
+  });
+  svg.innerHTML = out;
+  $(id + "-legend").replaceChildren(...legend);
+}
+
+const sum = values => values.reduce((a, b) => a + b, 0);
+
+async function refresh() {
+  $("error").textContent = "";
+  try {
+    const resp = await fetch("/admin/dashboard/data?minutes=" + $("range").value, {headers: {"Authorization": "Bearer " + $("key").value}});
+    const data = await resp.json();
+    if (!resp.ok) throw new Error(data.error ? data.error.message : resp.statusText);
+    const requests = sum(data.requests), errors = sum(data.errors);
+    chart("requests", data.minutes, [
+      {name: "requests", values: data.requests, summary: fmt(requests) + " total"},
+      {name: "errors", values: data.errors, summary: fmt(errors) + " total"},
+    ]);
+    chart("errors", data.minutes, [
+      {name: "error rate", values: data.error_rate, summary: (requests ? 100 * errors / requests : 0).toFixed(1) + "% overall"},
+    ]);
+    chart("latency", data.minutes, [
+      {name: "avg", values: data.latency_ms, summary: "peak " + fmt(Math.max(0, ...data.latency_ms))},
+      {name: "max", values: data.latency_max_ms, summary: "peak " + fmt(Math.max(0, ...data.latency_max_ms))},
+    ]);
+    chart("tokens", data.minutes, Object.keys(data.tokens)
+      .sort((a, b) => sum(data.tokens[b]) - sum(data.tokens[a]))
+      .map(key => ({name: key, values: data.tokens[key], summary: fmt(sum(data.tokens[key]))})));
+    $("updated").textContent = "updated " + new Date().toLocaleTimeString();
+  } catch (e) {
+    $("error").textContent = e.message;
+  }
+}
+
+refresh();
+setInterval(refresh, 30000);
+</script>
+</body>
+</html>
+`
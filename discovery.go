@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 模型搜索接口每页最多返回的条数和最多翻页数
+const (
+	discoveryPageSize = 100
+	discoveryMaxPages = 10
+)
+
+// 设置 -model-discovery-ttl 后，/v1/models 通过 Cloudflare 的模型搜索接口列出账号可用的文本生成模型，
+// 结果缓存 TTL 时长；刷新失败时继续使用上一次的列表
+type discoveredModel struct {
+	ID            string
+	Description   string
+	ContextWindow int
+	Tools         bool
+}
+
+var modelDiscovery struct {
+	mu      sync.RWMutex
+	models  map[string]discoveredModel
+	order   []string
+	fetched time.Time
+	refresh sync.Mutex
+}
+
+type cloudflareModelSearch struct {
+	Result []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Properties  []struct {
+			PropertyID string      `json:"property_id"`
+			Value      interface{} `json:"value"`
+		} `json:"properties"`
+	} `json:"result"`
+}
+
+func fetchDiscoveredModels(ctx context.Context) (map[string]discoveredModel, []string, error) {
+	models := make(map[string]discoveredModel)
+	var order []string
+	for page := 1; page <= discoveryMaxPages; page++ {
+		query := url.Values{"task": {"Text Generation"}, "per_page": {strconv.Itoa(discoveryPageSize)}, "page": {strconv.Itoa(page)}}
+		httpReq, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/ai/models/search?%s", config().AccountID, query.Encode()), nil)
+		httpReq.Header.Set("Authorization", "Bearer "+currentAuthToken())
+		resp, err := upstreamHTTPClient(false).Do(httpReq)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, newUpstreamError(resp, body)
+		}
+		var search cloudflareModelSearch
+		if err := json.Unmarshal(body, &search); err != nil {
+			return nil, nil, err
+		}
+		for _, m := range search.Result {
+			model := discoveredModel{ID: m.Name, Description: m.Description}
+			for _, p := range m.Properties {
+				value := fmt.Sprint(p.Value)
+				switch p.PropertyID {
+				case "context_window", "max_input_tokens":
+					if n, err := strconv.Atoi(value); err == nil && model.ContextWindow == 0 {
+						model.ContextWindow = n
+					}
+				case "function_calling":
+					model.Tools = value == "true"
+				}
+			}
+			if _, ok := models[model.ID]; !ok {
+				order = append(order, model.ID)
+			}
+			models[model.ID] = model
+		}
+		if len(search.Result) < discoveryPageSize {
+			break
+		}
+	}
+	return models, order, nil
+}
+
+// 缓存过期时同步刷新一次；同一时间只有一个请求在刷新，其他请求使用旧列表
+func discoveredModels(ctx context.Context) ([]discoveredModel, bool) {
+	if config().ModelDiscoveryTTL <= 0 {
+		return nil, false
+	}
+	modelDiscovery.mu.RLock()
+	stale := time.Since(modelDiscovery.fetched) > config().ModelDiscoveryTTL
+	modelDiscovery.mu.RUnlock()
+	if stale && modelDiscovery.refresh.TryLock() {
+		models, order, err := fetchDiscoveredModels(ctx)
+		modelDiscovery.mu.Lock()
+		if err != nil {
+			logf(slog.LevelWarn, tr("model_discovery_failed"), err)
+		} else {
+			modelDiscovery.models, modelDiscovery.order = models, order
+		}
+		// 失败时同样推迟下一次刷新，避免每个请求都去访问出错的接口
+		modelDiscovery.fetched = time.Now()
+		modelDiscovery.mu.Unlock()
+		modelDiscovery.refresh.Unlock()
+	}
+
+	modelDiscovery.mu.RLock()
+	defer modelDiscovery.mu.RUnlock()
+	list := make([]discoveredModel, 0, len(modelDiscovery.order))
+	for _, id := range modelDiscovery.order {
+		list = append(list, modelDiscovery.models[id])
+	}
+	return list, true
+}
+
+// 只查已缓存的列表，不触发刷新，供每个请求的模型选择使用
+func isDiscoveredModel(model string) bool {
+	if config().ModelDiscoveryTTL <= 0 {
+		return false
+	}
+	modelDiscovery.mu.RLock()
+	defer modelDiscovery.mu.RUnlock()
+	_, ok := modelDiscovery.models[model]
+	return ok
+}
+
+// OpenAI 的模型对象，额外附带已知的上下文窗口和能力信息
+func modelObject(id string, discovered *discoveredModel) map[string]interface{} {
+	object := map[string]interface{}{
+		"id":       id,
+		"object":   "model",
+		"created":  time.Now().Unix(),
+		"owned_by": "openai",
+	}
+	target := id
+	if alias, ok := lookupModelAlias(id); ok {
+		target = alias
+		object["root"] = alias
+	}
+	if caps, ok := modelCapabilities[target]; ok {
+		object["context_window"] = caps.ContextWindow
+		object["capabilities"] = map[string]bool{"tools": caps.Tools, "vision": caps.Vision, "json_schema": caps.JSONSchema}
+	} else if discovered != nil {
+		object["context_window"] = discovered.ContextWindow
+		object["capabilities"] = map[string]bool{"tools": discovered.Tools}
+	}
+	if discovered != nil {
+		object["owned_by"] = "cloudflare"
+		object["description"] = discovered.Description
+	}
+	return object
+}
+
+// GET /v1/models/{id}：模型 ID 中可能含有斜杠（如 @cf/openai/gpt-oss-120b），取前缀之后的全部路径
+func handleModelDetail(w http.ResponseWriter, r *http.Request) {
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	id := r.URL.Path[len(apiPath("/v1/models/")):]
+	for _, known := range listModelIDs(r.Context()) {
+		if known == id {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(modelObject(id, nil))
+			return
+		}
+	}
+	discovered, _ := discoveredModels(r.Context())
+	for _, m := range discovered {
+		if m.ID == id {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(modelObject(id, &m))
+			return
+		}
+	}
+	writeErrorParam(w, http.StatusNotFound, "model_not_found", fmt.Sprintf("The model '%s' does not exist", id), "model")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// OpenAI 单次请求最多 2048 条输入；Cloudflare BGE 模型单次最多 100 条，超出时分批调用
+const (
+	maxEmbeddingInputs     = 2048
+	embeddingUpstreamBatch = 100
+)
+
+type EmbeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	EncodingFormat string          `json:"encoding_format,omitempty"`
+	Dimensions     *int            `json:"dimensions,omitempty"`
+	User           string          `json:"user,omitempty"`
+}
+
+type EmbeddingData struct {
+	Object    string      `json:"object"`
+	Index     int         `json:"index"`
+	Embedding interface{} `json:"embedding"`
+}
+
+type EmbeddingResponse struct {
+	Object string          `json:"object"`
+	Data   []EmbeddingData `json:"data"`
+	Model  string          `json:"model"`
+	Usage  Usage           `json:"usage"`
+}
+
+func handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
+		return
+	}
+
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+	var embReq EmbeddingRequest
+	if err := json.Unmarshal(body, &embReq); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	inputs, err := parseEmbeddingInput(embReq.Input)
+	if err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "input")
+		return
+	}
+	format := embReq.EncodingFormat
+	if format == "" {
+		format = "float"
+	}
+	if format != "float" && format != "base64" {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", "encoding_format must be float or base64", "encoding_format")
+		return
+	}
+
+	model := embeddingModel(embReq.Model)
+	reqLog.Printf(tr("embedding_request"), model, len(inputs))
+
+	if rejectIfCircuitOpen(w) {
+		return
+	}
+
+	vectors := make([][]float64, 0, len(inputs))
+	for start := 0; start < len(inputs); start += embeddingUpstreamBatch {
+		end := min(start+embeddingUpstreamBatch, len(inputs))
+		batch, err := callCloudflareEmbeddings(r, model, inputs[start:end])
+		if err != nil {
+			recordUsage(r, model, Usage{}, err)
+			writeUpstreamError(w, err)
+			return
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	// Cloudflare 不返回 token 用量，按输入文本估算
+	promptTokens := 0
+	for _, input := range inputs {
+		promptTokens += estimateTextTokens(input)
+	}
+	resp := EmbeddingResponse{
+		Object: "list",
+		Data:   make([]EmbeddingData, len(vectors)),
+		Model:  model,
+		Usage:  Usage{PromptTokens: promptTokens, TotalTokens: promptTokens},
+	}
+	for i, vector := range vectors {
+		if embReq.Dimensions != nil && *embReq.Dimensions > 0 && *embReq.Dimensions < len(vector) {
+			vector = truncateEmbedding(vector, *embReq.Dimensions)
+		}
+		resp.Data[i] = EmbeddingData{Object: "embedding", Index: i, Embedding: vector}
+		if format == "base64" {
+			resp.Data[i].Embedding = encodeEmbeddingBase64(vector)
+		}
+	}
+	recordUsage(r, model, resp.Usage, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// input 可以是字符串或字符串数组；token 数组形式的输入 Cloudflare 无法处理
+func parseEmbeddingInput(raw json.RawMessage) ([]string, error) {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		if single == "" {
+			return nil, fmt.Errorf("input must not be empty")
+		}
+		return []string{single}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of strings (token arrays are not supported)")
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("input must not be empty")
+	}
+	if len(list) > maxEmbeddingInputs {
+		return nil, fmt.Errorf("input accepts at most %d items", maxEmbeddingInputs)
+	}
+	for _, item := range list {
+		if item == "" {
+			return nil, fmt.Errorf("input must not contain empty strings")
+		}
+	}
+	return list, nil
+}
+
+// @cf/ 开头的模型名原样使用，其他名称（例如 text-embedding-3-small）使用 -embedding-model
+func embeddingModel(requested string) string {
+	if strings.HasPrefix(requested, "@cf/") {
+		return requested
+	}
+	return config().EmbeddingModel
+}
+
+func callCloudflareEmbeddings(r *http.Request, model string, inputs []string) ([][]float64, error) {
+	body, _, err := callCloudflareRun(r.Context(), model, map[string]interface{}{"text": inputs})
+	if err != nil {
+		return nil, err
+	}
+	var cfResp struct {
+		Result struct {
+			Data [][]float64 `json:"data"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &cfResp); err != nil {
+		return nil, err
+	}
+	if len(cfResp.Result.Data) != len(inputs) {
+		return nil, fmt.Errorf("upstream returned %d embeddings for %d inputs", len(cfResp.Result.Data), len(inputs))
+	}
+	return cfResp.Result.Data, nil
+}
+
+// 与 OpenAI 的 dimensions 一致：截取前 n 维后重新归一化
+func truncateEmbedding(vector []float64, n int) []float64 {
+	truncated := vector[:n]
+	var norm float64
+	for _, v := range truncated {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return truncated
+	}
+	out := make([]float64, n)
+	for i, v := range truncated {
+		out[i] = v / norm
+	}
+	return out
+}
+
+// base64 格式为小端 float32 数组，与 OpenAI SDK 的解码方式一致
+func encodeEmbeddingBase64(vector []float64) string {
+	var buf bytes.Buffer
+	for _, v := range vector {
+		binary.Write(&buf, binary.LittleEndian, float32(v))
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 与 OpenAI 一致的错误类型，按 HTTP 状态码划分
+func errorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
+
+func errorEnvelope(status int, code, message, param string) map[string]interface{} {
+	var paramValue interface{}
+	if param != "" {
+		paramValue = param
+	}
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errorType(status),
+			"code":    code,
+			"param":   paramValue,
+		},
+	}
+}
+
+// 返回给客户端的错误始终使用英文，采用 OpenAI 的 {"error": {...}} 格式，
+// 并在 X-Error-Code 中附带机器可读的错误码
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorParam(w, status, code, message, "")
+}
+
+// param 指出导致错误的请求字段
+func writeErrorParam(w http.ResponseWriter, status int, code, message, param string) {
+	w.Header().Set("X-Error-Code", code)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorEnvelope(status, code, message, param))
+}
+
+// 上游返回的非 200 响应，保留状态码和 Cloudflare 的错误信息
+type upstreamError struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("API request failed (%d): %s", e.Status, e.Message)
+}
+
+// 从 Cloudflare 的 {"errors": [{"message"}]} 或 OpenAI 风格的 {"error": {"message"}} 中提取错误信息
+func newUpstreamError(resp *http.Response, body []byte) *upstreamError {
+	status := resp.StatusCode
+	var parsed struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := string(body)
+	if json.Unmarshal(body, &parsed) == nil {
+		if len(parsed.Errors) > 0 && parsed.Errors[0].Message != "" {
+			message = parsed.Errors[0].Message
+		} else if parsed.Error.Message != "" {
+			message = parsed.Error.Message
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return &upstreamError{Status: status, Message: message, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// 把上游错误映射为对应的状态码：429 和其他 4xx、5xx 原样返回；
+// 401/403 说明代理自身的 Cloudflare 凭据有问题，不是客户端的错，返回 502
+func upstreamErrorStatus(err error) (int, string, string) {
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		switch {
+		case upErr.Status == http.StatusUnauthorized || upErr.Status == http.StatusForbidden:
+			return http.StatusBadGateway, "upstream_auth_error", "Cloudflare API error: " + upErr.Message
+		case upErr.Status == http.StatusTooManyRequests:
+			return http.StatusTooManyRequests, "rate_limit_exceeded", "Cloudflare API error: " + upErr.Message
+		case upErr.Status >= 400:
+			return upErr.Status, "upstream_error", "Cloudflare API error: " + upErr.Message
+		}
+	}
+	if isUpstreamTimeout(err) {
+		return http.StatusGatewayTimeout, "upstream_timeout", "Cloudflare API error: request timed out"
+	}
+	return http.StatusBadGateway, "upstream_error", fmt.Sprintf("Cloudflare API error: %v", err)
+}
+
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	status, code, message := upstreamErrorStatus(err)
+	writeError(w, status, code, message)
+}
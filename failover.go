@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	backendPrimary  = "primary"
+	backendFallback = "fallback"
+)
+
+// -failover-on 中可用的触发条件
+var failoverReasons = map[string]bool{"5xx": true, "429": true, "auth": true, "timeout": true, "network": true}
+
+var failoverPolicy map[string]bool
+
+// 备用上游可以是另一个 Cloudflare 账号、同一账号下的其他模型，或 OpenAI 兼容接口
+func loadFailover() error {
+	policy := map[string]bool{}
+	for _, reason := range strings.Split(config().FailoverOn, ",") {
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			continue
+		}
+		if !failoverReasons[reason] {
+			return fmt.Errorf("invalid -failover-on value %q, expected 5xx, 429, auth, timeout or network", reason)
+		}
+		policy[reason] = true
+	}
+	if config().FallbackAccount != "" {
+		id, token, ok := strings.Cut(config().FallbackAccount, ":")
+		if !ok || id == "" || token == "" {
+			return fmt.Errorf("invalid -fallback-account, expected account:token")
+		}
+	}
+	if config().FallbackURL != "" && config().FallbackAccount != "" {
+		return fmt.Errorf("-fallback-url and -fallback-account cannot be used together")
+	}
+	failoverPolicy = policy
+	return nil
+}
+
+func fallbackConfigured() bool {
+	return config().FallbackURL != "" || config().FallbackAccount != "" || config().FallbackModel != ""
+}
+
+// 熔断器打开时不再请求主上游，视为 503，由故障转移策略决定是否改用备用上游
+func primaryAvailable() error {
+	if breaker.allow() {
+		return nil
+	}
+	return &upstreamError{Status: http.StatusServiceUnavailable, Message: "Upstream temporarily unavailable"}
+}
+
+func failoverReason(ctx context.Context, err error) string {
+	var upErr *upstreamError
+	switch {
+	case ctx.Err() != nil:
+		// 客户端已断开，不再转移
+		return ""
+	case errors.As(err, &upErr):
+		switch {
+		case upErr.Status == http.StatusTooManyRequests:
+			return "429"
+		case upErr.Status == http.StatusUnauthorized || upErr.Status == http.StatusForbidden:
+			return "auth"
+		case upErr.Status >= 500:
+			return "5xx"
+		}
+		return ""
+	case isUpstreamTimeout(err):
+		return "timeout"
+	}
+	return "network"
+}
+
+// 主上游的错误符合 -failover-on 策略且配置了备用上游时返回 true
+func shouldFailover(ctx context.Context, err error) bool {
+	if err == nil || !fallbackConfigured() {
+		return false
+	}
+	reason := failoverReason(ctx, err)
+	if !failoverPolicy[reason] {
+		return false
+	}
+	metrics.inc("gptoss2api_failover_total", "reason", reason)
+	return true
+}
+
+type accountOverrideContextKey struct{}
+
+// 备用账号和 -providers 中的 Cloudflare 上游使用自己的账号和令牌，优先于租户和账号池
+func withAccountOverride(ctx context.Context, a *poolAccount) context.Context {
+	return context.WithValue(ctx, accountOverrideContextKey{}, a)
+}
+
+func accountOverride(ctx context.Context) *poolAccount {
+	a, _ := ctx.Value(accountOverrideContextKey{}).(*poolAccount)
+	return a
+}
+
+// -fallback-url 为 OpenAI 兼容上游，否则为 Cloudflare（指定了 -fallback-account 时使用该账号）
+func fallbackProvider() Provider {
+	if config().FallbackURL != "" {
+		return &openAIProvider{BaseURL: config().FallbackURL, APIKey: config().FallbackKey}
+	}
+	id, token, _ := strings.Cut(config().FallbackAccount, ":")
+	return &cloudflareProvider{AccountID: id, APIToken: token}
+}
+
+// OpenAI 兼容上游默认沿用客户端请求的模型名，Cloudflare 备用上游默认沿用主上游的模型
+func fallbackModel(openaiReq OpenAIRequest, cfReq CloudflareRequest) string {
+	switch {
+	case config().FallbackModel != "":
+		return config().FallbackModel
+	case config().FallbackURL != "":
+		return openaiReq.Model
+	}
+	return cfReq.Model
+}
+
+// 非流式请求改用备用上游
+func callFallbackChat(ctx context.Context, openaiReq OpenAIRequest, cfReq CloudflareRequest) (OpenAIResponse, error) {
+	defer trackPhase(ctx, "fallback", time.Now())
+	return fallbackProvider().Call(ctx, openaiReq, fallbackModel(openaiReq, cfReq))
+}
+
+// 流式请求改用 OpenAI 兼容的上游时，响应已是 chat.completion.chunk 格式，按事件原样转发。
+// 请求上游时总是带上 stream_options.include_usage，从最后的用量事件中取得 token 数；
+// 客户端没有要求用量时不转发这个事件
+func proxyFallbackStream(w http.ResponseWriter, resp *http.Response, includeUsage bool) Usage {
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	var usage Usage
+	var event []byte
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		event = append(event, line...)
+		if len(line) > 0 && len(bytes.TrimSpace(line)) > 0 && err == nil {
+			continue
+		}
+		if len(event) > 0 {
+			forward := true
+			if chunkUsage, usageOnly := streamChunkUsage(event); chunkUsage != nil {
+				usage = *chunkUsage
+				forward = includeUsage || !usageOnly
+			}
+			if forward {
+				if _, err := w.Write(event); err != nil {
+					// 客户端已断开，关闭备用上游的连接
+					return usage
+				}
+				w.(http.Flusher).Flush()
+			}
+			event = event[:0]
+		}
+		if err != nil {
+			return usage
+		}
+	}
+}
+
+// 返回事件中 data 行携带的用量；usageOnly 表示这是不含任何选项的用量事件
+func streamChunkUsage(event []byte) (usage *Usage, usageOnly bool) {
+	for _, line := range bytes.Split(event, []byte("\n")) {
+		data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		if !ok {
+			continue
+		}
+		var chunk struct {
+			Choices []json.RawMessage `json:"choices"`
+			Usage   *Usage            `json:"usage"`
+		}
+		if json.Unmarshal(bytes.TrimSpace(data), &chunk) == nil && chunk.Usage != nil {
+			return chunk.Usage, len(chunk.Choices) == 0
+		}
+	}
+	return nil, false
+}
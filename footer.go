@@ -0,0 +1,23 @@
+package main
+
+// 在回复末尾追加配置的页脚（例如 AI 生成内容声明），JSON 模式和函数调用的输出保持原样以免破坏解析
+func applyFooter(openaiResp *OpenAIResponse, openaiReq OpenAIRequest) {
+	if config().Footer == "" || isJSONMode(openaiReq) {
+		return
+	}
+	for i := range openaiResp.Choices {
+		if len(openaiResp.Choices[i].Message.ToolCalls) > 0 {
+			continue
+		}
+		if content, ok := openaiResp.Choices[i].Message.Content.(string); ok {
+			openaiResp.Choices[i].Message.Content = content + "\n\n" + config().Footer
+		}
+	}
+}
+
+func isJSONMode(openaiReq OpenAIRequest) bool {
+	if openaiReq.ResponseFormat == nil {
+		return false
+	}
+	return openaiReq.ResponseFormat.Type == "json_object" || openaiReq.ResponseFormat.Type == "json_schema"
+}
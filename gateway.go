@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 设置 -ai-gateway 后，推理请求经 Cloudflare AI Gateway 转发，可以使用网关的缓存、分析、限速和重试功能。
+// 网关需要在所用的每个账号下以同一名称创建；健康检查仍直接访问 API
+func workersAIURL(ctx context.Context, path string) string {
+	if config().AIGateway == "" {
+		return fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/ai/%s", upstreamAccountID(ctx), path)
+	}
+	// 网关的 Workers AI 路径中模型直接跟在 workers-ai/ 之后，没有 run/
+	return fmt.Sprintf("https://gateway.ai.cloudflare.com/v1/%s/%s/workers-ai/%s", upstreamAccountID(ctx), config().AIGateway, strings.TrimPrefix(path, "run/"))
+}
+
+// 开启了认证的网关需要 cf-aig-authorization；请求 ID 写入网关日志的元数据，便于对照
+func setGatewayHeaders(ctx context.Context, httpReq *http.Request) {
+	if config().AIGateway == "" {
+		return
+	}
+	if config().AIGatewayToken != "" {
+		httpReq.Header.Set("cf-aig-authorization", "Bearer "+config().AIGatewayToken)
+	}
+	if id := requestID(ctx); id != "" {
+		metadata, _ := json.Marshal(map[string]string{"request_id": id})
+		httpReq.Header.Set("cf-aig-metadata", string(metadata))
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 极简 MaxMind DB（.mmdb）读取器，只用于按 IP 查询国家代码
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+}
+
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	metaStart := uint(idx + len(mmdbMetadataMarker))
+	meta, _, err := (&mmdbReader{buf: buf}).decode(metaStart, metaStart)
+	if err != nil {
+		return nil, err
+	}
+	metaMap, _ := meta.(map[string]interface{})
+	r := &mmdbReader{
+		buf:        buf,
+		nodeCount:  uint(toUint64(metaMap["node_count"])),
+		recordSize: uint(toUint64(metaMap["record_size"])),
+		ipVersion:  uint(toUint64(metaMap["ip_version"])),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	r.dataStart = r.nodeCount*r.recordSize/4 + 16
+
+	// IPv6 数据库中 IPv4 地址位于 ::/96 之下，预先找到该节点
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func toUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case uint32:
+		return uint64(n)
+	case uint16:
+		return uint64(n)
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
+
+func (r *mmdbReader) readRecord(node uint, bit uint) uint {
+	b := r.buf
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return (uint(b[off+3])&0xF0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return (uint(b[off+3])&0x0F)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// 查询 IP 对应的数据记录，未找到时返回 nil
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	bitCount := 128
+	node := uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		bitCount = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bitCount && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = r.readRecord(node, bit)
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+	offset := node - r.nodeCount - 16 + r.dataStart
+	if offset >= uint(len(r.buf)) {
+		return nil, fmt.Errorf("invalid data pointer")
+	}
+	value, _, err := r.decode(offset, r.dataStart)
+	return value, err
+}
+
+// 按 MaxMind DB 规范解码一个值，返回值和下一个值的位置；base 为指针的相对起点
+func (r *mmdbReader) decode(offset, base uint) (interface{}, uint, error) {
+	b := r.buf
+	if offset >= uint(len(b)) {
+		return nil, 0, fmt.Errorf("unexpected end of database")
+	}
+	ctrl := b[offset]
+	offset++
+	typeNum := uint(ctrl >> 5)
+
+	if typeNum == 1 {
+		size := uint(ctrl>>3) & 0x3
+		vvv := uint(ctrl & 0x7)
+		var pointer uint
+		switch size {
+		case 0:
+			pointer = vvv<<8 | uint(b[offset])
+		case 1:
+			pointer = (vvv<<16 | uint(b[offset])<<8 | uint(b[offset+1])) + 2048
+		case 2:
+			pointer = (vvv<<24 | uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])) + 526336
+		default:
+			pointer = uint(binary.BigEndian.Uint32(b[offset:]))
+		}
+		value, _, err := r.decode(base+pointer, base)
+		return value, offset + size + 1, err
+	}
+
+	if typeNum == 0 {
+		typeNum = 7 + uint(b[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	switch size {
+	case 29:
+		size = 29 + uint(b[offset])
+		offset++
+	case 30:
+		size = 285 + uint(b[offset])<<8 | uint(b[offset+1])
+		offset += 2
+	case 31:
+		size = 65821 + (uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2]))
+		offset += 3
+	}
+
+	switch typeNum {
+	case 2:
+		return string(b[offset : offset+size]), offset + size, nil
+	case 3:
+		return math.Float64frombits(binary.BigEndian.Uint64(b[offset:])), offset + size, nil
+	case 4:
+		return b[offset : offset+size], offset + size, nil
+	case 5, 6, 9, 10:
+		var n uint64
+		for _, c := range b[offset : offset+size] {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset + size, nil
+	case 8:
+		var n uint32
+		for _, c := range b[offset : offset+size] {
+			n = n<<8 | uint32(c)
+		}
+		return int32(n), offset + size, nil
+	case 7:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := r.decode(offset, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, after, err := r.decode(next, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyStr, _ := key.(string)
+			m[keyStr] = value
+			offset = after
+		}
+		return m, offset, nil
+	case 11:
+		arr := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := r.decode(offset, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, value)
+			offset = next
+		}
+		return arr, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	case 15:
+		return math.Float32frombits(binary.BigEndian.Uint32(b[offset:])), offset + size, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typeNum)
+}
+
+// 返回 ISO 国家代码，优先取实际所在国家，其次取注册国家
+func (r *mmdbReader) country(ip net.IP) string {
+	record, err := r.lookup(ip)
+	if err != nil || record == nil {
+		return ""
+	}
+	m, _ := record.(map[string]interface{})
+	for _, field := range []string{"country", "registered_country"} {
+		if c, ok := m[field].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	geoDB        *mmdbReader
+	geoAllowList map[string]bool
+	geoDenyList  map[string]bool
+)
+
+func parseCountryList(list string) map[string]bool {
+	countries := make(map[string]bool)
+	for _, code := range strings.Split(list, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			countries[code] = true
+		}
+	}
+	return countries
+}
+
+func initGeoIP() error {
+	if config().GeoIPDB == "" {
+		return nil
+	}
+	db, err := openMMDB(config().GeoIPDB)
+	if err != nil {
+		return err
+	}
+	geoDB = db
+	geoAllowList = parseCountryList(config().GeoAllow)
+	geoDenyList = parseCountryList(config().GeoDeny)
+	log.Printf(tr("geoip_loaded"), config().GeoIPDB, config().GeoAllow, config().GeoDeny)
+	return nil
+}
+
+// 按客户端 IP 所在国家过滤请求；内网和本机地址不受限制，
+// 配置了白名单时无法识别国家的地址也会被拒绝
+func geoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if geoDB == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if ip == nil || ip.IsLoopback() || ip.IsPrivate() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		country := geoDB.country(ip)
+		if geoDenyList[country] || (len(geoAllowList) > 0 && !geoAllowList[country]) {
+			metrics.inc("gptoss2api_geo_blocked_total", "country", country)
+			writeError(w, http.StatusForbidden, "country_not_allowed", "Access from your region is not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
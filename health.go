@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 熔断器：连续失败达到阈值后在冷却时间内直接拒绝请求，冷却结束后放行试探
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+var breaker = &circuitBreaker{}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().After(b.openUntil)
+}
+
+func (b *circuitBreaker) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	metrics.set("gptoss2api_circuit_breaker_open", 0)
+}
+
+func (b *circuitBreaker) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if config().BreakerThreshold > 0 && b.failures >= config().BreakerThreshold {
+		b.tripLocked()
+	}
+}
+
+// 健康探测失败时直接熔断，不必等用户请求失败
+func (b *circuitBreaker) trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tripLocked()
+}
+
+func (b *circuitBreaker) tripLocked() {
+	if time.Now().Before(b.openUntil) {
+		return
+	}
+	b.openUntil = time.Now().Add(config().BreakerCooldown)
+	metrics.set("gptoss2api_circuit_breaker_open", 1)
+	logf(slog.LevelWarn, tr("breaker_open"), b.failures, config().BreakerCooldown)
+}
+
+// 根据上游调用结果更新熔断器，4xx 属于客户端问题，不计入失败
+func recordUpstreamResult(statusCode int, err error, duration time.Duration) {
+	if (err != nil && statusCode == 0) || statusCode >= 500 || statusCode == http.StatusTooManyRequests {
+		breaker.recordFailure()
+	} else if statusCode == http.StatusOK {
+		breaker.recordSuccess()
+	}
+	metrics.inc("gptoss2api_upstream_requests_total", "status", fmt.Sprint(statusCode))
+	metrics.observe("gptoss2api_upstream_duration_seconds", duration)
+	alerts.record(statusCode, err)
+}
+
+// 熔断期间直接返回 503，避免继续冲击故障的上游
+func rejectIfCircuitOpen(w http.ResponseWriter) bool {
+	if breaker.allow() {
+		return false
+	}
+	writeError(w, http.StatusServiceUnavailable, "upstream_unavailable", "Upstream temporarily unavailable")
+	return true
+}
+
+type healthStatus struct {
+	mu        sync.RWMutex
+	healthy   bool
+	checked   bool
+	lastError string
+	lastCheck time.Time
+}
+
+var upstreamHealth = &healthStatus{}
+
+func startHealthProbe() {
+	if config().HealthInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			probeUpstream()
+			time.Sleep(config().HealthInterval)
+		}
+	}()
+}
+
+// 使用模型搜索接口探测，既能验证账号和令牌，又不消耗推理额度。
+// 配置了账号池时逐个探测：失败的账号移出轮换，只有全部账号都失败时才熔断
+func probeUpstream() {
+	accountPool.mu.Lock()
+	accounts := append([]*poolAccount(nil), accountPool.accounts...)
+	accountPool.mu.Unlock()
+	if len(accounts) == 0 {
+		accounts = []*poolAccount{{AccountID: config().AccountID}}
+	}
+
+	var failed []*poolAccount
+	var errs []error
+	for _, a := range accounts {
+		token := a.AuthToken
+		if token == "" {
+			token = currentAuthToken()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := checkUpstream(ctx, a.AccountID, token)
+		cancel()
+		if err != nil {
+			failed = append(failed, a)
+			errs = append(errs, fmt.Errorf("account %s: %w", a.AccountID, err))
+		}
+	}
+	var err error
+	if len(failed) == len(accounts) {
+		err = errors.Join(errs...)
+	}
+
+	upstreamHealth.mu.Lock()
+	upstreamHealth.healthy = err == nil
+	upstreamHealth.checked = true
+	upstreamHealth.lastCheck = time.Now()
+	upstreamHealth.lastError = ""
+	if len(errs) > 0 {
+		upstreamHealth.lastError = errors.Join(errs...).Error()
+	}
+	upstreamHealth.mu.Unlock()
+
+	if err != nil {
+		logf(slog.LevelWarn, tr("health_failed"), err)
+		metrics.inc("gptoss2api_upstream_probe_total", "result", "failure")
+		metrics.set("gptoss2api_upstream_healthy", 0)
+		breaker.trip()
+		return
+	}
+	for i, a := range failed {
+		ejectAccount(a, "probe")
+		logf(slog.LevelWarn, tr("account_probe_failed"), a.AccountID, config().AccountEject, errs[i])
+	}
+	metrics.inc("gptoss2api_upstream_probe_total", "result", "success")
+	metrics.set("gptoss2api_upstream_healthy", 1)
+}
+
+func checkUpstream(ctx context.Context, accountID, token string) error {
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/ai/models/search?per_page=1", accountID)
+	httpReq, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	client := upstreamHTTPClient(false)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	upstreamHealth.mu.RLock()
+	healthy, checked := upstreamHealth.healthy, upstreamHealth.checked
+	lastError, lastCheck := upstreamHealth.lastError, upstreamHealth.lastCheck
+	upstreamHealth.mu.RUnlock()
+
+	// 关闭探测或尚未完成首次探测时以熔断器状态为准
+	ready := breaker.allow() && (healthy || !checked)
+
+	resp := map[string]interface{}{
+		"status":          "ready",
+		"upstream":        healthy,
+		"circuit_breaker": "closed",
+	}
+	if !breaker.allow() {
+		resp["circuit_breaker"] = "open"
+	}
+	if checked {
+		resp["last_check"] = lastCheck.Unix()
+	}
+	if lastError != "" {
+		resp["error"] = lastError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		resp["status"] = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 启动时发送一个极小的请求，提前建立连接并验证令牌
+func warmupUpstream() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	cfReq := CloudflareRequest{
+		Model: config().Model,
+		Input: "ping",
+	}
+	if _, _, err := callCloudflareAPI(cfReq, ctx); err != nil {
+		logf(slog.LevelWarn, tr("warmup_failed"), err)
+		return
+	}
+	log.Printf(tr("warmup_done"), time.Since(start))
+}
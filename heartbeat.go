@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 长时间的推理阶段没有任何输出，中间的代理和负载均衡可能因空闲超时断开连接。
+// SSE 流在第一个内容数据块之前按 -sse-heartbeat 间隔发送注释行保持连接
+type sseHeartbeat struct {
+	once   sync.Once
+	stopCh chan struct{}
+	done   chan struct{}
+	// 是否已发送过心跳，即响应头是否已经发出；只在 stop 之后读取
+	sent bool
+}
+
+var ssePing = []byte(": ping\n\n")
+
+// 响应头应已设置好；间隔为 0 时返回 nil，nil 的 stop/wrote 均可安全调用
+func startSSEHeartbeat(w http.ResponseWriter, ping []byte) *sseHeartbeat {
+	if config().SSEHeartbeat <= 0 {
+		return nil
+	}
+	h := &sseHeartbeat{stopCh: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(config().SSEHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := w.Write(ping); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				h.sent = true
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+	return h
+}
+
+// 在写出第一个数据块之前调用，等待心跳协程退出，之后不会再有并发写入
+func (h *sseHeartbeat) stop() {
+	if h == nil {
+		return
+	}
+	h.once.Do(func() { close(h.stopCh) })
+	<-h.done
+}
+
+// 停止心跳并返回响应头是否已经发出
+func (h *sseHeartbeat) wrote() bool {
+	if h == nil {
+		return false
+	}
+	h.stop()
+	return h.sent
+}
@@ -0,0 +1,124 @@
+package main
+
+// 日志和启动信息的多语言文案，通过 -lang 选择，缺失时回退到中文
+var translations = map[string]map[string]string{
+	"zh": {
+		"missing_token":           "请提供 auth-token 参数",
+		"server_started":          "服务器监听 %s\n",
+		"user_request":            "用户请求 JSON: %s",
+		"upstream_raw":            "Cloudflare 原始响应: %s",
+		"image_request":           "用户图片请求 JSON: %s",
+		"image_edit_request":      "用户图片编辑请求: prompt=%q image=%d bytes mask=%d bytes",
+		"audio_request":           "用户音频请求: task=%s format=%s language=%s audio=%d bytes",
+		"breaker_open":            "上游连续失败 %d 次，熔断 %s",
+		"health_failed":           "上游健康检查失败: %v",
+		"warmup_failed":           "预热请求失败，请检查账号 ID、令牌和模型配置: %v",
+		"warmup_done":             "预热请求完成，耗时 %s",
+		"alert_send_failed":       "发送告警失败: %v",
+		"alert_sent":              "已发送告警: %s",
+		"alert_error_rate":        "错误率 %.0f%% (%d/%d) 超过阈值 %.0f%%",
+		"alert_upstream":          "上游失败 %d 次，达到阈值 %d",
+		"alert_quota":             "Cloudflare 返回 429，额度可能已耗尽 (%d 次)",
+		"statsd_failed":           "连接 StatsD 失败: %v",
+		"statsd_started":          "指标将发送到 StatsD %s",
+		"chaos_enabled":           "故障注入模式已开启，仅用于测试",
+		"redis_failed":            "连接 Redis 失败: %v",
+		"redis_connected":         "已连接 Redis %s，限流和额度计数在所有副本间共享",
+		"redis_limit_fallback":    "Redis 限流失败，退回本地限流: %v",
+		"credential_reloaded":     "凭据文件 %s 已更新并重新加载",
+		"report_failed":           "发送用量报告失败: %v",
+		"report_done":             "已生成用量报告，本周期共 %d 个请求",
+		"geoip_loaded":            "已加载 GeoIP 数据库 %s，允许: %s 拒绝: %s",
+		"retention_purged":        "数据保留策略：已清理 %d 条重放记录、%d 条用量报告、%d 条用量明细",
+		"retention_failed":        "执行数据保留策略失败: %v",
+		"slow_request":            "慢请求 method=%s route=%s status=%d total=%s %s",
+		"shutdown_started":        "收到信号 %s，停止接受新请求，最多等待 %s 让进行中的请求完成",
+		"shutdown_forced":         "等待超时，强制关闭剩余连接: %v",
+		"shutdown_done":           "服务已退出",
+		"embedding_request":       "用户向量请求: model=%s inputs=%d",
+		"account_ejected":         "账号 %s 返回 %d，暂时移出轮换 %s",
+		"failover":                "主上游失败，改用备用上游: %v",
+		"usage_write_failed":      "写入用量明细失败: %v",
+		"upstream_failed_trace":   "上游返回 %d，cf-ray: %s",
+		"client_disconnected":     "客户端中途断开，已停止上游生成（已输出 %d 字节）",
+		"admin_listening":         "管理接口监听 %s",
+		"admin_changed":           "管理接口修改了 %s: %s",
+		"config_reloaded":         "配置已重新加载",
+		"config_reload_failed":    "重新加载配置失败，继续使用原配置: %v",
+		"tls_reloaded":            "已加载更新后的 TLS 证书: %s",
+		"tls_reload_failed":       "加载更新后的 TLS 证书失败，继续使用原证书: %v",
+		"tokenizer_loaded":        "已加载分词词表 %s（%d 个 token）",
+		"context_summary_failed":  "压缩早期对话失败，改为直接删除: %v",
+		"model_discovery_failed":  "获取 Cloudflare 模型列表失败，继续使用缓存的列表: %v",
+		"mock_enabled":            "模拟模式：不会调用 Cloudflare，所有推理请求返回本地生成的模拟响应",
+		"cassette_missing":        "回放目录中没有该请求的录制: %s %s",
+		"cassette_write_failed":   "写入录制文件失败: %v",
+		"access_log_write_failed": "写入访问日志失败: %v",
+		"handler_panic":           "处理 %s %s 时发生 panic: %v\n%s",
+		"key_limit_store_failed":  "读取 %s 的每日 token 用量失败，本次请求不检查该额度: %v",
+		"account_probe_failed":    "账号 %s 健康检查失败，暂时移出轮换 %s: %v",
+	},
+	"en": {
+		"missing_token":           "please provide the -token parameter",
+		"server_started":          "server listening on %s\n",
+		"user_request":            "client request JSON: %s",
+		"upstream_raw":            "Cloudflare raw response: %s",
+		"image_request":           "client image request JSON: %s",
+		"image_edit_request":      "client image edit request: prompt=%q image=%d bytes mask=%d bytes",
+		"audio_request":           "client audio request: task=%s format=%s language=%s audio=%d bytes",
+		"breaker_open":            "upstream failed %d times in a row, circuit open for %s",
+		"health_failed":           "upstream health check failed: %v",
+		"warmup_failed":           "warmup request failed, check account ID, token and model: %v",
+		"warmup_done":             "warmup request finished in %s",
+		"alert_send_failed":       "failed to send alert: %v",
+		"alert_sent":              "alert sent: %s",
+		"alert_error_rate":        "error rate %.0f%% (%d/%d) exceeds threshold %.0f%%",
+		"alert_upstream":          "%d upstream failures reached threshold %d",
+		"alert_quota":             "Cloudflare returned 429, quota may be exhausted (%d times)",
+		"statsd_failed":           "failed to connect to StatsD: %v",
+		"statsd_started":          "sending metrics to StatsD %s",
+		"chaos_enabled":           "chaos fault injection enabled, for testing only",
+		"redis_failed":            "failed to connect to Redis: %v",
+		"redis_connected":         "connected to Redis %s, rate limit and budget counters are shared across replicas",
+		"redis_limit_fallback":    "Redis rate limiting failed, falling back to local limiter: %v",
+		"credential_reloaded":     "credential file %s changed and was reloaded",
+		"report_failed":           "failed to deliver usage report: %v",
+		"report_done":             "usage report generated, %d requests in this period",
+		"geoip_loaded":            "loaded GeoIP database %s, allow: %s deny: %s",
+		"retention_purged":        "retention: purged %d replay records, %d usage report entries and %d usage records",
+		"retention_failed":        "failed to apply retention policy: %v",
+		"slow_request":            "slow request method=%s route=%s status=%d total=%s %s",
+		"shutdown_started":        "received %s, no longer accepting requests, waiting up to %s for in-flight requests",
+		"shutdown_forced":         "shutdown timed out, closing remaining connections: %v",
+		"shutdown_done":           "server stopped",
+		"embedding_request":       "client embedding request: model=%s inputs=%d",
+		"account_ejected":         "account %s returned %d, removed from rotation for %s",
+		"failover":                "primary upstream failed, switching to fallback: %v",
+		"usage_write_failed":      "failed to write usage record: %v",
+		"upstream_failed_trace":   "upstream returned %d, cf-ray: %s",
+		"client_disconnected":     "client disconnected mid-stream, upstream generation stopped after %d bytes",
+		"admin_listening":         "Admin API listening on %s",
+		"admin_changed":           "Admin API changed %s: %s",
+		"config_reloaded":         "Configuration reloaded",
+		"config_reload_failed":    "Config reload failed, keeping previous configuration: %v",
+		"tls_reloaded":            "Reloaded TLS certificate: %s",
+		"tls_reload_failed":       "Failed to load renewed TLS certificate, keeping the previous one: %v",
+		"tokenizer_loaded":        "Loaded tokenizer vocabulary %s (%d tokens)",
+		"context_summary_failed":  "Summarizing earlier conversation failed, dropping it instead: %v",
+		"model_discovery_failed":  "Fetching the Cloudflare model list failed, keeping the cached list: %v",
+		"mock_enabled":            "Mock mode: Cloudflare is never called, all inference requests get locally generated mock responses",
+		"cassette_missing":        "No recorded interaction for %s %s",
+		"cassette_write_failed":   "Writing cassette failed: %v",
+		"access_log_write_failed": "failed to write access log: %v",
+		"handler_panic":           "panic while handling %s %s: %v\n%s",
+		"key_limit_store_failed":  "failed to read daily token usage for %s, skipping the quota check for this request: %v",
+		"account_probe_failed":    "account %s failed the health check, removed from rotation for %s: %v",
+	},
+}
+
+func tr(key string) string {
+	if msg, ok := translations[config().Lang][key]; ok {
+		return msg
+	}
+	return translations["zh"][key]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 进程内保存的生成图片上限，超出时淘汰最早的图片
+const maxHostedImages = 500
+
+// response_format=url 且设置了 -image-url-ttl 时，生成的图片暂存在内存中，
+// 通过 /v1/images/files/{id} 提供下载，过期后删除。ID 随机生成，下载不需要认证
+type hostedImage struct {
+	data        []byte
+	contentType string
+	expires     time.Time
+}
+
+type imageHost struct {
+	mu     sync.Mutex
+	images map[string]hostedImage
+	order  []string
+}
+
+var hostedImages = &imageHost{images: make(map[string]hostedImage)}
+
+func (h *imageHost) put(data []byte) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := "img_" + hex.EncodeToString(b)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for len(h.order) > 0 {
+		oldest := h.order[0]
+		if img, ok := h.images[oldest]; ok && now.Before(img.expires) && len(h.order) < maxHostedImages {
+			break
+		}
+		delete(h.images, oldest)
+		h.order = h.order[1:]
+	}
+	h.images[id] = hostedImage{data: data, contentType: http.DetectContentType(data), expires: now.Add(config().ImageURLTTL)}
+	h.order = append(h.order, id)
+	return id
+}
+
+func (h *imageHost) get(id string) (hostedImage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	img, ok := h.images[id]
+	if !ok || time.Now().After(img.expires) {
+		return hostedImage{}, false
+	}
+	return img, true
+}
+
+// 图片地址的前缀：优先使用 -image-base-url，否则根据请求的 Host 推断
+func imageBaseURL(r *http.Request) string {
+	if config().ImageBaseURL != "" {
+		return strings.TrimSuffix(config().ImageBaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// GET /v1/images/files/{id}
+func handleImageFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	img, ok := hostedImages.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Image not found or expired")
+		return
+	}
+	w.Header().Set("Content-Type", img.contentType)
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(time.Until(img.expires).Seconds())))
+	w.Write(img.data)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ImageGenerationRequest struct {
+	Model          string `json:"model"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+type ImageData struct {
+	URL     string `json:"url,omitempty"`
+	B64JSON string `json:"b64_json,omitempty"`
+}
+
+type ImageResponse struct {
+	Created int64       `json:"created"`
+	Data    []ImageData `json:"data"`
+}
+
+const maxImagesPerRequest = 10
+
+func handleImageGenerations(w http.ResponseWriter, r *http.Request) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
+		return
+	}
+
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+	reqLog.Body(tr("image_request"), string(body))
+
+	var imgReq ImageGenerationRequest
+	if err := json.Unmarshal(body, &imgReq); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if imgReq.Prompt == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "prompt is required")
+		return
+	}
+
+	n, format, err := validateImageOptions(imgReq.N, imgReq.ResponseFormat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if rejectIfCircuitOpen(w) {
+		return
+	}
+
+	model := workersAIModel(imgReq.Model, config().ImageModel)
+	payload, err := convertToCloudflareImageRequest(imgReq, model)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	images, err := generateImages(r, model, payload, n)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeImageResponse(w, r, images, format)
+}
+
+// n > 1 时并发生成多张图片，任意一张失败则整体失败
+func generateImages(r *http.Request, model string, payload map[string]interface{}, n int) ([][]byte, error) {
+	images := make([][]byte, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			images[i], errs[i] = generateImage(r, model, payload)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+func writeImageResponse(w http.ResponseWriter, r *http.Request, images [][]byte, format string) {
+	resp := ImageResponse{Created: time.Now().Unix()}
+	for _, img := range images {
+		switch {
+		case format == "b64_json":
+			resp.Data = append(resp.Data, ImageData{B64JSON: base64.StdEncoding.EncodeToString(img)})
+		case config().ImageURLTTL > 0:
+			resp.Data = append(resp.Data, ImageData{URL: imageBaseURL(r) + apiPath("/v1/images/files/") + hostedImages.put(img)})
+		default:
+			// 未开启图片托管时，url 模式返回 data URL
+			resp.Data = append(resp.Data, ImageData{URL: "data:" + http.DetectContentType(img) + ";base64," + base64.StdEncoding.EncodeToString(img)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func validateImageOptions(n int, format string) (int, string, error) {
+	if n == 0 {
+		n = 1
+	}
+	if n < 1 || n > maxImagesPerRequest {
+		return 0, "", fmt.Errorf("n must be between 1 and %d", maxImagesPerRequest)
+	}
+	if format == "" {
+		format = "url"
+	}
+	if format != "url" && format != "b64_json" {
+		return 0, "", fmt.Errorf("response_format must be url or b64_json")
+	}
+	return n, format, nil
+}
+
+// 将 OpenAI 的 size/quality 映射为 Cloudflare 图片模型的 width/height/steps
+func convertToCloudflareImageRequest(imgReq ImageGenerationRequest, model string) (map[string]interface{}, error) {
+	payload := map[string]interface{}{
+		"prompt": imgReq.Prompt,
+	}
+
+	if imgReq.Size != "" && imgReq.Size != "auto" {
+		width, height, err := parseImageSize(imgReq.Size)
+		if err != nil {
+			return nil, err
+		}
+		payload["width"] = width
+		payload["height"] = height
+	}
+
+	// flux 系列使用 steps（上限 8），stable diffusion 系列使用 num_steps（上限 20）
+	hd := imgReq.Quality == "hd" || imgReq.Quality == "high"
+	if strings.Contains(model, "flux") {
+		if hd {
+			payload["steps"] = 8
+		} else {
+			payload["steps"] = 4
+		}
+	} else {
+		if hd {
+			payload["num_steps"] = 20
+		} else {
+			payload["num_steps"] = 10
+		}
+	}
+
+	return payload, nil
+}
+
+func parseImageSize(size string) (int, int, error) {
+	parts := strings.SplitN(size, "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size: %s", size)
+	}
+	width, err1 := strconv.Atoi(parts[0])
+	height, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || width < 256 || height < 256 || width > 2048 || height > 2048 {
+		return 0, 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return width, height, nil
+}
+
+// 生成单张图片，兼容返回 JSON（base64）和直接返回图片二进制的模型
+func generateImage(r *http.Request, model string, payload map[string]interface{}) ([]byte, error) {
+	body, contentType, err := callCloudflareRun(r.Context(), model, payload)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		return body, nil
+	}
+
+	var cfResp struct {
+		Result struct {
+			Image string `json:"image"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &cfResp); err != nil {
+		return nil, err
+	}
+	if cfResp.Result.Image == "" {
+		return nil, fmt.Errorf("empty image in response: %s", string(body))
+	}
+	return base64.StdEncoding.DecodeString(cfResp.Result.Image)
+}
+
+// 默认 32MB，与 OpenAI 图片上传上限一致
+const maxImageUploadSize = 32 << 20
+
+func handleImageEdits(w http.ResponseWriter, r *http.Request) {
+	handleImageToImage(w, r, true)
+}
+
+func handleImageVariations(w http.ResponseWriter, r *http.Request) {
+	handleImageToImage(w, r, false)
+}
+
+// edits 与 variations 都接收 multipart 上传的 image（以及可选的 mask），
+// 有 mask 时走 inpainting 模型，否则走 img2img 模型
+func handleImageToImage(w http.ResponseWriter, r *http.Request, isEdit bool) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxImageUploadSize); err != nil {
+		if isBodyTooLarge(err) {
+			writeBodyTooLarge(w)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid multipart form")
+		return
+	}
+
+	image, err := readFormFile(r, "image")
+	if err != nil || image == nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "image is required")
+		return
+	}
+	mask, err := readFormFile(r, "mask")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid mask")
+		return
+	}
+
+	prompt := r.FormValue("prompt")
+	if isEdit && prompt == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "prompt is required")
+		return
+	}
+	if !isEdit {
+		// variations 没有 prompt，img2img 模型要求必须提供
+		prompt = "a variation of this image"
+		mask = nil
+	}
+	reqLog.Body(tr("image_edit_request"), prompt, len(image), len(mask))
+
+	nValue := 0
+	if v := r.FormValue("n"); v != "" {
+		if nValue, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "n must be an integer")
+			return
+		}
+	}
+	n, format, err := validateImageOptions(nValue, r.FormValue("response_format"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if rejectIfCircuitOpen(w) {
+		return
+	}
+
+	model := config().ImageVariationModel
+	payload := map[string]interface{}{
+		"prompt": prompt,
+		"image":  bytesToIntArray(image),
+	}
+	if mask != nil {
+		model = config().ImageEditModel
+		payload["mask"] = bytesToIntArray(mask)
+	}
+	if size := r.FormValue("size"); size != "" {
+		width, height, err := parseImageSize(size)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		payload["width"] = width
+		payload["height"] = height
+	}
+
+	images, err := generateImages(r, model, payload, n)
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	writeImageResponse(w, r, images, format)
+}
+
+// 读取 multipart 中的文件字段，字段不存在时返回 nil
+func readFormFile(r *http.Request, field string) ([]byte, error) {
+	file, _, err := r.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Workers AI 的图片输入要求是 uint8 数组，json.Marshal 对 []byte 会编码成 base64
+func bytesToIntArray(data []byte) []int {
+	arr := make([]int, len(data))
+	for i, b := range data {
+		arr[i] = int(b)
+	}
+	return arr
+}
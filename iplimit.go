@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 按客户端 IP 的令牌桶限流，用于不设客户端密钥的公开实例：-ip-rpm 为持续速率，
+// -ip-burst 为允许的突发量。使用具名客户端密钥的请求由 -key-rpm 等按密钥限额约束，不受此限制
+var ipBuckets = struct {
+	mu      sync.Mutex
+	sweep   time.Time
+	buckets map[string]*tokenBucket
+}{buckets: make(map[string]*tokenBucket)}
+
+func ipBucket(ip string) *tokenBucket {
+	ipBuckets.mu.Lock()
+	defer ipBuckets.mu.Unlock()
+	now := time.Now()
+	if now.Sub(ipBuckets.sweep) >= time.Minute {
+		// 已经补满的桶与新建的桶等价，定期删除，避免大量来访地址占用内存
+		ipBuckets.sweep = now
+		for key, b := range ipBuckets.buckets {
+			b.mu.Lock()
+			b.refillLocked()
+			full := b.tokens >= b.capacity
+			b.mu.Unlock()
+			if full {
+				delete(ipBuckets.buckets, key)
+			}
+		}
+	}
+	b := ipBuckets.buckets[ip]
+	if b == nil || b.rate != config().IPRPM/60 || b.capacity != ipBurst() {
+		b = newTokenBucket(config().IPRPM, config().IPBurst)
+		ipBuckets.buckets[ip] = b
+	}
+	return b
+}
+
+func ipBurst() float64 {
+	if config().IPBurst <= 0 {
+		return config().IPRPM
+	}
+	return config().IPBurst
+}
+
+// 超出限额时写出 429 并返回 true
+func rejectIfIPLimited(w http.ResponseWriter, r *http.Request) bool {
+	if config().IPRPM <= 0 || requestKeyID(r) != "" {
+		return false
+	}
+	ip := clientIP(r)
+	if ip == nil {
+		return false
+	}
+	wait, remaining := ipBucket(ip.String()).take(1)
+	h := w.Header()
+	h.Set("x-ratelimit-limit-requests", strconv.FormatFloat(ipBurst(), 'f', -1, 64))
+	h.Set("x-ratelimit-remaining-requests", strconv.Itoa(int(remaining)))
+	if wait <= 0 {
+		return false
+	}
+	metrics.inc("gptoss2api_ip_rate_limited_total")
+	h.Set("x-ratelimit-reset-requests", wait.Round(time.Millisecond).String())
+	h.Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	writeError(w, http.StatusTooManyRequests, "rate_limit_exceeded", "Rate limit reached for requests from your IP address")
+	return true
+}
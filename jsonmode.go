@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// response_format 的 json_schema 参数
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// Responses API 通过 text.format 约束输出格式
+type CloudflareTextConfig struct {
+	Format map[string]interface{} `json:"format"`
+}
+
+func validateResponseFormat(format *ResponseFormat) error {
+	if format == nil {
+		return nil
+	}
+	switch format.Type {
+	case "text", "json_object":
+		return nil
+	case "json_schema":
+		if format.JSONSchema == nil || format.JSONSchema.Name == "" {
+			return fmt.Errorf("response_format.json_schema.name is required")
+		}
+		if len(format.JSONSchema.Schema) > 0 {
+			var schema interface{}
+			if err := json.Unmarshal(format.JSONSchema.Schema, &schema); err != nil {
+				return fmt.Errorf("response_format.json_schema.schema must be a JSON object")
+			}
+			if _, ok := schema.(map[string]interface{}); !ok {
+				return fmt.Errorf("response_format.json_schema.schema must be a JSON object")
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("response_format.type must be text, json_object or json_schema")
+}
+
+// 模型能力中 json_schema 为 true 或未登记能力时把约束转发给上游，否则在系统提示中要求模型输出 JSON，
+// 两种情况下代理都会校验输出
+func applyResponseFormat(cfReq *CloudflareRequest, openaiReq OpenAIRequest) {
+	if !isJSONMode(openaiReq) {
+		return
+	}
+	format := openaiReq.ResponseFormat
+	if caps, ok := modelCapabilities[cfReq.Model]; !ok || caps.JSONSchema {
+		if format.Type == "json_object" {
+			cfReq.Text = &CloudflareTextConfig{Format: map[string]interface{}{"type": "json_object"}}
+			return
+		}
+		f := map[string]interface{}{"type": "json_schema", "name": format.JSONSchema.Name}
+		if format.JSONSchema.Description != "" {
+			f["description"] = format.JSONSchema.Description
+		}
+		if len(format.JSONSchema.Schema) > 0 {
+			f["schema"] = format.JSONSchema.Schema
+		}
+		if format.JSONSchema.Strict != nil {
+			f["strict"] = *format.JSONSchema.Strict
+		}
+		cfReq.Text = &CloudflareTextConfig{Format: f}
+		return
+	}
+
+	instruction := "Respond only with a single valid JSON value, without code fences or any other text."
+	if format.Type == "json_schema" && len(format.JSONSchema.Schema) > 0 {
+		instruction = "Respond only with a single valid JSON value that conforms to this JSON Schema, without code fences or any other text:\n" + string(format.JSONSchema.Schema)
+	}
+	input, _ := cfReq.Input.([]map[string]interface{})
+	cfReq.Input = append([]map[string]interface{}{{"role": "system", "content": instruction}}, input...)
+}
+
+var jsonFencePattern = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*\\n(.*?)\\n?```$")
+
+// 模型经常把 JSON 包在 ```json 代码块中，去掉后再解析
+func stripJSONFence(text string) string {
+	text = strings.TrimSpace(text)
+	if m := jsonFencePattern.FindStringSubmatch(text); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return text
+}
+
+// 校验回复正文是否满足 response_format，返回给客户端的错误信息说明第一个不满足的位置
+func checkResponseFormat(openaiReq OpenAIRequest, openaiResp OpenAIResponse) error {
+	choice := openaiResp.Choices[0]
+	if len(choice.Message.ToolCalls) > 0 {
+		return nil
+	}
+	text, _ := choice.Message.Content.(string)
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return fmt.Errorf("model output is not valid JSON: %v", err)
+	}
+	format := openaiReq.ResponseFormat
+	if format.Type == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("model output is not a JSON object")
+		}
+		return nil
+	}
+	if len(format.JSONSchema.Schema) == 0 {
+		return nil
+	}
+	var schema interface{}
+	json.Unmarshal(format.JSONSchema.Schema, &schema)
+	return validateJSONSchema(value, schema, schema, "$")
+}
+
+type responseFormatError struct {
+	Message string
+}
+
+func (e *responseFormatError) Error() string { return e.Message }
+
+// 非流式请求的输出不满足 response_format 时重新请求上游，最多 -json-retries 次；
+// 返回的用量包含所有尝试，全部失败时返回 *responseFormatError
+func enforceResponseFormat(ctx context.Context, p Provider, openaiReq OpenAIRequest, model string, openaiResp OpenAIResponse) (OpenAIResponse, error) {
+	usage := openaiResp.Usage
+	for attempt := 0; ; attempt++ {
+		err := checkResponseFormat(openaiReq, openaiResp)
+		if err == nil {
+			openaiResp.Usage = usage
+			return openaiResp, nil
+		}
+		metrics.inc("gptoss2api_response_format_failures_total")
+		if attempt >= config().JSONRetries {
+			openaiResp.Usage = usage
+			return openaiResp, &responseFormatError{err.Error()}
+		}
+		retried, err := p.Call(ctx, openaiReq, model)
+		if err != nil {
+			openaiResp.Usage = usage
+			return openaiResp, err
+		}
+		openaiResp = retried
+		usage.PromptTokens += openaiResp.Usage.PromptTokens
+		usage.CompletionTokens += openaiResp.Usage.CompletionTokens
+		usage.TotalTokens += openaiResp.Usage.TotalTokens
+	}
+}
+
+// 支持结构化输出常用的 JSON Schema 子集：type、enum、const、properties、required、additionalProperties、
+// items、anyOf/oneOf/allOf、数值和长度范围以及指向 #/$defs 的 $ref
+func validateJSONSchema(value, schema, root interface{}, path string) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := resolveSchemaRef(root, ref)
+		if err != nil {
+			return err
+		}
+		return validateJSONSchema(value, target, root, path)
+	}
+	if t, ok := s["type"]; ok && !matchesSchemaType(value, t) {
+		return fmt.Errorf("%s: expected type %v", path, t)
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(value, c) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+	for _, sub := range schemaList(s["allOf"]) {
+		if err := validateJSONSchema(value, sub, root, path); err != nil {
+			return err
+		}
+	}
+	if anyOf := schemaList(s["anyOf"]); len(anyOf) > 0 {
+		if countMatches(value, anyOf, root, path) == 0 {
+			return fmt.Errorf("%s: value does not match any of the anyOf schemas", path)
+		}
+	}
+	if oneOf := schemaList(s["oneOf"]); len(oneOf) > 0 {
+		if countMatches(value, oneOf, root, path) != 1 {
+			return fmt.Errorf("%s: value must match exactly one of the oneOf schemas", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := s["properties"].(map[string]interface{})
+		for _, name := range schemaStrings(s["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, item := range v {
+			if sub, ok := properties[name]; ok {
+				if err := validateJSONSchema(item, sub, root, path+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := s["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+			case map[string]interface{}:
+				if err := validateJSONSchema(item, extra, root, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if n, ok := s["minItems"].(float64); ok && float64(len(v)) < n {
+			return fmt.Errorf("%s: expected at least %v items", path, n)
+		}
+		if n, ok := s["maxItems"].(float64); ok && float64(len(v)) > n {
+			return fmt.Errorf("%s: expected at most %v items", path, n)
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				if err := validateJSONSchema(item, items, root, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := s["minLength"].(float64); ok && length < n {
+			return fmt.Errorf("%s: expected at least %v characters", path, n)
+		}
+		if n, ok := s["maxLength"].(float64); ok && length > n {
+			return fmt.Errorf("%s: expected at most %v characters", path, n)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				return fmt.Errorf("%s: value does not match pattern %s", path, pattern)
+			}
+		}
+	case float64:
+		if n, ok := s["minimum"].(float64); ok && v < n {
+			return fmt.Errorf("%s: expected a value >= %v", path, n)
+		}
+		if n, ok := s["maximum"].(float64); ok && v > n {
+			return fmt.Errorf("%s: expected a value <= %v", path, n)
+		}
+		if n, ok := s["exclusiveMinimum"].(float64); ok && v <= n {
+			return fmt.Errorf("%s: expected a value > %v", path, n)
+		}
+		if n, ok := s["exclusiveMaximum"].(float64); ok && v >= n {
+			return fmt.Errorf("%s: expected a value < %v", path, n)
+		}
+	}
+	return nil
+}
+
+func matchesSchemaType(value interface{}, t interface{}) bool {
+	if types, ok := t.([]interface{}); ok {
+		for _, item := range types {
+			if matchesSchemaType(value, item) {
+				return true
+			}
+		}
+		return false
+	}
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func resolveSchemaRef(root interface{}, ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %s", ref)
+	}
+	node := root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, _ := node.(map[string]interface{})
+		if m == nil || m[part] == nil {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+		node = m[part]
+	}
+	return node, nil
+}
+
+func countMatches(value interface{}, schemas []interface{}, root interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if validateJSONSchema(value, sub, root, path) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func schemaList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+func schemaStrings(v interface{}) []string {
+	var out []string
+	for _, item := range schemaList(v) {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestApplyResponseFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		req   string
+		want  string
+	}{
+		{
+			name:  "json_object",
+			model: "@cf/openai/gpt-oss-120b",
+			req:   `{"messages":[{"role":"user","content":"list"}],"response_format":{"type":"json_object"}}`,
+			want:  `{"model":"@cf/openai/gpt-oss-120b","input":[{"role":"user","content":"list"}],"text":{"format":{"type":"json_object"}}}`,
+		},
+		{
+			name:  "json_schema",
+			model: "@cf/openai/gpt-oss-120b",
+			req:   `{"messages":[{"role":"user","content":"list"}],"response_format":{"type":"json_schema","json_schema":{"name":"items","schema":{"type":"array"},"strict":true}}}`,
+			want:  `{"model":"@cf/openai/gpt-oss-120b","input":[{"role":"user","content":"list"}],"text":{"format":{"type":"json_schema","name":"items","schema":{"type":"array"},"strict":true}}}`,
+		},
+		{
+			name:  "models without structured output get an instruction",
+			model: "@cf/meta/llama-3.2-11b-vision-instruct",
+			req:   `{"messages":[{"role":"user","content":"list"}],"response_format":{"type":"json_object"}}`,
+			want: `{"model":"@cf/meta/llama-3.2-11b-vision-instruct","input":[{"role":"system","content":"Respond only with a single valid JSON value, without code fences or any other text."},
+				{"role":"user","content":"list"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OpenAIRequest
+			if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, convertToCloudflareRequest(req, tt.model), tt.want)
+		})
+	}
+}
+
+func TestStripJSONFence(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{`{"a":1}`, `{"a":1}`},
+		{"```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"  ```\n[1,2]```  ", `[1,2]`},
+		{"here: ```json\n{}\n```", "here: ```json\n{}\n```"},
+	}
+	for _, tt := range tests {
+		if got := stripJSONFence(tt.text); got != tt.want {
+			t.Errorf("stripJSONFence(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 单个客户端密钥的限额：每分钟请求数和每天 token 数，0 表示不限制；
+// DefaultMaxTokens 和 MaxTokensCap 覆盖该密钥的 -default-max-tokens 和 -max-tokens-cap，0 表示沿用租户或全局配置
+type KeyLimit struct {
+	RPM              float64 `json:"rpm"`
+	TPD              float64 `json:"tpd"`
+	DefaultMaxTokens int     `json:"default_max_tokens,omitempty"`
+	MaxTokensCap     int     `json:"max_tokens_cap,omitempty"`
+}
+
+// -key-limits 文件中按密钥 ID 覆盖 -key-rpm/-key-tpd 的默认值，可以通过管理接口在运行时修改
+var keyLimits map[string]KeyLimit
+
+var keyLimitsMu sync.RWMutex
+
+var keyBuckets = struct {
+	mu      sync.Mutex
+	sweep   time.Time
+	buckets map[string]*tokenBucket
+}{buckets: make(map[string]*tokenBucket)}
+
+func loadKeyLimits() error {
+	limits := map[string]KeyLimit{}
+	if config().KeyLimitsFile != "" {
+		data, err := os.ReadFile(config().KeyLimitsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &limits); err != nil {
+			return fmt.Errorf("invalid -key-limits file: %v", err)
+		}
+	}
+	keyLimitsMu.Lock()
+	keyLimits = limits
+	keyLimitsMu.Unlock()
+	return nil
+}
+
+func keyLimitFor(identity string) KeyLimit {
+	keyLimitsMu.RLock()
+	defer keyLimitsMu.RUnlock()
+	if limit, ok := keyLimits[identity]; ok {
+		return limit
+	}
+	return KeyLimit{RPM: config().KeyRPM, TPD: config().KeyTPD}
+}
+
+// 未使用具名密钥时身份就是原始密钥，存储中只保存其哈希
+func keyLimitSubject(identity string) string {
+	if isClientKeyID(identity) {
+		return identity
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:8])
+}
+
+// 与预算一致按 UTC 日期计数，多副本部署时共享
+func keyTokensKey(identity string) string {
+	return "keytokens:" + keyLimitSubject(identity) + ":" + time.Now().UTC().Format("2006-01-02")
+}
+
+// 距离下一个 UTC 零点的时间，即每日 token 额度的重置时间
+func untilUTCMidnight() time.Duration {
+	now := time.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+type keyLimitError struct {
+	Code    string
+	Message string
+}
+
+func rejectIfKeyLimited(w http.ResponseWriter, r *http.Request) bool {
+	if err := checkKeyLimits(w, r); err != nil {
+		writeError(w, http.StatusTooManyRequests, err.Code, err.Message)
+		return true
+	}
+	return false
+}
+
+// 按 OpenAI 的 x-ratelimit-* 响应头告知客户端剩余额度，超出限额时设置 Retry-After 并返回错误，由调用方写出 429
+func checkKeyLimits(w http.ResponseWriter, r *http.Request) *keyLimitError {
+	identity := clientIdentity(r)
+	limit := keyLimitFor(identity)
+	h := w.Header()
+
+	if limit.RPM > 0 {
+		wait, remaining, reset := takeKeyRequest(identity, limit.RPM)
+		h.Set("x-ratelimit-limit-requests", strconv.FormatFloat(limit.RPM, 'f', -1, 64))
+		h.Set("x-ratelimit-remaining-requests", strconv.Itoa(int(remaining)))
+		h.Set("x-ratelimit-reset-requests", reset.Round(time.Millisecond).String())
+		if wait > 0 {
+			metrics.inc("gptoss2api_key_rate_limited_total", "limit", "requests")
+			h.Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			return &keyLimitError{"rate_limit_exceeded", "Rate limit reached for requests per minute on this API key"}
+		}
+	}
+
+	if limit.TPD > 0 {
+		value, _, err := store.Get(keyTokensKey(identity))
+		if err != nil {
+			// 存储不可用时放行，不因为计数失败拒绝所有请求
+			metrics.inc("gptoss2api_store_errors_total", "op", "key_tokens")
+			logf(slog.LevelWarn, tr("key_limit_store_failed"), usageLabel(identity), err)
+			return nil
+		}
+		used, _ := strconv.ParseFloat(value, 64)
+		remaining := max(limit.TPD-used, 0)
+		reset := untilUTCMidnight()
+		h.Set("x-ratelimit-limit-tokens", strconv.FormatFloat(limit.TPD, 'f', -1, 64))
+		h.Set("x-ratelimit-remaining-tokens", strconv.FormatFloat(remaining, 'f', 0, 64))
+		h.Set("x-ratelimit-reset-tokens", reset.Round(time.Second).String())
+		if remaining <= 0 {
+			metrics.inc("gptoss2api_key_rate_limited_total", "limit", "tokens")
+			h.Set("Retry-After", strconv.Itoa(int(reset.Seconds())+1))
+			return &keyLimitError{"insufficient_quota", "Daily token quota for this API key is exhausted"}
+		}
+	}
+	return nil
+}
+
+// 计入一次请求，返回需要等待的时间（0 表示未超限）、剩余次数和计数重置时间。
+// 多副本共享存储时按分钟固定窗口在整个集群内计数，存储出错时退回本地令牌桶
+func takeKeyRequest(identity string, rpm float64) (wait time.Duration, remaining float64, reset time.Duration) {
+	if store.Shared() {
+		name := "keyrequests:" + keyLimitSubject(identity)
+		count, err := incrWindow(name, 1, time.Minute)
+		if err == nil {
+			reset = time.Minute - time.Duration(time.Now().UnixNano()%int64(time.Minute))
+			if float64(count) <= rpm {
+				return 0, rpm - float64(count), reset
+			}
+			// 被拒绝的请求不占用窗口内的次数
+			incrWindow(name, -1, time.Minute)
+			return reset, 0, reset
+		}
+		logf(slog.LevelWarn, tr("redis_limit_fallback"), err)
+	}
+
+	wait, remaining = keyBucket(identity, rpm).take(1)
+	// 未超限时重置时间为令牌桶补满所需的时间
+	reset = wait
+	if reset == 0 {
+		reset = time.Duration((rpm - remaining) / rpm * float64(time.Minute))
+	}
+	return wait, remaining, reset
+}
+
+func keyBucket(identity string, rpm float64) *tokenBucket {
+	keyBuckets.mu.Lock()
+	defer keyBuckets.mu.Unlock()
+	now := time.Now()
+	if now.Sub(keyBuckets.sweep) >= time.Minute {
+		// 与 ipBuckets 相同，删除已经补满的桶，未加 -keys 时每个不同的原始密钥都会建一个桶
+		keyBuckets.sweep = now
+		for key, b := range keyBuckets.buckets {
+			b.mu.Lock()
+			b.refillLocked()
+			full := b.tokens >= b.capacity
+			b.mu.Unlock()
+			if full {
+				delete(keyBuckets.buckets, key)
+			}
+		}
+	}
+	bucket := keyBuckets.buckets[identity]
+	if bucket == nil || bucket.capacity != rpm {
+		bucket = newTokenBucket(rpm, 0)
+		keyBuckets.buckets[identity] = bucket
+	}
+	return bucket
+}
+
+// 请求完成后按实际用量扣减每日 token 额度
+func chargeKeyTokens(identity string, tokens int) {
+	if tokens <= 0 || keyLimitFor(identity).TPD <= 0 {
+		return
+	}
+	store.Incr(keyTokensKey(identity), float64(tokens), 48*time.Hour)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// 使用独立的内存存储、密钥和限额，结束后恢复
+func setupKeyLimits(t *testing.T, keys map[string]string, limits map[string]KeyLimit) {
+	t.Helper()
+	previousStore, previousKeys, previousLimits := store, credentials.clientKeys, keyLimits
+	store = newMemoryStore()
+	credentials.mu.Lock()
+	credentials.clientKeys = keys
+	credentials.mu.Unlock()
+	keyLimitsMu.Lock()
+	keyLimits = limits
+	keyLimitsMu.Unlock()
+	keyBuckets.mu.Lock()
+	keyBuckets.buckets = make(map[string]*tokenBucket)
+	keyBuckets.mu.Unlock()
+	t.Cleanup(func() {
+		store = previousStore
+		credentials.mu.Lock()
+		credentials.clientKeys = previousKeys
+		credentials.mu.Unlock()
+		keyLimitsMu.Lock()
+		keyLimits = previousLimits
+		keyLimitsMu.Unlock()
+	})
+}
+
+func TestCheckKeyLimits(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AuthMethods = "bearer"
+		c.KeyRPM = 2
+	})
+	setupKeyLimits(t, map[string]string{"sk-alice": "alice", "sk-bob": "bob", "sk-carol": "carol"}, map[string]KeyLimit{
+		"bob":   {RPM: 1},
+		"carol": {TPD: 50},
+	})
+
+	type step struct {
+		key     string
+		charge  int
+		wantErr string
+		headers map[string]string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "default requests per minute",
+			steps: []step{
+				{key: "sk-alice", headers: map[string]string{"x-ratelimit-limit-requests": "2", "x-ratelimit-remaining-requests": "1"}},
+				{key: "sk-alice", headers: map[string]string{"x-ratelimit-remaining-requests": "0"}},
+				{key: "sk-alice", wantErr: "rate_limit_exceeded"},
+			},
+		},
+		{
+			name: "per-key override",
+			steps: []step{
+				{key: "sk-bob", headers: map[string]string{"x-ratelimit-limit-requests": "1", "x-ratelimit-remaining-requests": "0"}},
+				{key: "sk-bob", wantErr: "rate_limit_exceeded"},
+			},
+		},
+		{
+			name: "unregistered keys are limited separately",
+			steps: []step{
+				{key: "sk-unknown-1"},
+				{key: "sk-unknown-1"},
+				{key: "sk-unknown-1", wantErr: "rate_limit_exceeded"},
+				{key: "sk-unknown-2", headers: map[string]string{"x-ratelimit-remaining-requests": "1"}},
+			},
+		},
+		{
+			name: "daily tokens",
+			steps: []step{
+				{key: "sk-carol", charge: 30, headers: map[string]string{"x-ratelimit-limit-tokens": "50", "x-ratelimit-remaining-tokens": "50", "x-ratelimit-limit-requests": ""}},
+				{key: "sk-carol", charge: 30, headers: map[string]string{"x-ratelimit-remaining-tokens": "20"}},
+				{key: "sk-carol", wantErr: "insufficient_quota", headers: map[string]string{"x-ratelimit-remaining-tokens": "0"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, s := range tt.steps {
+				r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+				r.Header.Set("Authorization", "Bearer "+s.key)
+				w := httptest.NewRecorder()
+				err := checkKeyLimits(w, r)
+				code := ""
+				if err != nil {
+					code = err.Code
+				}
+				if code != s.wantErr {
+					t.Fatalf("step %d: got error %q, want %q", i, code, s.wantErr)
+				}
+				if s.wantErr != "" && w.Header().Get("Retry-After") == "" {
+					t.Errorf("step %d: missing Retry-After", i)
+				}
+				for name, want := range s.headers {
+					if got := w.Header().Get(name); got != want {
+						t.Errorf("step %d: %s = %q, want %q", i, name, got, want)
+					}
+				}
+				chargeKeyTokens(clientIdentity(r), s.charge)
+			}
+		})
+	}
+}
+
+func TestCheckKeyLimitsDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.AuthMethods = "bearer" })
+	setupKeyLimits(t, nil, nil)
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		r.Header.Set("Authorization", "Bearer sk-any")
+		w := httptest.NewRecorder()
+		if err := checkKeyLimits(w, r); err != nil {
+			t.Fatalf("request %d limited: %+v", i, err)
+		}
+		if len(w.Header()) != 0 {
+			t.Errorf("unexpected rate limit headers %v", w.Header())
+		}
+	}
+}
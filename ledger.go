@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 每个请求一行的用量明细，追加写入 -usage-file，用于与 Cloudflare 账单对账
+type usageRecord struct {
+	Time             time.Time `json:"time"`
+	RequestID        string    `json:"request_id,omitempty"`
+	Key              string    `json:"key"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	LatencyMs        int64     `json:"latency_ms"`
+	Error            string    `json:"error,omitempty"`
+}
+
+var usageLedger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openUsageLedger() error {
+	if config().UsageFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(config().UsageFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	usageLedger.file = f
+	return nil
+}
+
+// 使用共享存储时每条明细同时写入存储，/v1/usage 和保留期清理以存储中的全集群记录为准；
+// 本地文件仍然追加写入，只包含本副本处理的请求
+func usageRecordKey(record usageRecord) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	// 定长的纳秒时间戳使键按时间排序
+	return fmt.Sprintf("usage:%020d:%s", record.Time.UnixNano(), hex.EncodeToString(b))
+}
+
+func appendUsageRecord(record usageRecord) {
+	line, _ := json.Marshal(record)
+	if config().UsageFile != "" && store.Shared() {
+		if err := store.Set(usageRecordKey(record), string(line), config().UsageRetention); err != nil {
+			logf(slog.LevelError, tr("usage_write_failed"), err)
+		}
+	}
+	usageLedger.mu.Lock()
+	defer usageLedger.mu.Unlock()
+	if usageLedger.file == nil {
+		return
+	}
+	if _, err := usageLedger.file.Write(append(line, '\n')); err != nil {
+		logf(slog.LevelError, tr("usage_write_failed"), err)
+	}
+}
+
+// 保留期清理时重写文件，需要在持有锁的情况下重新打开
+func rewriteUsageLedger(keep func(record usageRecord) bool) (int, error) {
+	if config().UsageFile == "" {
+		return 0, nil
+	}
+	usageLedger.mu.Lock()
+	defer usageLedger.mu.Unlock()
+
+	records, err := readUsageRecords()
+	if err != nil {
+		return 0, err
+	}
+	tmp := config().UsageFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	w := bufio.NewWriter(f)
+	for _, record := range records {
+		if !keep(record) {
+			purged++
+			continue
+		}
+		line, _ := json.Marshal(record)
+		w.Write(append(line, '\n'))
+	}
+	w.Flush()
+	f.Close()
+	if purged == 0 {
+		return 0, os.Remove(tmp)
+	}
+	if err := os.Rename(tmp, config().UsageFile); err != nil {
+		return 0, err
+	}
+	if usageLedger.file != nil {
+		usageLedger.file.Close()
+		usageLedger.file, err = os.OpenFile(config().UsageFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	return purged, err
+}
+
+func queryUsageRecords() ([]usageRecord, error) {
+	if store.Shared() {
+		return readSharedUsageRecords()
+	}
+	usageLedger.mu.Lock()
+	defer usageLedger.mu.Unlock()
+	return readUsageRecords()
+}
+
+func readSharedUsageRecords() ([]usageRecord, error) {
+	keys, err := store.Keys("usage:")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	records := make([]usageRecord, 0, len(keys))
+	for _, key := range keys {
+		value, ok, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		var record usageRecord
+		if ok && json.Unmarshal([]byte(value), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func readUsageRecords() ([]usageRecord, error) {
+	f, err := os.Open(config().UsageFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []usageRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record usageRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// start/end 接受 2006-01-02 或 RFC 3339 时间，只有日期的 end 包含当天
+func parseUsageTime(value string, endOfDay bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if endOfDay {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, true
+}
+
+type usageQueryTotals struct {
+	Requests         int `json:"requests"`
+	Errors           int `json:"errors"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// GET /v1/usage?start=2026-01-01&end=2026-01-31&key=alice&model=...：管理密钥可查询所有调用方，
+// 客户端密钥只能查询自己的用量
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	admin := authorizeAdmin(r)
+	if !admin && !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if config().UsageFile == "" {
+		writeError(w, http.StatusNotFound, "usage_disabled", "Usage accounting is not enabled on this server")
+		return
+	}
+
+	query := r.URL.Query()
+	var start, end time.Time
+	if v := query.Get("start"); v != "" {
+		var ok bool
+		if start, ok = parseUsageTime(v, false); !ok {
+			writeErrorParam(w, http.StatusBadRequest, "invalid_request", "start must be a date (2006-01-02) or RFC 3339 time", "start")
+			return
+		}
+	}
+	if v := query.Get("end"); v != "" {
+		var ok bool
+		if end, ok = parseUsageTime(v, true); !ok {
+			writeErrorParam(w, http.StatusBadRequest, "invalid_request", "end must be a date (2006-01-02) or RFC 3339 time", "end")
+			return
+		}
+	}
+	key := query.Get("key")
+	if !admin {
+		key = usageLabel(clientIdentity(r))
+	}
+	model := query.Get("model")
+
+	records, err := queryUsageRecords()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "usage_read_failed", err.Error())
+		return
+	}
+
+	data := []usageRecord{}
+	var totals usageQueryTotals
+	for _, record := range records {
+		if (!start.IsZero() && record.Time.Before(start)) || (!end.IsZero() && !record.Time.Before(end)) {
+			continue
+		}
+		if (key != "" && record.Key != key) || (model != "" && record.Model != model) {
+			continue
+		}
+		data = append(data, record)
+		totals.Requests++
+		if record.Error != "" {
+			totals.Errors++
+		}
+		totals.PromptTokens += record.PromptTokens
+		totals.CompletionTokens += record.CompletionTokens
+		totals.TotalTokens += record.TotalTokens
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"totals": totals,
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 以进程内存储模拟 Redis，供需要 Shared() 行为的测试使用
+type sharedMemoryStore struct {
+	*memoryStore
+}
+
+func (sharedMemoryStore) Shared() bool { return true }
+
+func TestSharedUsageLedger(t *testing.T) {
+	previous := store
+	store = sharedMemoryStore{newMemoryStore()}
+	t.Cleanup(func() { store = previous })
+	withConfig(t, func(c *Config) {
+		c.UsageFile = filepath.Join(t.TempDir(), "usage.jsonl")
+	})
+
+	now := time.Now()
+	for _, record := range []usageRecord{
+		{Time: now.Add(-time.Hour), Key: "b", Model: "m", TotalTokens: 5},
+		{Time: now.Add(-48 * time.Hour), Key: "a", Model: "m", TotalTokens: 3},
+	} {
+		appendUsageRecord(record)
+	}
+	// 本地文件没有打开，查询结果只能来自共享存储
+	records, err := queryUsageRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Key != "a" || records[1].Key != "b" {
+		t.Fatalf("records = %+v, want a then b", records)
+	}
+
+	purged, err := purgeSharedUsageRecords(now.Add(-24 * time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("purged = %d, %v, want 1", purged, err)
+	}
+	if records, _ = queryUsageRecords(); len(records) != 1 || records[0].Key != "b" {
+		t.Errorf("records after purge = %+v, want only b", records)
+	}
+}
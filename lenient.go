@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// 宽松模式下需要从字符串转换的字段；整数字段按整数解析，"100.5" 之类的值转成数字后由解析报出类型错误
+var lenientFloatFields = []string{"temperature", "top_p", "presence_penalty", "frequency_penalty"}
+var lenientIntFields = []string{"max_tokens", "max_completion_tokens", "n", "seed"}
+var lenientBoolFields = []string{"stream"}
+
+// 兼容部分前端发出的不规范请求：去掉尾随逗号、把字符串形式的数字和布尔值转换为正确类型、
+// 删除值为 null 的字段（包括 stream_options、tools[].function 和 messages[] 中的字段）；
+// 无法修复时原样返回，由后续解析报错
+func normalizeLenientJSON(body []byte) []byte {
+	cleaned := stripTrailingCommas(body)
+
+	var req map[string]interface{}
+	if err := json.Unmarshal(cleaned, &req); err != nil {
+		return body
+	}
+
+	normalizeLenientObject(req, lenientFloatFields, lenientIntFields, lenientBoolFields)
+	if opts, ok := req["stream_options"].(map[string]interface{}); ok {
+		normalizeLenientObject(opts, nil, nil, []string{"include_usage"})
+	}
+	if tools, ok := req["tools"].([]interface{}); ok {
+		for _, tool := range tools {
+			if tool, ok := tool.(map[string]interface{}); ok {
+				normalizeLenientObject(tool, nil, nil, nil)
+				if fn, ok := tool["function"].(map[string]interface{}); ok {
+					normalizeLenientObject(fn, nil, nil, []string{"strict"})
+				}
+			}
+		}
+	}
+	if messages, ok := req["messages"].([]interface{}); ok {
+		for _, message := range messages {
+			if message, ok := message.(map[string]interface{}); ok {
+				normalizeLenientObject(message, nil, nil, nil)
+			}
+		}
+	}
+
+	normalized, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+func normalizeLenientObject(obj map[string]interface{}, floatFields, intFields, boolFields []string) {
+	for key, value := range obj {
+		if value == nil {
+			delete(obj, key)
+		}
+	}
+	for _, key := range floatFields {
+		if s, ok := obj[key].(string); ok {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				obj[key] = f
+			}
+		}
+	}
+	for _, key := range intFields {
+		if s, ok := obj[key].(string); ok {
+			s = strings.TrimSpace(s)
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				obj[key] = i
+			} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+				obj[key] = f
+			}
+		}
+	}
+	for _, key := range boolFields {
+		switch v := obj[key].(type) {
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				obj[key] = b
+			}
+		case float64:
+			obj[key] = v != 0
+		}
+	}
+}
+
+// 删除字符串之外紧跟在 } 或 ] 之前的逗号
+func stripTrailingCommas(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	inString, escaped := false, false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if inString {
+			out = append(out, c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		}
+		if c == ',' {
+			j := i + 1
+			for j < len(body) && strings.ContainsRune(" \t\r\n", rune(body[j])) {
+				j++
+			}
+			if j < len(body) && (body[j] == '}' || body[j] == ']') {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
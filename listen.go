@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -listen/-admin-listen 中的每一项可以是：
+//   - TCP 地址，如 :10000、127.0.0.1:10001、[::1]:10000
+//   - unix:/run/gptoss2api.sock，Unix 套接字，权限为 0660，已存在的旧套接字文件会先删除
+//   - systemd 或 systemd:名称，使用 systemd 套接字激活传入的监听套接字；不带名称时取所有尚未使用的套接字，
+//     带名称时按 .socket 单元中的 FileDescriptorName= 选择。管理接口先于 API 打开，
+//     -admin-listen 必须带名称，否则会把 API 的套接字也占走
+const (
+	listenUnixPrefix = "unix:"
+	listenSystemd    = "systemd"
+)
+
+type systemdSocket struct {
+	name     string
+	listener net.Listener
+	claimed  bool
+}
+
+var systemdSockets struct {
+	once    sync.Once
+	mu      sync.Mutex
+	sockets []*systemdSocket
+	err     error
+}
+
+// 按 sd_listen_fds 的约定读取 LISTEN_PID/LISTEN_FDS/LISTEN_FDNAMES，文件描述符从 3 开始；
+// 读取后清除这些环境变量，避免子进程误用
+func loadSystemdSockets() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return
+	}
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(3+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			systemdSockets.err = fmt.Errorf("systemd socket %s: %v", name, err)
+			return
+		}
+		systemdSockets.sockets = append(systemdSockets.sockets, &systemdSocket{name: name, listener: l})
+	}
+}
+
+func claimSystemdSockets(name string) ([]net.Listener, error) {
+	systemdSockets.once.Do(loadSystemdSockets)
+	if systemdSockets.err != nil {
+		return nil, systemdSockets.err
+	}
+	systemdSockets.mu.Lock()
+	defer systemdSockets.mu.Unlock()
+	var listeners []net.Listener
+	for _, s := range systemdSockets.sockets {
+		if s.claimed || (name != "" && s.name != name) {
+			continue
+		}
+		s.claimed = true
+		listeners = append(listeners, s.listener)
+	}
+	if len(listeners) == 0 {
+		if name == "" {
+			return nil, fmt.Errorf("no systemd sockets passed to the process")
+		}
+		return nil, fmt.Errorf("no systemd socket named %q passed to the process", name)
+	}
+	return listeners, nil
+}
+
+func openListener(addr string) ([]net.Listener, error) {
+	switch {
+	case addr == listenSystemd:
+		return claimSystemdSockets("")
+	case strings.HasPrefix(addr, listenSystemd+":"):
+		return claimSystemdSockets(strings.TrimPrefix(addr, listenSystemd+":"))
+	case strings.HasPrefix(addr, listenUnixPrefix):
+		path := strings.TrimPrefix(addr, listenUnixPrefix)
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			// 上次异常退出留下的套接字文件
+			os.Remove(path)
+		}
+		l, err := listenUnixSocket(path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{l}, nil
+}
+
+// 打开逗号分隔的全部监听地址，任意一个失败时关闭已打开的并返回错误
+func openListeners(list string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		opened, err := openListener(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("listen %s: %v", addr, err)
+		}
+		listeners = append(listeners, opened...)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listen address configured")
+	}
+	return listeners, nil
+}
+
+func listenerAddrs(listeners []net.Listener) string {
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr().Network() + ":" + l.Addr().String()
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// 同一个 http.Server 同时服务所有监听地址，Shutdown 时一并关闭；配置了证书时全部使用 HTTPS。
+// 返回第一个结束的监听的错误
+func serveListeners(srv *http.Server, listeners []net.Listener) error {
+	if serverCert != nil {
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: serverCert.getCertificate,
+		}
+	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if serverCert != nil {
+				errs <- srv.ServeTLS(l, "", "")
+			} else {
+				errs <- srv.Serve(l)
+			}
+		}(l)
+	}
+	return <-errs
+}
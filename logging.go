@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// 按 -log-format 和 -log-level 初始化 slog；标准库 log 的输出也会转到这里，按 info 级别记录
+func initLogging() error {
+	if err := setLogLevel(config().LogLevel); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch config().LogFormat {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -log-format %q, expected json or text", config().LogFormat)
+	}
+	slog.SetDefault(slog.New(&redactHandler{handler}))
+	return nil
+}
+
+// 日志级别可以通过管理接口在运行时调整
+var logLevel = new(slog.LevelVar)
+
+func setLogLevel(value string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", value)
+	}
+	logLevel.Set(level)
+	return nil
+}
+
+// 以指定级别输出一条日志，消息沿用 tr() 的格式化文本
+func logf(level slog.Level, format string, args ...interface{}) {
+	slog.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "req_" + hex.EncodeToString(b)
+}
+
+type requestIDContextKey struct{}
+
+// 客户端传来的 X-Request-ID 只接受长度合理的可见 ASCII 字符，避免日志注入
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// 为每个请求分配 ID（或沿用客户端的 X-Request-ID），写入响应头并随上下文传递给日志和上游请求
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// 上游请求带上同一个 X-Request-ID；上游失败时记录 Cloudflare 返回的 cf-ray，便于向 Cloudflare 追查
+func traceUpstreamRequest(ctx context.Context, httpReq *http.Request) {
+	if id := requestID(ctx); id != "" {
+		httpReq.Header.Set("X-Request-ID", id)
+	}
+}
+
+func traceUpstreamResponse(ctx context.Context, resp *http.Response) {
+	if resp.StatusCode < http.StatusBadRequest {
+		return
+	}
+	slog.Warn(fmt.Sprintf(tr("upstream_failed_trace"), resp.StatusCode, resp.Header.Get("Cf-Ray")), "request_id", requestID(ctx))
+}
+
+// 写出前对消息和所有字符串属性做脱敏，调用方无需关心哪些内容可能含有密钥
+type redactHandler struct {
+	slog.Handler
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactSecrets(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactHandler{h.Handler.WithAttrs(redacted)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactSecrets(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttr(ga)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	}
+	return a
+}
+
+const redactedText = "[REDACTED]"
+
+// 认证头、api-key 头和 api_key 查询参数的值，无论是否是已知密钥都会隐藏
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?(?:bearer\s+|basic\s+)?)[^\s",]+`),
+	regexp.MustCompile(`(?i)(api[-_]key"?\s*[:=]\s*"?)[^\s",&]+`),
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+}
+
+// 隐藏日志中出现的 Cloudflare 令牌、客户端密钥、租户凭据和管理密钥
+func redactSecrets(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redactedText)
+	}
+	for _, secret := range knownSecrets() {
+		s = strings.ReplaceAll(s, secret, redactedText)
+	}
+	return s
+}
+
+func knownSecrets() []string {
+	credentials.mu.RLock()
+	secrets := []string{credentials.authToken, credentials.clientKey}
+	for key := range credentials.clientKeys {
+		secrets = append(secrets, key)
+	}
+	credentials.mu.RUnlock()
+	for _, t := range currentTenants() {
+		secrets = append(secrets, t.AuthToken, t.ClientKey)
+	}
+	secrets = append(secrets, accountPoolTokens()...)
+	secrets = append(secrets, providerSecrets()...)
+	secrets = append(secrets, config().AdminKey, config().RedisPassword, config().FallbackKey, config().AIGatewayToken)
+	if _, token, ok := strings.Cut(config().FallbackAccount, ":"); ok {
+		secrets = append(secrets, token)
+	}
+
+	nonEmpty := secrets[:0]
+	for _, secret := range secrets {
+		if secret != "" {
+			nonEmpty = append(nonEmpty, secret)
+		}
+	}
+	return nonEmpty
+}
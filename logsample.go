@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// 记录响应状态码，同时保留流式响应需要的 Flush
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// 按 -log-sample-rate 抽样记录成功请求的详细日志；未抽中的请求先缓存，出错时再补打，
+// 保证错误请求始终有完整日志
+type requestLog struct {
+	mu      sync.Mutex
+	sampled bool
+	logger  *slog.Logger
+	pending []string
+}
+
+// 每行日志带上请求 ID，使用具名密钥的请求还会标注密钥 ID，便于区分不同调用方
+func startRequestLog(w http.ResponseWriter, r *http.Request) (*statusRecorder, *requestLog) {
+	id := requestID(r.Context())
+	if id == "" {
+		id = newRequestID()
+	}
+	reqLog := &requestLog{
+		sampled: rand.Float64() < config().LogSampleRate,
+		logger:  slog.Default().With("request_id", id, "client_ip", clientIP(r).String()),
+	}
+	if id := requestKeyID(r); id != "" {
+		reqLog.logger = reqLog.logger.With("key", id)
+	}
+	return &statusRecorder{ResponseWriter: w}, reqLog
+}
+
+type requestLogContextKey struct{}
+
+// Provider 等拿不到 requestLog 参数的调用方从请求上下文中取得日志
+func withRequestLog(ctx context.Context, l *requestLog) context.Context {
+	return context.WithValue(ctx, requestLogContextKey{}, l)
+}
+
+func contextRequestLog(ctx context.Context) *requestLog {
+	l, _ := ctx.Value(requestLogContextKey{}).(*requestLog)
+	return l
+}
+
+func (l *requestLog) Printf(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	if l.sampled {
+		l.logger.Info(line)
+		return
+	}
+	l.mu.Lock()
+	l.pending = append(l.pending, line)
+	l.mu.Unlock()
+}
+
+// 完整的请求体和上游原始响应，-log-bodies=false 时完全不记录
+func (l *requestLog) Body(format string, args ...interface{}) {
+	if config().LogBodies {
+		l.Printf(format, args...)
+	}
+}
+
+func (l *requestLog) finish(rec *statusRecorder) {
+	if l.sampled || rec.status < http.StatusBadRequest {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.pending {
+		l.logger.Info(line)
+	}
+}
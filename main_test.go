@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// 测试不经过 main 注册命令行参数，flagValues 为零值；只设置被测代码依赖的参数，结束后恢复
+func withConfig(t *testing.T, update func(c *Config)) {
+	t.Helper()
+	previous := flagValues
+	update(&flagValues)
+	publishConfig()
+	t.Cleanup(func() {
+		flagValues = previous
+		publishConfig()
+	})
+}
+
+// 按 JSON 比较，避免 map、指针和 interface{} 的类型差异影响断言
+func assertJSON(t *testing.T, got interface{}, want string) {
+	t.Helper()
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var gotValue, wantValue interface{}
+	json.Unmarshal(data, &gotValue)
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("invalid expected JSON %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+}
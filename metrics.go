@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 简单的进程内指标，按 Prometheus 文本格式在 /metrics 暴露
+type metricsRegistry struct {
+	mu     sync.Mutex
+	types  map[string]string
+	values map[string]float64
+}
+
+var metrics = &metricsRegistry{
+	types:  make(map[string]string),
+	values: make(map[string]float64),
+}
+
+// labels 按 key, value 成对传入
+func seriesKey(name string, labels []string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metricsRegistry) add(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	m.types[name] = "counter"
+	m.values[seriesKey(name, labels)] += delta
+	m.mu.Unlock()
+	statsd.send(name, delta, "c", labels)
+}
+
+func (m *metricsRegistry) inc(name string, labels ...string) {
+	m.add(name, 1, labels...)
+}
+
+func (m *metricsRegistry) set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	m.types[name] = "gauge"
+	m.values[seriesKey(name, labels)] = value
+	m.mu.Unlock()
+	statsd.send(name, value, "g", labels)
+}
+
+// 耗时以 summary 的 _sum/_count 形式暴露，StatsD 中作为 timing 发送
+func (m *metricsRegistry) observe(name string, d time.Duration, labels ...string) {
+	m.mu.Lock()
+	m.types[name] = "summary"
+	m.values[seriesKey(name+"_sum", labels)] += d.Seconds()
+	m.values[seriesKey(name+"_count", labels)]++
+	m.mu.Unlock()
+	statsd.send(name, float64(d.Milliseconds()), "ms", labels)
+}
+
+func (m *metricsRegistry) snapshot() (map[string]string, map[string]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := make(map[string]string, len(m.types))
+	for k, v := range m.types {
+		types[k] = v
+	}
+	values := make(map[string]float64, len(m.values))
+	for k, v := range m.values {
+		values[k] = v
+	}
+	return types, values
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	types, values := metrics.snapshot()
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	series := make([]string, 0, len(values))
+	for key := range values {
+		series = append(series, key)
+	}
+	sort.Strings(series)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, types[name])
+		for _, key := range series {
+			base := key
+			if i := strings.Index(key, "{"); i >= 0 {
+				base = key[:i]
+			}
+			if base == name || (types[name] == "summary" && (base == name+"_sum" || base == name+"_count")) {
+				fmt.Fprintf(w, "%s %g\n", key, values[key])
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 1x1 像素的透明 PNG，模拟模式下作为图片生成的结果
+const mockImagePNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+// -mock 模式下发往 Cloudflare 的请求不会离开本机：由这个 RoundTripper 生成与上游格式一致的响应，
+// 所有接口的转换、流式转发、用量统计和限额逻辑照常运行，下游应用的测试不需要 Cloudflare 凭据，也不产生费用。
+// 回复内容为 -mock-response，未设置时原样返回最后一条用户消息；流式响应按词输出，每块间隔 -mock-delay。
+// 备用上游等其他主机的请求照常发出
+type mockTransport struct {
+	next http.RoundTripper
+}
+
+func isCloudflareHost(host string) bool {
+	return host == "api.cloudflare.com" || host == "gateway.ai.cloudflare.com"
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isCloudflareHost(req.URL.Host) {
+		return t.next.RoundTrip(req)
+	}
+	var body map[string]interface{}
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		req.Body.Close()
+		json.Unmarshal(data, &body)
+	}
+	path := req.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/models/search"):
+		return mockJSON(req, map[string]interface{}{"success": true, "result": []map[string]interface{}{{"name": config().Model, "description": "mock model"}}}), nil
+	case strings.HasSuffix(path, "/v1/responses"):
+		return mockResponses(req, body), nil
+	case strings.Contains(path, "/run/") || strings.Contains(path, "/workers-ai/"):
+		return mockRun(req, path, body), nil
+	}
+	return mockJSON(req, map[string]interface{}{"success": false, "errors": []map[string]string{{"message": "mock: unsupported path " + path}}}), nil
+}
+
+func mockJSON(req *http.Request, value interface{}) *http.Response {
+	data, _ := json.Marshal(value)
+	return mockResponse(req, "application/json", io.NopCloser(bytes.NewReader(data)))
+}
+
+func mockResponse(req *http.Request, contentType string, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       body,
+		Request:    req,
+	}
+}
+
+// 请求中最后一条用户消息的文本；input 可以是字符串或消息数组
+func mockReply(input interface{}) string {
+	if config().MockResponse != "" {
+		return config().MockResponse
+	}
+	if text, ok := input.(string); ok {
+		return text
+	}
+	items, _ := input.([]interface{})
+	for i := len(items) - 1; i >= 0; i-- {
+		item, _ := items[i].(map[string]interface{})
+		if item["role"] != "user" {
+			continue
+		}
+		switch content := item["content"].(type) {
+		case string:
+			return content
+		case []interface{}:
+			var texts []string
+			for _, part := range content {
+				if p, _ := part.(map[string]interface{}); p != nil {
+					if text, ok := p["text"].(string); ok {
+						texts = append(texts, text)
+					}
+				}
+			}
+			return strings.Join(texts, "\n")
+		}
+	}
+	return "This is a mock response."
+}
+
+func mockResponses(req *http.Request, body map[string]interface{}) *http.Response {
+	model, _ := body["model"].(string)
+	reply := mockReply(body["input"])
+	inputData, _ := json.Marshal(body["input"])
+	instructions, _ := body["instructions"].(string)
+	prompt := estimateTextTokens(string(inputData)) + estimateTextTokens(instructions)
+
+	status := "completed"
+	var incomplete *IncompleteDetails
+	if limit, ok := body["max_output_tokens"].(float64); ok && estimateTextTokens(reply) > int(limit) {
+		words := mockWords(reply)
+		for len(words) > 0 && estimateTextTokens(strings.Join(words, "")) > int(limit) {
+			words = words[:len(words)-1]
+		}
+		reply = strings.Join(words, "")
+		status = "incomplete"
+		incomplete = &IncompleteDetails{Reason: "max_output_tokens"}
+	}
+	call := mockToolCall(body, reply)
+	if call != nil {
+		reply = call.Arguments
+	}
+	completion := estimateTextTokens(reply)
+	final := CloudflareResponse{
+		ID:                fmt.Sprintf("resp_mock%d", time.Now().UnixNano()),
+		Created:           time.Now().Unix(),
+		Model:             model,
+		Object:            "response",
+		Status:            status,
+		IncompleteDetails: incomplete,
+		Output: []CloudflareOutputItem{{
+			ID:      "msg_mock",
+			Type:    "message",
+			Role:    "assistant",
+			Status:  "completed",
+			Content: []CloudflareContentItem{{Type: "output_text", Text: reply}},
+		}},
+		Usage: CloudflareUsage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion},
+	}
+	if call != nil {
+		final.Output = []CloudflareOutputItem{*call}
+	}
+	if stream, _ := body["stream"].(bool); !stream {
+		return mockJSON(req, final)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		send := func(event map[string]interface{}) bool {
+			data, _ := json.Marshal(event)
+			_, err := fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event["type"], data)
+			return err == nil
+		}
+		send(map[string]interface{}{"type": "response.created", "response": map[string]interface{}{"id": final.ID, "model": model, "status": "in_progress"}})
+		pieces, delta := mockWords(reply), map[string]interface{}{"type": "response.output_text.delta"}
+		if call != nil {
+			added := *call
+			added.Arguments = ""
+			send(map[string]interface{}{"type": "response.output_item.added", "output_index": 0, "item": added})
+			pieces, delta = mockChunks(call.Arguments, 8), map[string]interface{}{"type": "response.function_call_arguments.delta", "item_id": call.ID, "output_index": 0}
+		}
+		for _, piece := range pieces {
+			select {
+			case <-req.Context().Done():
+				writer.CloseWithError(req.Context().Err())
+				return
+			case <-time.After(config().MockDelay):
+			}
+			delta["delta"] = piece
+			if !send(delta) {
+				return
+			}
+		}
+		if call != nil {
+			send(map[string]interface{}{"type": "response.function_call_arguments.done", "item_id": call.ID, "output_index": 0, "arguments": call.Arguments})
+			send(map[string]interface{}{"type": "response.output_item.done", "output_index": 0, "item": call})
+		}
+		eventType := "response.completed"
+		if incomplete != nil {
+			eventType = "response.incomplete"
+		}
+		send(map[string]interface{}{"type": eventType, "response": final})
+		writer.Close()
+	}()
+	return mockResponse(req, "text/event-stream", reader)
+}
+
+// tool_choice 为 required 或指定了函数时模拟一次函数调用，参数为 {"input": 回复文本}；
+// auto 时始终直接回复，便于测试客户端的两种分支
+func mockToolCall(body map[string]interface{}, reply string) *CloudflareOutputItem {
+	tools, _ := body["tools"].([]interface{})
+	if len(tools) == 0 {
+		return nil
+	}
+	tool, _ := tools[0].(map[string]interface{})
+	name, _ := tool["name"].(string)
+	switch choice := body["tool_choice"].(type) {
+	case string:
+		if choice != "required" {
+			return nil
+		}
+	case map[string]interface{}:
+		if n, ok := choice["name"].(string); ok {
+			name = n
+		}
+	default:
+		return nil
+	}
+	arguments, _ := json.Marshal(map[string]string{"input": reply})
+	return &CloudflareOutputItem{
+		ID:        "fc_mock",
+		Type:      "function_call",
+		Status:    "completed",
+		CallID:    fmt.Sprintf("call_mock%d", time.Now().UnixNano()),
+		Name:      name,
+		Arguments: string(arguments),
+	}
+}
+
+// 按固定字节数切分，用于模拟参数增量
+func mockChunks(text string, size int) []string {
+	var chunks []string
+	for len(text) > size {
+		chunks = append(chunks, text[:size])
+		text = text[size:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// 按词切分，空白附在词的前面，拼接后与原文一致
+func mockWords(text string) []string {
+	var words []string
+	start := 0
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) && !unicode.IsSpace(rune(text[i-1])) {
+			words = append(words, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// Workers AI run 接口按模型类型返回：语音识别、向量、图片，其余按文本生成处理
+func mockRun(req *http.Request, path string, body map[string]interface{}) *http.Response {
+	switch {
+	case strings.Contains(path, "whisper"):
+		return mockJSON(req, map[string]interface{}{"result": map[string]interface{}{
+			"text":  "mock transcription",
+			"words": []WhisperWord{{Word: "mock", Start: 0, End: 0.5}, {Word: "transcription", Start: 0.5, End: 1.2}},
+		}})
+	case strings.Contains(path, "bge") || strings.Contains(path, "embedding"):
+		texts, _ := body["text"].([]interface{})
+		data := make([][]float64, len(texts))
+		for i, text := range texts {
+			// 按文本内容生成确定的向量，相同输入得到相同结果
+			vector := make([]float64, 8)
+			for j, b := range []byte(fmt.Sprint(text)) {
+				vector[j%len(vector)] += float64(b) / 255
+			}
+			data[i] = vector
+		}
+		return mockJSON(req, map[string]interface{}{"result": map[string]interface{}{"shape": []int{len(data), 8}, "data": data}})
+	case strings.Contains(path, "flux") || strings.Contains(path, "stable-diffusion") || strings.Contains(path, "dreamshaper") || strings.Contains(path, "lucid"):
+		image, _ := base64.StdEncoding.DecodeString(mockImagePNG)
+		return mockResponse(req, "image/png", io.NopCloser(bytes.NewReader(image)))
+	}
+	reply := mockReply(body["messages"])
+	if prompt, ok := body["prompt"].(string); ok && config().MockResponse == "" {
+		reply = prompt
+	}
+	return mockJSON(req, map[string]interface{}{"result": map[string]interface{}{
+		"response": reply,
+		"usage":    CloudflareUsage{CompletionTokens: estimateTextTokens(reply), TotalTokens: estimateTextTokens(reply)},
+	}})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// 按模型配置的默认采样参数，只在客户端没有传对应字段时使用
+type ModelDefaults struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxTokens       *int     `json:"max_tokens,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"`
+}
+
+// -model-defaults 文件的键可以是客户端使用的模型名（别名）或上游模型名，支持 path.Match 通配符
+var modelDefaults struct {
+	mu       sync.RWMutex
+	byModel  map[string]ModelDefaults
+	patterns []string
+}
+
+func loadModelDefaults() error {
+	byModel := map[string]ModelDefaults{}
+	if config().ModelDefaultsFile != "" {
+		data, err := os.ReadFile(config().ModelDefaultsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &byModel); err != nil {
+			return fmt.Errorf("invalid -model-defaults file: %v", err)
+		}
+	}
+	var patterns []string
+	for pattern, defaults := range byModel {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid -model-defaults pattern %q", pattern)
+		}
+		if err := validateReasoningEffort(defaults.ReasoningEffort); err != nil {
+			return fmt.Errorf("invalid -model-defaults entry %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	modelDefaults.mu.Lock()
+	modelDefaults.byModel = byModel
+	modelDefaults.patterns = patterns
+	modelDefaults.mu.Unlock()
+	return nil
+}
+
+// 客户端使用的模型名优先于上游模型名，精确匹配优先于通配符
+func modelDefaultsFor(requested, model string) (ModelDefaults, bool) {
+	modelDefaults.mu.RLock()
+	defer modelDefaults.mu.RUnlock()
+	for _, name := range []string{requested, model} {
+		if defaults, ok := modelDefaults.byModel[name]; ok && name != "" {
+			return defaults, true
+		}
+	}
+	for _, name := range []string{requested, model} {
+		for _, pattern := range modelDefaults.patterns {
+			if ok, _ := path.Match(pattern, name); ok && name != "" {
+				return modelDefaults.byModel[pattern], true
+			}
+		}
+	}
+	return ModelDefaults{}, false
+}
+
+func validateReasoningEffort(effort string) error {
+	switch effort {
+	case "", "low", "medium", "high":
+		return nil
+	}
+	return fmt.Errorf("reasoning_effort must be low, medium or high")
+}
+
+// 先补上模型默认值，再把超出范围的 temperature、top_p 和 max_tokens 收回到合法范围，
+// 通过 Warning 响应头说明调整，而不是把请求交给上游报错。需要在 applyMaxTokensPolicy 之前调用
+func applyModelDefaults(w http.ResponseWriter, requested, model string, openaiReq *OpenAIRequest) {
+	if defaults, ok := modelDefaultsFor(requested, model); ok {
+		if openaiReq.Temperature == nil && defaults.Temperature != nil {
+			temperature := *defaults.Temperature
+			openaiReq.Temperature = &temperature
+		}
+		if openaiReq.TopP == nil && defaults.TopP != nil {
+			topP := *defaults.TopP
+			openaiReq.TopP = &topP
+		}
+		if openaiReq.MaxTokens == nil && openaiReq.MaxCompletionTokens == nil && defaults.MaxTokens != nil {
+			maxTokens := *defaults.MaxTokens
+			openaiReq.MaxTokens = &maxTokens
+		}
+		if openaiReq.ReasoningEffort == "" {
+			openaiReq.ReasoningEffort = defaults.ReasoningEffort
+		}
+	}
+
+	clampFloat(w, "temperature", openaiReq.Temperature, 0, 2)
+	clampFloat(w, "top_p", openaiReq.TopP, 0, 1)
+	for name, value := range map[string]*int{"max_tokens": openaiReq.MaxTokens, "max_completion_tokens": openaiReq.MaxCompletionTokens} {
+		if value != nil && *value < 1 {
+			*value = 1
+			warnClamped(w, name, "1")
+		}
+	}
+}
+
+func clampFloat(w http.ResponseWriter, name string, value *float64, low, high float64) {
+	if value == nil || (*value >= low && *value <= high) {
+		return
+	}
+	*value = min(max(*value, low), high)
+	warnClamped(w, name, strconv.FormatFloat(*value, 'g', -1, 64))
+}
+
+func warnClamped(w http.ResponseWriter, name, value string) {
+	w.Header().Add("Warning", fmt.Sprintf(`299 gptoss2api "%s was out of range and has been clamped to %s"`, name, value))
+	metrics.inc("gptoss2api_params_clamped_total", "param", name)
+}
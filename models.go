@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 对外暴露的模型名 → Cloudflare 模型，-model-aliases 中的配置会覆盖同名的内置别名
+var builtinModelAliases = map[string]string{
+	"gpt-oss-120b": "@cf/openai/gpt-oss-120b",
+	"gpt-oss-20b":  "@cf/openai/gpt-oss-20b",
+}
+
+var modelAliases = map[string]string{}
+
+// 别名可以通过管理接口在运行时修改
+var modelAliasesMu sync.RWMutex
+
+func lookupModelAlias(name string) (string, bool) {
+	modelAliasesMu.RLock()
+	defer modelAliasesMu.RUnlock()
+	target, ok := modelAliases[name]
+	return target, ok
+}
+
+func modelAliasSnapshot() map[string]string {
+	modelAliasesMu.RLock()
+	defer modelAliasesMu.RUnlock()
+	snapshot := make(map[string]string, len(modelAliases))
+	for alias, target := range modelAliases {
+		snapshot[alias] = target
+	}
+	return snapshot
+}
+
+// 解析 -model-aliases，格式为 "alias=@cf/model,alias=@cf/model"；重新加载时以内置别名为基础重建
+func loadModelAliases() error {
+	aliases := make(map[string]string, len(builtinModelAliases))
+	for alias, target := range builtinModelAliases {
+		aliases[alias] = target
+	}
+	for _, item := range strings.Split(config().ModelAliases, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		alias, target, ok := strings.Cut(item, "=")
+		alias, target = strings.TrimSpace(alias), strings.TrimSpace(target)
+		if !ok || alias == "" || target == "" {
+			return fmt.Errorf("invalid -model-aliases entry %q, expected alias=model", item)
+		}
+		aliases[alias] = target
+	}
+	modelAliasesMu.Lock()
+	modelAliases = aliases
+	modelAliasesMu.Unlock()
+	return nil
+}
+
+// 按请求中的 model 字段选择上游模型：别名映射到对应模型，已登记的 Cloudflare 模型名原样使用，
+// 其他名称（例如客户端写死的 gpt-3.5-turbo）使用默认模型，默认模型参与灰度发布
+func resolveModel(ctx context.Context, requested string) string {
+	aliases := modelAliasSnapshot()
+	if target, ok := aliases[requested]; ok {
+		return target
+	}
+	if requested != "" && requested != upstreamModel(ctx) {
+		// 模型发现列出的模型也可以直接使用
+		if isDiscoveredModel(requested) {
+			return requested
+		}
+		for _, target := range aliases {
+			if target == requested {
+				return requested
+			}
+		}
+	}
+	return selectModel(ctx)
+}
+
+// 图片和音频接口的模型选择：OpenAI 的模型名（dall-e-3、whisper-1 等）使用对应的默认模型，
+// Workers AI 的模型 ID（如 @cf/openai/whisper）按请求原样使用
+func workersAIModel(requested, fallback string) string {
+	if strings.HasPrefix(requested, "@cf/") || strings.HasPrefix(requested, "@hf/") {
+		return requested
+	}
+	return fallback
+}
+
+// /v1/models 列出默认模型和所有别名
+func listModelIDs(ctx context.Context) []string {
+	ids := []string{upstreamModel(ctx)}
+	snapshot := modelAliasSnapshot()
+	aliases := make([]string, 0, len(snapshot))
+	for alias := range snapshot {
+		if alias != ids[0] {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return append(ids, aliases...)
+}
@@ -3,34 +3,208 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Config struct {
-	AccountID string
-	Model     string
-	AuthToken string
-	Port      string
-	ClientKey string
+	AccountID             string
+	Model                 string
+	AuthToken             string
+	Port                  string
+	ClientKey             string
+	ImageModel            string
+	ImageEditModel        string
+	ImageVariationModel   string
+	AudioModel            string
+	HealthInterval        time.Duration
+	BreakerThreshold      int
+	BreakerCooldown       time.Duration
+	Warmup                bool
+	AlertWebhook          string
+	AlertWindow           time.Duration
+	AlertCooldown         time.Duration
+	AlertErrorRate        float64
+	AlertUpstreamFailures int
+	StatsdAddr            string
+	StatsdPrefix          string
+	StatsdDogstatsd       bool
+	Lang                  string
+	ChaosLatency          time.Duration
+	ChaosErrorRate        float64
+	ChaosDropRate         float64
+	MaxStreamsPerKey      int
+	UpstreamRPM           float64
+	UpstreamTPM           float64
+	RedisAddr             string
+	RedisPassword         string
+	RedisDB               int
+	RedisPrefix           string
+	TokenFile             string
+	KeyFile               string
+	CredentialPoll        time.Duration
+	RoutePrefix           string
+	TenantsFile           string
+	RulesFile             string
+	Timings               bool
+	CanaryModel           string
+	CanaryPercent         float64
+	CapabilitiesFile      string
+	Lenient               bool
+	BasicAuth             bool
+	LogSampleRate         float64
+	ReportInterval        time.Duration
+	ReportFile            string
+	ReportWebhook         string
+	NeuronDailyLimit      float64
+	Footer                string
+	DefaultMaxTokens      int
+	MaxTokensCap          int
+	Store                 string
+	TrustedProxies        string
+	GeoIPDB               string
+	GeoAllow              string
+	GeoDeny               string
+	AdminKey              string
+	ReplayTTL             time.Duration
+	ReportRetention       time.Duration
+	RetentionInterval     time.Duration
+	Coalesce              bool
+	SlowRequest           time.Duration
+	ConfigFile            string
+	ClientKeys            string
+	ModelAliases          string
+	ReasoningMode         string
+	ReadTimeout           time.Duration
+	WriteTimeout          time.Duration
+	IdleTimeout           time.Duration
+	ShutdownTimeout       time.Duration
+	MaxRetries            int
+	RetryBackoff          time.Duration
+	KeysFile              string
+	LogLevel              string
+	LogFormat             string
+	LogBodies             bool
+	EmbeddingModel        string
+	Accounts              string
+	AccountBalance        string
+	AccountEject          time.Duration
+	FallbackURL           string
+	FallbackKey           string
+	FallbackModel         string
+	FallbackAccount       string
+	FailoverOn            string
+	KeyRPM                float64
+	KeyTPD                float64
+	KeyLimitsFile         string
+	UsageFile             string
+	UsageRetention        time.Duration
+	CacheTTL              time.Duration
+	CacheSize             int
+	JSONRetries           int
+	MaxConcurrent         int
+	QueueSize             int
+	QueueTimeout          time.Duration
+	AdminPort             string
+	WatchConfig           bool
+	TLSCert               string
+	TLSKey                string
+	SystemPrompt          string
+	SystemPromptsFile     string
+	SystemPromptMode      string
+	MaxChoices            int
+	StreamChunking        string
+	StreamDelay           time.Duration
+	SSEHeartbeat          time.Duration
+
+	UpstreamConnectTimeout      time.Duration
+	UpstreamHeaderTimeout       time.Duration
+	UpstreamTimeout             time.Duration
+	UpstreamStreamHeaderTimeout time.Duration
+	UpstreamStreamTimeout       time.Duration
+	UpstreamMaxIdleConns        int
+	UpstreamIdleTimeout         time.Duration
+	UpstreamHTTP2               bool
+	Proxy                       string
+	AIGateway                   string
+	AIGatewayToken              string
+	VisionModel                 string
+	ImageURLTTL                 time.Duration
+	ImageBaseURL                string
+	TokenizerFile               string
+	ContextTrim                 string
+	ModelDefaultsFile           string
+	ModelDiscoveryTTL           time.Duration
+	Mock                        bool
+	MockResponse                string
+	MockDelay                   time.Duration
+	Cassette                    string
+	CassetteMode                string
+	ProvidersFile               string
+	Playground                  bool
+	AccessLog                   string
+	AccessLogFormat             string
+	AccessLogMaxSize            int
+	AccessLogBackups            int
+	MaxBodySize                 int
+	AuthMethods                 string
+	IPAllow                     string
+	IPDeny                      string
+	IPRPM                       float64
+	IPBurst                     float64
+	Listen                      string
+	AdminListen                 string
+	QueueStatusInterval         time.Duration
 }
 
 type OpenAIRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Stream      bool      `json:"stream,omitempty"`
-	Temperature *float64  `json:"temperature,omitempty"`
-	TopP        *float64  `json:"top_p,omitempty"`
+	Model               string          `json:"model"`
+	Messages            []Message       `json:"messages"`
+	Stream              bool            `json:"stream,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"`
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`
+	Tools               []Tool          `json:"tools,omitempty"`
+	ToolChoice          interface{}     `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool           `json:"parallel_tool_calls,omitempty"`
+	ReasoningMode       string          `json:"reasoning_mode,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	Stop                StopSequences   `json:"stop,omitempty"`
+	N                   *int            `json:"n,omitempty"`
+	StreamChunking      string          `json:"stream_chunking,omitempty"`
+	StreamDelayMs       *int            `json:"stream_delay_ms,omitempty"`
+	ReasoningEffort     string          `json:"reasoning_effort,omitempty"`
+	User                string          `json:"user,omitempty"`
+	Cache               string          `json:"cache,omitempty"`
+}
+
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Message struct {
-	Role    string      `json:"role"`
-	Content interface{} `json:"content"`
+	Role             string      `json:"role"`
+	Content          interface{} `json:"content"`
+	ReasoningContent string      `json:"reasoning_content,omitempty"`
+	Refusal          string      `json:"refusal,omitempty"`
+	ToolCalls        []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID       string      `json:"tool_call_id,omitempty"`
 }
 
 type OpenAIResponse struct {
@@ -40,6 +214,16 @@ type OpenAIResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
+	Timings *Timings `json:"x_timings,omitempty"`
+}
+
+// 调试用的耗时统计，开启 -timings 后以 x_timings 扩展字段返回
+type Timings struct {
+	UpstreamLatencyMs int64   `json:"upstream_latency_ms"`
+	TTFTMs            int64   `json:"ttft_ms"`
+	TokensPerSecond   float64 `json:"tokens_per_second"`
+	Retries           int     `json:"retries"`
+	Account           string  `json:"account"`
 }
 
 type Choice struct {
@@ -55,32 +239,54 @@ type Usage struct {
 }
 
 type CloudflareRequest struct {
-	Model       string      `json:"model"`
-	Input       interface{} `json:"input"`
-	Temperature *float64    `json:"temperature,omitempty"`
-	TopP        *float64    `json:"top_p,omitempty"`
+	Model             string                `json:"model"`
+	Instructions      string                `json:"instructions,omitempty"`
+	Input             interface{}           `json:"input"`
+	Temperature       *float64              `json:"temperature,omitempty"`
+	TopP              *float64              `json:"top_p,omitempty"`
+	MaxOutputTokens   *int                  `json:"max_output_tokens,omitempty"`
+	Stream            bool                  `json:"stream,omitempty"`
+	Tools             []interface{}         `json:"tools,omitempty"`
+	ToolChoice        interface{}           `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                 `json:"parallel_tool_calls,omitempty"`
+	Text              *CloudflareTextConfig `json:"text,omitempty"`
+	Reasoning         *CloudflareReasoning  `json:"reasoning,omitempty"`
+}
+
+type CloudflareReasoning struct {
+	Effort string `json:"effort"`
 }
 
 type CloudflareResponse struct {
-	ID      string                 `json:"id"`
-	Created int64                  `json:"created_at"`
-	Model   string                 `json:"model"`
-	Object  string                 `json:"object"`
-	Output  []CloudflareOutputItem `json:"output"`
-	Usage   CloudflareUsage        `json:"usage"`
+	ID                string                 `json:"id"`
+	Created           int64                  `json:"created_at"`
+	Model             string                 `json:"model"`
+	Object            string                 `json:"object"`
+	Status            string                 `json:"status,omitempty"`
+	IncompleteDetails *IncompleteDetails     `json:"incomplete_details,omitempty"`
+	Output            []CloudflareOutputItem `json:"output"`
+	Usage             CloudflareUsage        `json:"usage"`
+}
+
+type IncompleteDetails struct {
+	Reason string `json:"reason"`
 }
 
 type CloudflareOutputItem struct {
-	ID      string                  `json:"id"`
-	Content []CloudflareContentItem `json:"content"`
-	Role    string                  `json:"role,omitempty"`
-	Type    string                  `json:"type"`
-	Status  string                  `json:"status,omitempty"`
+	ID        string                  `json:"id"`
+	Content   []CloudflareContentItem `json:"content"`
+	Role      string                  `json:"role,omitempty"`
+	Type      string                  `json:"type"`
+	Status    string                  `json:"status,omitempty"`
+	CallID    string                  `json:"call_id,omitempty"`
+	Name      string                  `json:"name,omitempty"`
+	Arguments string                  `json:"arguments,omitempty"`
 }
 
 type CloudflareContentItem struct {
-	Text string `json:"text"`
-	Type string `json:"type"`
+	Text    string `json:"text"`
+	Type    string `json:"type"`
+	Refusal string `json:"refusal,omitempty"`
 }
 
 type CloudflareUsage struct {
@@ -89,243 +295,815 @@ type CloudflareUsage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
-var config Config
+// 命令行参数、环境变量和配置文件的值解析到 flagValues；请求处理通过 config() 读取当前生效的只读快照，
+// 启动和重新加载配置时整体替换，读取方不会看到修改到一半的配置
+var (
+	flagValues    Config
+	currentConfig atomic.Pointer[Config]
+)
+
+func config() *Config {
+	if c := currentConfig.Load(); c != nil {
+		return c
+	}
+	// 启动阶段尚未发布快照，此时只有主 goroutine 在读写参数
+	return &flagValues
+}
+
+func publishConfig() {
+	c := flagValues
+	currentConfig.Store(&c)
+}
 
 func main() {
-	flag.StringVar(&config.AccountID, "id", "", "Cloudflare Account ID")
-	flag.StringVar(&config.Model, "model", "@cf/openai/gpt-oss-120b", "Cloudflare Model")
-	flag.StringVar(&config.AuthToken, "token", "", "Cloudflare Auth Token")
-	flag.StringVar(&config.Port, "port", "10000", "Server Port")
-	flag.StringVar(&config.ClientKey, "key", "", "Client Authorization Key")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "service":
+			runService(os.Args[2:])
+			return
+		}
+	}
+
+	flag.StringVar(&flagValues.ConfigFile, "config", "", "JSON/YAML Config File (flags > GPTOSS2API_* env > file)")
+	flag.StringVar(&flagValues.SystemPrompt, "system-prompt", "", "System Prompt Injected Into Every Request")
+	flag.StringVar(&flagValues.SystemPromptsFile, "system-prompts", "", "JSON File With Per-Model System Prompts {\"@cf/model\": \"prompt\"} (overrides -system-prompt)")
+	flag.StringVar(&flagValues.SystemPromptMode, "system-prompt-mode", systemPromptPrepend, "How To Inject The System Prompt: prepend (system message) or instructions")
+	flag.IntVar(&flagValues.MaxChoices, "max-choices", 8, "Maximum n (Choices Per Chat Request, Each Is A Separate Upstream Call)")
+	flag.StringVar(&flagValues.StreamChunking, "stream-chunking", chunkUpstream, "Streaming Content Chunks: upstream, rune, word, message or N (characters per chunk)")
+	flag.DurationVar(&flagValues.StreamDelay, "stream-delay", 0, "Delay Between Streaming Content Chunks To Pace Output")
+	flag.DurationVar(&flagValues.SSEHeartbeat, "sse-heartbeat", 15*time.Second, "Interval Of SSE Keep-alive Comments Sent Before The First Chunk (0 to disable)")
+	flag.DurationVar(&flagValues.UpstreamConnectTimeout, "upstream-connect-timeout", 10*time.Second, "Upstream TCP/TLS Connect Timeout")
+	flag.DurationVar(&flagValues.UpstreamHeaderTimeout, "upstream-header-timeout", 0, "Non-streaming Upstream Response Header Timeout (0 for none besides -upstream-timeout)")
+	flag.DurationVar(&flagValues.UpstreamTimeout, "upstream-timeout", 5*time.Minute, "Non-streaming Upstream Total Timeout Including The Body (0 for none)")
+	flag.DurationVar(&flagValues.UpstreamStreamHeaderTimeout, "upstream-stream-header-timeout", time.Minute, "Streaming Upstream Response Header Timeout (0 for none)")
+	flag.DurationVar(&flagValues.UpstreamStreamTimeout, "upstream-stream-timeout", 0, "Streaming Upstream Total Timeout (0 for none)")
+	flag.IntVar(&flagValues.UpstreamMaxIdleConns, "upstream-max-idle-conns", 64, "Idle Keep-alive Connections Kept Per Upstream Host")
+	flag.DurationVar(&flagValues.UpstreamIdleTimeout, "upstream-idle-timeout", 90*time.Second, "How Long Idle Upstream Connections Are Kept")
+	flag.BoolVar(&flagValues.UpstreamHTTP2, "upstream-http2", true, "Use HTTP/2 For Upstream Connections When Available")
+	flag.StringVar(&flagValues.Proxy, "proxy", "", "Outbound Proxy URL (http, https or socks5; defaults to HTTPS_PROXY/HTTP_PROXY)")
+	flag.StringVar(&flagValues.AIGateway, "ai-gateway", "", "Route Requests Through This Cloudflare AI Gateway (gateway ID)")
+	flag.StringVar(&flagValues.AIGatewayToken, "ai-gateway-token", "", "AI Gateway Token Sent As cf-aig-authorization (for authenticated gateways)")
+	flag.StringVar(&flagValues.VisionModel, "vision-model", "@cf/meta/llama-3.2-11b-vision-instruct", "Cloudflare Model For Requests With Image Inputs (empty rejects them)")
+	flag.DurationVar(&flagValues.ImageURLTTL, "image-url-ttl", 0, "How Long Generated Images Are Served From /v1/images/files (0 returns data URLs)")
+	flag.StringVar(&flagValues.ImageBaseURL, "image-base-url", "", "Public Base URL For Hosted Image Links (default derived from the request host)")
+	flag.StringVar(&flagValues.TokenizerFile, "tokenizer-file", "", "Tiktoken BPE Vocabulary (e.g. o200k_base.tiktoken) For Counting Tokens When Upstream Usage Is Missing")
+	flag.StringVar(&flagValues.ContextTrim, "context-trim", contextTrimOff, "Handle Conversations Over The Context Window: off, oldest (drop oldest messages) or summarize")
+	flag.StringVar(&flagValues.ModelDefaultsFile, "model-defaults", "", "JSON File Of Per-model Default temperature, top_p, max_tokens And reasoning_effort")
+	flag.DurationVar(&flagValues.ModelDiscoveryTTL, "model-discovery-ttl", 0, "List Text Generation Models From Cloudflare In /v1/models, Cached For This Long (0 to disable)")
+	flag.BoolVar(&flagValues.Mock, "mock", false, "Answer Locally With Mock Responses Instead Of Calling Cloudflare (for testing)")
+	flag.StringVar(&flagValues.MockResponse, "mock-response", "", "Fixed Reply Text In Mock Mode (default echoes the last user message)")
+	flag.DurationVar(&flagValues.MockDelay, "mock-delay", 30*time.Millisecond, "Delay Between Streamed Chunks In Mock Mode")
+	flag.StringVar(&flagValues.Cassette, "cassette", "", "Directory For Recorded Upstream Interactions")
+	flag.StringVar(&flagValues.CassetteMode, "cassette-mode", "", "record (save upstream interactions to -cassette) or replay (answer from them without network access)")
+	flag.StringVar(&flagValues.ProvidersFile, "providers", "", "JSON File Of Named Upstreams (openai-compatible or cloudflare) Usable As provider:model In Model Aliases")
+	flag.BoolVar(&flagValues.Playground, "playground", true, "Serve A Chat Playground Page At /")
+	flag.StringVar(&flagValues.AccessLog, "access-log", "", "Write One Access Log Line Per Request To This File (empty to disable)")
+	flag.StringVar(&flagValues.AccessLogFormat, "access-log-format", "combined", "Access Log Format: combined or json")
+	flag.IntVar(&flagValues.AccessLogMaxSize, "access-log-max-size", 100, "Rotate The Access Log When It Exceeds This Many MB (0 to disable)")
+	flag.IntVar(&flagValues.AccessLogBackups, "access-log-backups", 5, "Number Of Rotated Access Log Files To Keep")
+	flag.IntVar(&flagValues.MaxBodySize, "max-body-size", 32, "Maximum Request Body Size In MB, Larger Requests Get 413 (0 for no limit)")
+	flag.StringVar(&flagValues.TLSCert, "tls-cert", "", "TLS Certificate File (PEM, full chain) To Serve HTTPS Directly")
+	flag.StringVar(&flagValues.TLSKey, "tls-key", "", "TLS Private Key File (PEM)")
+	flag.BoolVar(&flagValues.WatchConfig, "watch-config", false, "Reload The Config File When It Changes (SIGHUP always reloads)")
+	flag.StringVar(&flagValues.AccountID, "id", "", "Cloudflare Account ID")
+	flag.StringVar(&flagValues.Model, "model", "@cf/openai/gpt-oss-120b", "Cloudflare Model")
+	flag.StringVar(&flagValues.AuthToken, "token", "", "Cloudflare Auth Token")
+	flag.StringVar(&flagValues.Accounts, "accounts", "", "Additional Cloudflare Accounts As account:token,account:token For Load Balancing")
+	flag.StringVar(&flagValues.AccountBalance, "account-balance", "round-robin", "Account Pool Balancing: round-robin, least-loaded or sticky (same conversation, same account)")
+	flag.DurationVar(&flagValues.AccountEject, "account-eject", 5*time.Minute, "How Long An Account Is Removed From Rotation After 401/403/429")
+	flag.DurationVar(&flagValues.ReadTimeout, "read-timeout", 5*time.Minute, "Max Time To Read A Request Including The Body (0 for none)")
+	flag.DurationVar(&flagValues.WriteTimeout, "write-timeout", 0, "Max Time To Write A Response (0 for none; long SSE streams need 0 or a generous value)")
+	flag.DurationVar(&flagValues.IdleTimeout, "idle-timeout", 2*time.Minute, "Keep-alive Idle Connection Timeout")
+	flag.DurationVar(&flagValues.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Time Allowed For In-flight Requests To Finish On Shutdown")
+	flag.StringVar(&flagValues.ModelAliases, "model-aliases", "", "Model Aliases As alias=@cf/model,alias=@cf/model (selected by the request's model field)")
+	flag.StringVar(&flagValues.Port, "port", "10000", "Server Port")
+	flag.StringVar(&flagValues.Listen, "listen", "", "Comma-separated Listen Addresses (host:port, unix:/path.sock, systemd[:name]); Overrides -port")
+	flag.StringVar(&flagValues.ClientKey, "key", "", "Client Authorization Key")
+	flag.StringVar(&flagValues.AuthMethods, "auth-methods", "bearer,api-key,x-api-key,query", "Comma-separated Ways Clients May Send Their Key: bearer, api-key, x-api-key, query")
+	flag.BoolVar(&flagValues.BasicAuth, "basic-auth", false, "Accept HTTP Basic Auth With The Client Key As Password")
+	flag.StringVar(&flagValues.TokenFile, "token-file", "", "Read Cloudflare Auth Token From File (reloaded on change)")
+	flag.StringVar(&flagValues.ClientKeys, "keys", "", "Named Client Keys As id:key,id:key")
+	flag.StringVar(&flagValues.KeysFile, "keys-file", "", "JSON File Of Named Client Keys {\"id\": \"key\"} (reloaded on change)")
+	flag.StringVar(&flagValues.KeyFile, "key-file", "", "Read Client Authorization Key From File (reloaded on change)")
+	flag.DurationVar(&flagValues.CredentialPoll, "credential-poll", 5*time.Second, "Credential File Poll Interval")
+	flag.StringVar(&flagValues.RoutePrefix, "route-prefix", "", "Mount API Routes Under This Path Prefix (e.g. /openai)")
+	flag.StringVar(&flagValues.TenantsFile, "tenants", "", "JSON File Mapping Host Names To Tenant Account/Token/Model/Key")
+	flag.StringVar(&flagValues.RulesFile, "rules", "", "JSON File With Request Transformation Rules")
+	flag.StringVar(&flagValues.AdminKey, "admin-key", "", "Admin API Key For /admin Endpoints (empty disables them)")
+	flag.StringVar(&flagValues.AdminPort, "admin-port", "", "Separate Port For /admin Endpoints (empty serves them on the main port)")
+	flag.StringVar(&flagValues.AdminListen, "admin-listen", "", "Comma-separated Admin Listen Addresses (host:port, unix:/path.sock, systemd[:name]); Overrides -admin-port")
+	flag.DurationVar(&flagValues.ReplayTTL, "replay-ttl", 0, "Keep Chat Requests For Admin Replay This Long (0 to disable)")
+	flag.DurationVar(&flagValues.ReportRetention, "report-retention", 0, "Drop Usage Report File Entries Older Than This (0 to keep forever)")
+	flag.DurationVar(&flagValues.RetentionInterval, "retention-interval", time.Hour, "How Often Retention Policies Are Applied")
+	flag.StringVar(&flagValues.TrustedProxies, "trusted-proxies", "", "Comma-separated Proxy CIDRs Whose X-Forwarded-For/X-Real-IP Headers Are Trusted")
+	flag.StringVar(&flagValues.GeoIPDB, "geoip-db", "", "MaxMind Country MMDB File For Country-Based Access Control")
+	flag.StringVar(&flagValues.GeoAllow, "geo-allow", "", "Comma-separated ISO Country Codes Allowed (empty allows all)")
+	flag.StringVar(&flagValues.GeoDeny, "geo-deny", "", "Comma-separated ISO Country Codes Denied")
+	flag.StringVar(&flagValues.IPAllow, "ip-allow", "", "Comma-separated CIDRs Allowed To Connect (empty allows all)")
+	flag.StringVar(&flagValues.IPDeny, "ip-deny", "", "Comma-separated CIDRs Denied Access")
+	flag.IntVar(&flagValues.DefaultMaxTokens, "default-max-tokens", 0, "max_tokens Applied When Clients Omit It (0 for none)")
+	flag.IntVar(&flagValues.MaxTokensCap, "max-tokens-cap", 0, "Hard Ceiling On max_tokens (0 for none)")
+	flag.StringVar(&flagValues.ReasoningMode, "reasoning-mode", "think-tags", "How Reasoning Is Returned: think-tags, reasoning_content or strip")
+	flag.StringVar(&flagValues.Footer, "footer", "", "Text Appended To Every Chat Completion (skipped in JSON mode)")
+	flag.BoolVar(&flagValues.Timings, "timings", false, "Include x_timings Debug Info In Chat Responses")
+	flag.StringVar(&flagValues.CanaryModel, "canary-model", "", "Canary Cloudflare Model To Gradually Shift Traffic To")
+	flag.Float64Var(&flagValues.CanaryPercent, "canary-percent", 0, "Percentage Of Chat Traffic Sent To The Canary Model")
+	flag.StringVar(&flagValues.CapabilitiesFile, "capabilities", "", "JSON File With Per-Model Capability Descriptors")
+	flag.BoolVar(&flagValues.Coalesce, "coalesce", false, "Share One Upstream Call Between Identical Concurrent Non-streaming Requests")
+	flag.BoolVar(&flagValues.Lenient, "lenient", false, "Tolerate Common Client JSON Quirks (string numbers, trailing commas, nulls)")
+	flag.StringVar(&flagValues.ImageModel, "image-model", "@cf/black-forest-labs/flux-1-schnell", "Cloudflare Image Model")
+	flag.StringVar(&flagValues.ImageEditModel, "image-edit-model", "@cf/runwayml/stable-diffusion-v1-5-inpainting", "Cloudflare Image Inpainting Model")
+	flag.StringVar(&flagValues.ImageVariationModel, "image-variation-model", "@cf/runwayml/stable-diffusion-v1-5-img2img", "Cloudflare Image-to-Image Model")
+	flag.StringVar(&flagValues.EmbeddingModel, "embedding-model", "@cf/baai/bge-m3", "Cloudflare Embedding Model")
+	flag.StringVar(&flagValues.AudioModel, "audio-model", "@cf/openai/whisper-large-v3-turbo", "Cloudflare Speech Recognition Model")
+	flag.DurationVar(&flagValues.HealthInterval, "health-interval", 60*time.Second, "Upstream Health Probe Interval (0 to disable)")
+	flag.IntVar(&flagValues.MaxRetries, "max-retries", 2, "Retries For Upstream 429/5xx And Network Errors (0 to disable)")
+	flag.DurationVar(&flagValues.RetryBackoff, "retry-backoff", 500*time.Millisecond, "Base Delay For Jittered Exponential Retry Backoff")
+	flag.StringVar(&flagValues.FallbackURL, "fallback-url", "", "OpenAI-compatible Fallback Base URL (e.g. https://api.openai.com/v1)")
+	flag.StringVar(&flagValues.FallbackKey, "fallback-key", "", "API Key For -fallback-url")
+	flag.StringVar(&flagValues.FallbackModel, "fallback-model", "", "Model Used By The Fallback Upstream (alone: another Cloudflare model on the same account)")
+	flag.StringVar(&flagValues.FallbackAccount, "fallback-account", "", "Fallback Cloudflare Account As account:token")
+	flag.StringVar(&flagValues.FailoverOn, "failover-on", "5xx,timeout,network", "Primary Errors That Trigger Failover: 5xx, 429, auth, timeout, network")
+	flag.IntVar(&flagValues.BreakerThreshold, "breaker-threshold", 5, "Consecutive Upstream Failures Before Circuit Opens (0 to disable)")
+	flag.DurationVar(&flagValues.BreakerCooldown, "breaker-cooldown", 30*time.Second, "Circuit Breaker Cooldown")
+	flag.BoolVar(&flagValues.Warmup, "warmup", false, "Send A Warmup Request On Startup And After Each Config Reload")
+	flag.StringVar(&flagValues.AlertWebhook, "alert-webhook", "", "Slack/Discord/Generic Alert Webhook URL")
+	flag.DurationVar(&flagValues.AlertWindow, "alert-window", 5*time.Minute, "Alert Evaluation Window")
+	flag.DurationVar(&flagValues.AlertCooldown, "alert-cooldown", 30*time.Minute, "Minimum Interval Between Identical Alerts")
+	flag.Float64Var(&flagValues.AlertErrorRate, "alert-error-rate", 0.5, "Error Rate Alert Threshold (0 to disable)")
+	flag.IntVar(&flagValues.AlertUpstreamFailures, "alert-upstream-failures", 10, "Upstream Failure Count Alert Threshold (0 to disable)")
+	flag.DurationVar(&flagValues.ReportInterval, "report-interval", 0, "Usage Summary Report Interval, e.g. 24h or 168h (0 to disable)")
+	flag.StringVar(&flagValues.ReportFile, "report-file", "", "Append Usage Summary Reports (JSON lines) To This File")
+	flag.StringVar(&flagValues.UsageFile, "usage-file", "", "Append Per-request Usage Records (JSON lines) To This File And Serve Them At /v1/usage")
+	flag.DurationVar(&flagValues.UsageRetention, "usage-retention", 0, "Drop Usage Records Older Than This (0 to keep forever)")
+	flag.DurationVar(&flagValues.CacheTTL, "cache-ttl", 0, "Serve Identical Non-streaming Chat Requests From Cache For This Long (0 to disable)")
+	flag.IntVar(&flagValues.CacheSize, "cache-size", 1000, "Maximum Cached Responses In The In-memory LRU")
+	flag.IntVar(&flagValues.MaxConcurrent, "max-concurrent", 0, "Maximum Concurrent Upstream Requests, Extra Requests Wait In A FIFO Queue (0 for unlimited)")
+	flag.IntVar(&flagValues.QueueSize, "queue-size", 100, "Maximum Requests Waiting For A Concurrency Slot")
+	flag.DurationVar(&flagValues.QueueTimeout, "queue-timeout", 30*time.Second, "Maximum Time A Request Waits In The Queue")
+	flag.DurationVar(&flagValues.QueueStatusInterval, "queue-status-interval", 0, "Interval Of queue_status SSE Events Sent To Queued Streaming Requests (0 to disable)")
+	flag.IntVar(&flagValues.JSONRetries, "json-retries", 2, "Retry Non-streaming Requests Whose Output Fails response_format Validation Up To This Many Times")
+	flag.StringVar(&flagValues.ReportWebhook, "report-webhook", "", "Send Usage Summary Reports To This Slack/Discord/Generic Webhook")
+	flag.StringVar(&flagValues.StatsdAddr, "statsd-addr", "", "StatsD/DogStatsD UDP Address (e.g. 127.0.0.1:8125)")
+	flag.StringVar(&flagValues.StatsdPrefix, "statsd-prefix", "gptoss2api.", "StatsD Metric Name Prefix")
+	flag.BoolVar(&flagValues.StatsdDogstatsd, "statsd-dogstatsd", true, "Send Labels As DogStatsD Tags")
+	flag.StringVar(&flagValues.Lang, "lang", "zh", "Log Language (zh or en)")
+	flag.DurationVar(&flagValues.SlowRequest, "slow-request", 0, "Log A Timing Breakdown For Requests Slower Than This (0 to disable)")
+	flag.StringVar(&flagValues.LogLevel, "log-level", "info", "Log Level: debug, info, warn or error")
+	flag.StringVar(&flagValues.LogFormat, "log-format", "json", "Log Format: json or text")
+	flag.BoolVar(&flagValues.LogBodies, "log-bodies", true, "Log Full Request Bodies And Upstream Responses (disable for privacy)")
+	flag.Float64Var(&flagValues.LogSampleRate, "log-sample-rate", 1, "Fraction Of Successful Requests Logged In Detail (errors are always logged)")
+	flag.DurationVar(&flagValues.ChaosLatency, "chaos-latency", 0, "Chaos: Max Random Latency Added Before Upstream Calls")
+	flag.Float64Var(&flagValues.ChaosErrorRate, "chaos-error-rate", 0, "Chaos: Probability Of Synthetic Upstream Errors")
+	flag.Float64Var(&flagValues.ChaosDropRate, "chaos-drop-rate", 0, "Chaos: Probability Of Dropping Streams Midway")
+	flag.Float64Var(&flagValues.KeyRPM, "key-rpm", 0, "Requests Per Minute Allowed Per Client Key (0 for unlimited)")
+	flag.Float64Var(&flagValues.IPRPM, "ip-rpm", 0, "Requests Per Minute Allowed Per Client IP For Requests Without A Named Client Key (0 for unlimited)")
+	flag.Float64Var(&flagValues.IPBurst, "ip-burst", 0, "Burst Size For -ip-rpm (0 to use the -ip-rpm value)")
+	flag.Float64Var(&flagValues.KeyTPD, "key-tpd", 0, "Tokens Per Day Allowed Per Client Key (0 for unlimited)")
+	flag.StringVar(&flagValues.KeyLimitsFile, "key-limits", "", "JSON File With Per-Key Limits {\"id\": {\"rpm\": 60, \"tpd\": 100000}}")
+	flag.IntVar(&flagValues.MaxStreamsPerKey, "max-streams-per-key", 0, "Max Concurrent Streams Per Client Key (0 for unlimited)")
+	flag.Float64Var(&flagValues.NeuronDailyLimit, "neuron-daily-limit", 0, "Estimated Daily Neuron Allowance Per Cloudflare Account (0 for unlimited)")
+	flag.Float64Var(&flagValues.UpstreamRPM, "upstream-rpm", 0, "Instance-wide Upstream Requests Per Minute (0 for unlimited)")
+	flag.Float64Var(&flagValues.UpstreamTPM, "upstream-tpm", 0, "Instance-wide Upstream Tokens Per Minute (0 for unlimited)")
+	flag.StringVar(&flagValues.Store, "store", "", "State Storage Backend: memory or redis (defaults to redis when -redis-addr is set)")
+	flag.StringVar(&flagValues.RedisAddr, "redis-addr", "", "Redis Address For Shared State (e.g. 127.0.0.1:6379)")
+	flag.StringVar(&flagValues.RedisPassword, "redis-password", "", "Redis Password")
+	flag.IntVar(&flagValues.RedisDB, "redis-db", 0, "Redis Database")
+	flag.StringVar(&flagValues.RedisPrefix, "redis-prefix", "gptoss2api:", "Redis Key Prefix")
 	flag.Parse()
+	if err := applyConfigSources(config().ConfigFile); err != nil {
+		log.Fatal(err)
+	}
+	publishConfig()
+	if err := initLogging(); err != nil {
+		log.Fatal(err)
+	}
 
-	if config.AuthToken == "" {
-		log.Fatal("请提供 auth-token 参数")
+	if err := initCredentials(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadAccountPool(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadFailover(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadKeyLimits(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateCassette(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateAuthMethods(config().AuthMethods); err != nil {
+		log.Fatal(err)
+	}
+	if currentAuthToken() == "" && accountPoolSize() == 0 && !config().Mock && config().CassetteMode != cassetteReplay {
+		log.Fatal(tr("missing_token"))
+	}
+	if config().Mock {
+		log.Print(tr("mock_enabled"))
+	}
+	watchCredentialFiles()
+	if err := loadTenants(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadRules(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadCapabilities(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadModelAliases(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadSystemPrompts(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadProviders(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadModelDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTokenizer(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateReasoningMode(config().ReasoningMode); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateContextTrim(config().ContextTrim); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateStreamChunking(config().StreamChunking); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateEgressProxy(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadIPFilters(); err != nil {
+		log.Fatal(err)
+	}
+	if err := initGeoIP(); err != nil {
+		log.Fatal(err)
+	}
+	startConfigReload()
+	if err := initTLS(); err != nil {
+		log.Fatal(err)
 	}
 
-	http.HandleFunc("/v1/chat/completions", handleChatCompletions)
-	http.HandleFunc("/v1/models", handleModels)
+	http.HandleFunc(apiPath("/v1/chat/completions"), limitConcurrency(handleChatCompletions))
+	http.HandleFunc(apiPath("/v1/completions"), limitConcurrency(handleCompletions))
+	http.HandleFunc(apiPath("/v1/responses"), limitConcurrency(handleResponses))
+	http.HandleFunc(apiPath("/v1/models"), handleModels)
+	http.HandleFunc(apiPath("/v1/models/"), handleModelDetail)
+	http.HandleFunc(apiPath("/v1/usage"), handleUsage)
+	http.HandleFunc(apiPath("/v1/images/generations"), limitConcurrency(handleImageGenerations))
+	http.HandleFunc(apiPath("/v1/images/edits"), limitConcurrency(handleImageEdits))
+	http.HandleFunc(apiPath("/v1/images/variations"), limitConcurrency(handleImageVariations))
+	http.HandleFunc(apiPath("/v1/images/files/"), handleImageFile)
+	http.HandleFunc(apiPath("/v1/messages"), limitConcurrency(handleAnthropicMessages))
+	http.HandleFunc(apiPath("/v1/embeddings"), limitConcurrency(handleEmbeddings))
+	http.HandleFunc(apiPath("/v1/audio/transcriptions"), limitConcurrency(handleAudioTranscriptions))
+	http.HandleFunc(apiPath("/v1/audio/translations"), limitConcurrency(handleAudioTranslations))
+	if config().Playground {
+		http.HandleFunc(apiPath("/"), handlePlayground)
+	}
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/metrics", handleMetrics)
+	if config().AdminPort != "" || config().AdminListen != "" {
+		startAdminServer()
+	} else {
+		registerAdminRoutes(http.DefaultServeMux)
+	}
 
-	fmt.Printf("服务器启动在端口 %s\n", config.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+	if chaosEnabled() {
+		log.Print(tr("chaos_enabled"))
+	}
+	startStatsd()
+	startUsageReports()
+	if err := openUsageLedger(); err != nil {
+		log.Fatal(err)
+	}
+	if err := openAccessLog(); err != nil {
+		log.Fatal(err)
+	}
+	if err := initStore(); err != nil {
+		log.Fatal(err)
+	}
+	initUpstreamLimiter()
+	startRetention()
+	if config().Warmup {
+		warmupUpstream()
+	}
+	startHealthProbe()
+
+	listen := config().Listen
+	if listen == "" {
+		listen = ":" + config().Port
+	}
+	listeners, err := openListeners(listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf(tr("server_started"), listenerAddrs(listeners))
+	runServer(routeMetricsMiddleware(http.DefaultServeMux, accessLogMiddleware(requestIDMiddleware(recoverMiddleware(bodyLimitMiddleware(ipFilterMiddleware(geoMiddleware(tenantMiddleware(accountPoolMiddleware(http.DefaultServeMux))))))))), listeners)
+}
+
+// 在 API 路由前加上可配置的前缀，便于挂在共享反向代理的子路径下
+func apiPath(path string) string {
+	prefix := strings.TrimRight(config().RoutePrefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return prefix + path
 }
 
+// 租户配置了密钥时只接受租户密钥；否则接受 -key 共享密钥或 -keys/-keys-file 中的任一具名密钥
 func authorizeClient(r *http.Request) bool {
-	if config.ClientKey == "" {
+	if t := requestTenant(r.Context()); t != nil && t.ClientKey != "" {
+		return presentedClientKey(r) == t.ClientKey
+	}
+	if currentClientKey() == "" && !clientKeysConfigured() {
 		return true
 	}
-	return r.Header.Get("Authorization") == "Bearer "+config.ClientKey
+	return requestKeyID(r) != ""
+}
+
+// -auth-methods 中可用的客户端密钥传递方式；Basic 认证由 -basic-auth 单独控制
+var authMethods = []string{"bearer", "api-key", "x-api-key", "query"}
+
+func validateAuthMethods(value string) error {
+	for _, method := range strings.Split(value, ",") {
+		if !slices.Contains(authMethods, strings.TrimSpace(method)) {
+			return fmt.Errorf("invalid -auth-methods entry %q, expected a comma-separated list of %s", method, strings.Join(authMethods, ", "))
+		}
+	}
+	return nil
+}
+
+func authMethodEnabled(method string) bool {
+	for _, enabled := range strings.Split(config().AuthMethods, ",") {
+		if strings.TrimSpace(enabled) == method {
+			return true
+		}
+	}
+	return false
+}
+
+// 依次从 Bearer 认证头、Basic 认证（密码即密钥）、Azure 风格的 api-key 头、Anthropic 风格的 x-api-key 头和 api_key 查询参数中读取客户端密钥，
+// 查询参数用于无法设置请求头的浏览器 EventSource 客户端。没有列在 -auth-methods 中的方式会被忽略，
+// 例如 -auth-methods=bearer 时只接受 Authorization 头，避免密钥出现在 URL 和代理日志中
+func presentedClientKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && authMethodEnabled("bearer") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if config().BasicAuth {
+		if _, password, ok := r.BasicAuth(); ok {
+			return password
+		}
+	}
+	if key := r.Header.Get("api-key"); key != "" && authMethodEnabled("api-key") {
+		return key
+	}
+	if key := r.Header.Get("x-api-key"); key != "" && authMethodEnabled("x-api-key") {
+		return key
+	}
+	if authMethodEnabled("query") {
+		return r.URL.Query().Get("api_key")
+	}
+	return ""
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	if config().BasicAuth {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gptoss2api"`)
+	}
+	writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 }
 
 func handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	requestStart := time.Now()
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	r = r.WithContext(withRequestLog(r.Context(), reqLog))
 	if !authorizeClient(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w)
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
 		return
 	}
 
-	body, _ := io.ReadAll(r.Body)
-	log.Printf("用户请求 JSON: %s", string(body))
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+	reqLog.Body(tr("user_request"), string(body))
+	if config().Lenient {
+		body = normalizeLenientJSON(body)
+	}
+	body = applyRules(r, body)
 
 	var openaiReq OpenAIRequest
 	if err := json.Unmarshal(body, &openaiReq); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeJSONError(w, err)
+		return
+	}
+	if param, err := validateMessages(openaiReq.Messages); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), param)
+		return
+	}
+	if err := validateReasoningMode(openaiReq.ReasoningMode); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_reasoning_mode", err.Error(), "reasoning_mode")
+		return
+	}
+	if err := validateStopSequences(openaiReq.Stop); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "stop")
+		return
+	}
+	if err := validateResponseFormat(openaiReq.ResponseFormat); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "response_format")
+		return
+	}
+	if param, err := validateContentParts(openaiReq.Messages); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), param)
+		return
+	}
+	if err := validateChoiceCount(openaiReq.N); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "n")
+		return
+	}
+	if err := validateStreamChunking(openaiReq.StreamChunking); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "stream_chunking")
+		return
+	}
+	if err := validateStreamDelay(openaiReq.StreamDelayMs); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "stream_delay_ms")
+		return
+	}
+	if err := validateReasoningEffort(openaiReq.ReasoningEffort); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "reasoning_effort")
+		return
+	}
+	if err := validateCacheDirective(openaiReq.Cache); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", err.Error(), "cache")
 		return
 	}
 
-	cfReq := convertToCloudflareRequest(openaiReq)
+	setAccountAffinity(r, openaiReq.User, openaiReq.Messages)
 
-	// 调用 Cloudflare API（保留原始响应字符串）
-	cfResp, rawCFJSON, err := callCloudflareAPI(cfReq, r.Context())
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Cloudflare API error: %v", err), http.StatusInternalServerError)
+	// 配置了备用上游时，熔断由故障转移处理
+	if !fallbackConfigured() && rejectIfCircuitOpen(w) {
+		return
+	}
+	if accountBudgetExhausted(r.Context()) {
+		writeError(w, http.StatusTooManyRequests, "account_budget_exhausted", "Daily neuron allowance for the upstream account is exhausted")
 		return
 	}
 
-	// 打印 Cloudflare 原始响应（不转义）
-	log.Printf("Cloudflare 原始响应: %s", rawCFJSON)
+	if openaiReq.Stream {
+		key := clientIdentity(r)
+		if !streams.acquire(key) {
+			writeError(w, http.StatusTooManyRequests, "too_many_streams", "Too many concurrent streams for this API key")
+			return
+		}
+		defer streams.release(key)
+	}
 
-	openaiResp := convertToOpenAIResponse(cfResp)
+	model := resolveModel(r.Context(), openaiReq.Model)
+	applyModelDefaults(w, openaiReq.Model, model, &openaiReq)
+	applyMaxTokensPolicy(r, &openaiReq)
+	trimContext(w, r, model, &openaiReq)
+	r, model, provider := routeProvider(r, model)
+	if provider != nil {
+		handleProviderChat(w, r, provider, model, openaiReq, reqLog)
+		return
+	}
+	cfReq := convertToCloudflareRequest(openaiReq, model)
+	if useVisionModel(cfReq.Model, openaiReq.Messages) {
+		handleVisionChat(w, r, openaiReq, reqLog)
+		return
+	}
+	if err := validateCapabilities(cfReq.Model, body); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "unsupported_feature", err.Error(), err.Param)
+		return
+	}
+	if err := checkContextLength(cfReq.Model, openaiReq); err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "context_length_exceeded", err.Error(), "messages")
+		return
+	}
 
+	if choiceCount(openaiReq) > 1 {
+		if openaiReq.Stream {
+			streamMultipleChoices(w, r, openaiReq, cfReq, reqLog)
+		} else {
+			handleMultipleChoices(w, r, openaiReq, cfReq, reqLog)
+		}
+		return
+	}
 	if openaiReq.Stream {
-		// SSE 流式返回，符合 OpenAI 兼容格式
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
-		fullContent := openaiResp.Choices[0].Message.Content.(string)
-		// 为了防止在多字节 UTF-8 字符中间切断，我们按字符而不是字节分割
-		runes := []rune(fullContent)
-
-		// 发送开始标记
-		startEvent := map[string]interface{}{
-			"id":      openaiResp.ID,
-			"object":  "chat.completion.chunk",
-			"created": time.Now().Unix(),
-			"model":   openaiResp.Model,
-			"choices": []map[string]interface{}{
-				{
-					"delta": map[string]interface{}{
-						"role": "assistant",
-					},
-					"index":         0,
-					"finish_reason": nil,
-				},
-			},
+		streamChatCompletion(w, r, openaiReq, cfReq, body, requestStart, reqLog)
+		return
+	}
+
+	var openaiResp OpenAIResponse
+	cacheKey, cached := "", false
+	if cacheEnabled() {
+		lookup, save := cacheDirectives(r, openaiReq)
+		if save {
+			cacheKey = responseCacheKey(r.Context(), openaiReq, cfReq)
 		}
-		w.Write([]byte("data: "))
+		if lookup {
+			openaiResp, cached = getCachedResponse(cacheKey)
+		}
+		setCacheStatus(w, cached)
+	}
+	if cached {
+		// 缓存命中不产生上游消耗，只计入用量统计
+		recordUsage(r, cfReq.Model, openaiResp.Usage, nil)
+		applyFooter(&openaiResp, openaiReq)
+		w.Header().Set("Content-Type", "application/json")
 		enc := json.NewEncoder(w)
 		enc.SetEscapeHTML(false)
-		enc.Encode(startEvent)
-		w.Write([]byte("\n"))
-		w.(http.Flusher).Flush()
-
-		// 逐字符发送内容
-		for _, r := range runes {
-			event := map[string]interface{}{
-				"id":      openaiResp.ID,
-				"object":  "chat.completion.chunk",
-				"created": time.Now().Unix(),
-				"model":   openaiResp.Model,
-				"choices": []map[string]interface{}{
-					{
-						"delta": map[string]interface{}{
-							"content": string(r),
-						},
-						"index":         0,
-						"finish_reason": nil,
-					},
-				},
+		enc.Encode(openaiResp)
+		return
+	}
+
+	upstreamStart := time.Now()
+	var shared bool
+	err := primaryAvailable()
+	if err == nil {
+		openaiResp, shared, err = callProviderCoalesced(r.Context(), defaultProvider, openaiReq, cfReq.Model)
+		recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+	}
+	backend := backendPrimary
+	if shouldFailover(r.Context(), err) {
+		reqLog.Printf(tr("failover"), err)
+		backend = backendFallback
+		openaiResp, err = callFallbackChat(r.Context(), openaiReq, cfReq)
+	}
+	w.Header().Set("X-Upstream-Backend", backend)
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	upstreamLatency := time.Since(upstreamStart)
+
+	conversionStart := time.Now()
+	if backend == backendPrimary && isJSONMode(openaiReq) {
+		openaiResp, err = enforceResponseFormat(r.Context(), defaultProvider, openaiReq, cfReq.Model, openaiResp)
+		if err != nil {
+			recordUsage(r, cfReq.Model, openaiResp.Usage, err)
+			recordNeurons(r.Context(), cfReq.Model, openaiResp.Usage)
+			var formatErr *responseFormatError
+			if errors.As(err, &formatErr) {
+				writeErrorParam(w, http.StatusBadGateway, "json_validate_failed", "Model output did not satisfy response_format: "+err.Error(), "response_format")
+			} else {
+				writeUpstreamError(w, err)
 			}
-			w.Write([]byte("data: "))
-			enc := json.NewEncoder(w)
-			enc.SetEscapeHTML(false)
-			enc.Encode(event)
-			w.Write([]byte("\n"))
-			w.(http.Flusher).Flush()
+			return
 		}
+	}
+	replyText, _ := openaiResp.Choices[0].Message.Content.(string)
+	saveReplayRecord(openaiResp.ID, body, cfReq.Model, replyText)
+	recordUsage(r, cfReq.Model, openaiResp.Usage, nil)
+	if !shared && backend == backendPrimary {
+		// 共享结果没有产生新的上游消耗，备用上游不计入主账号额度
+		recordNeurons(r.Context(), cfReq.Model, openaiResp.Usage)
+	}
+	if cacheKey != "" && backend == backendPrimary {
+		putCachedResponse(cacheKey, openaiResp)
+	}
+	applyFooter(&openaiResp, openaiReq)
+	if config().Timings {
+		openaiResp.Timings = newTimings(r, upstreamLatency, openaiResp.Usage.CompletionTokens)
+		openaiResp.Timings.TTFTMs = time.Since(requestStart).Milliseconds()
+	}
+	trackPhase(r.Context(), "conversion", conversionStart)
 
-		// 发送结束标记，包含 usage 信息
-		endEvent := map[string]interface{}{
-			"id":      openaiResp.ID,
-			"object":  "chat.completion.chunk",
-			"created": time.Now().Unix(),
-			"model":   openaiResp.Model,
-			"choices": []map[string]interface{}{
-				{
-					"delta":         map[string]interface{}{},
-					"index":         0,
-					"finish_reason": "stop",
-				},
-			},
-			"usage": map[string]interface{}{
-				"prompt_tokens":     openaiResp.Usage.PromptTokens,
-				"completion_tokens": openaiResp.Usage.CompletionTokens,
-				"total_tokens":      openaiResp.Usage.TotalTokens,
-			},
-		}
-		w.Write([]byte("data: "))
-		enc = json.NewEncoder(w)
-		enc.SetEscapeHTML(false)
-		enc.Encode(endEvent)
-		w.Write([]byte("\n\n"))
+	// 普通返回，禁止转义
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(openaiResp)
+}
 
-		// 发送 [DONE] 标记
-		w.Write([]byte("data: [DONE]\n\n"))
-		w.(http.Flusher).Flush()
-	} else {
-		// 普通返回，禁止转义
-		w.Header().Set("Content-Type", "application/json")
-		enc := json.NewEncoder(w)
-		enc.SetEscapeHTML(false)
-		enc.Encode(openaiResp)
+func newTimings(r *http.Request, upstreamLatency time.Duration, completionTokens int) *Timings {
+	timings := &Timings{
+		UpstreamLatencyMs: upstreamLatency.Milliseconds(),
+		Account:           upstreamAccountID(r.Context()),
+		Retries:           requestRetries(r.Context()),
 	}
+	if upstreamLatency > 0 {
+		timings.TokensPerSecond = float64(completionTokens) / upstreamLatency.Seconds()
+	}
+	return timings
 }
 
 func handleModels(w http.ResponseWriter, r *http.Request) {
 	if !authorizeClient(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeUnauthorized(w)
 		return
 	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
+	var data []map[string]interface{}
+	listed := map[string]bool{}
+	for _, id := range listModelIDs(r.Context()) {
+		data = append(data, modelObject(id, nil))
+		listed[id] = true
+	}
+	discovered, _ := discoveredModels(r.Context())
+	for _, m := range discovered {
+		if !listed[m.ID] {
+			data = append(data, modelObject(m.ID, &m))
+		}
+	}
 	modelsResp := map[string]interface{}{
 		"object": "list",
-		"data": []map[string]interface{}{
-			{
-				"id":       config.Model,
-				"object":   "model",
-				"created":  time.Now().Unix(),
-				"owned_by": "openai",
-			},
-		},
+		"data":   data,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(modelsResp)
 }
 
-func convertToCloudflareRequest(openaiReq OpenAIRequest) CloudflareRequest {
+func convertToCloudflareRequest(openaiReq OpenAIRequest, model string) CloudflareRequest {
 	var cfMessages []map[string]interface{}
 	for _, msg := range openaiReq.Messages {
-		cfMessages = append(cfMessages, map[string]interface{}{
-			"role":    msg.Role,
-			"content": msg.Content,
-		})
+		cfMessages = append(cfMessages, convertMessageToInput(msg)...)
 	}
 
 	cfReq := CloudflareRequest{
-		Model: config.Model,
+		Model: model,
 		Input: cfMessages,
 	}
 
+	if len(openaiReq.Tools) > 0 {
+		cfReq.Tools = convertToolsToCloudflare(openaiReq.Tools)
+		cfReq.ToolChoice = convertToolChoice(openaiReq.ToolChoice)
+		cfReq.ParallelToolCalls = openaiReq.ParallelToolCalls
+	}
+
 	if openaiReq.Temperature != nil {
 		cfReq.Temperature = openaiReq.Temperature
 	}
 	if openaiReq.TopP != nil {
 		cfReq.TopP = openaiReq.TopP
 	}
+	if openaiReq.MaxCompletionTokens != nil {
+		cfReq.MaxOutputTokens = openaiReq.MaxCompletionTokens
+	} else if openaiReq.MaxTokens != nil {
+		cfReq.MaxOutputTokens = openaiReq.MaxTokens
+	}
+	if openaiReq.ReasoningEffort != "" {
+		cfReq.Reasoning = &CloudflareReasoning{Effort: openaiReq.ReasoningEffort}
+	}
+	applyResponseFormat(&cfReq, openaiReq)
+	applySystemPrompt(&cfReq)
 
 	return cfReq
 }
 
-// 修改：返回 CloudflareResponse 和 原始 JSON 字符串
+// 修改：返回 CloudflareResponse 和 原始 JSON 字符串，429/5xx 时按 -max-retries 退避重试
 func callCloudflareAPI(req CloudflareRequest, ctx context.Context) (*CloudflareResponse, string, error) {
+	var cfResp *CloudflareResponse
+	var raw string
+	err := retryUpstream(ctx, func() error {
+		var err error
+		cfResp, raw, err = callCloudflareAPIOnce(req, ctx)
+		return err
+	})
+	return cfResp, raw, err
+}
+
+func callCloudflareAPIOnce(req CloudflareRequest, ctx context.Context) (*CloudflareResponse, string, error) {
 	reqBody, _ := json.Marshal(req)
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/ai/v1/responses", config.AccountID)
+	url := workersAIURL(ctx, "v1/responses")
 
 	httpReq, _ := http.NewRequestWithContext(ctx, "POST", url, io.NopCloser(strings.NewReader(string(reqBody))))
-	httpReq.Header.Set("Authorization", "Bearer "+config.AuthToken)
+	httpReq.Header.Set("Authorization", "Bearer "+upstreamAuthToken(ctx))
 	httpReq.Header.Set("Content-Type", "application/json")
+	setGatewayHeaders(ctx, httpReq)
 
-	client := &http.Client{}
+	if chaosEnabled() {
+		if status, err := chaosUpstreamFault(ctx); err != nil {
+			recordUpstreamResult(status, err, 0)
+			return nil, "", err
+		}
+	}
+	estimated := estimateTokens(reqBody)
+	if err := waitUpstreamSlot(ctx, estimated); err != nil {
+		return nil, "", err
+	}
+
+	client := upstreamHTTPClient(false)
+	traceUpstreamRequest(ctx, httpReq)
+	start := time.Now()
 	resp, err := client.Do(httpReq)
 	if err != nil {
+		recordUpstreamResult(0, err, time.Since(start))
 		return nil, "", err
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// 读取响应体时超过 -upstream-timeout
+		recordUpstreamResult(0, err, time.Since(start))
+		return nil, "", err
+	}
+	recordUpstreamResult(resp.StatusCode, nil, time.Since(start))
+	recordAccountResult(ctx, resp.StatusCode)
+	traceUpstreamResponse(ctx, resp)
+	trackPhase(ctx, "upstream", start)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, string(body), fmt.Errorf("API request failed: %s", string(body))
+		return nil, string(body), newUpstreamError(resp, body)
 	}
 
 	var cloudflareResp CloudflareResponse
 	if err := json.Unmarshal(body, &cloudflareResp); err != nil {
 		return nil, string(body), err
 	}
+	reportUpstreamTokens(cloudflareResp.Usage.TotalTokens, estimated)
 	return &cloudflareResp, string(body), nil
 }
 
-func convertToOpenAIResponse(cloudflareResp *CloudflareResponse) OpenAIResponse {
+// 调用 Cloudflare Workers AI 的 run 接口，返回原始响应体和 Content-Type
+func callCloudflareRun(ctx context.Context, model string, payload interface{}) ([]byte, string, error) {
+	var body []byte
+	var contentType string
+	err := retryUpstream(ctx, func() error {
+		var err error
+		body, contentType, err = callCloudflareRunOnce(ctx, model, payload)
+		return err
+	})
+	return body, contentType, err
+}
+
+func callCloudflareRunOnce(ctx context.Context, model string, payload interface{}) ([]byte, string, error) {
+	reqBody, _ := json.Marshal(payload)
+	url := workersAIURL(ctx, "run/"+model)
+
+	httpReq, _ := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(reqBody)))
+	httpReq.Header.Set("Authorization", "Bearer "+upstreamAuthToken(ctx))
+	httpReq.Header.Set("Content-Type", "application/json")
+	setGatewayHeaders(ctx, httpReq)
+
+	if chaosEnabled() {
+		if status, err := chaosUpstreamFault(ctx); err != nil {
+			recordUpstreamResult(status, err, 0)
+			return nil, "", err
+		}
+	}
+	estimated := estimateRunTokens(payload)
+	if err := waitUpstreamSlot(ctx, estimated); err != nil {
+		return nil, "", err
+	}
+
+	client := upstreamHTTPClient(false)
+	traceUpstreamRequest(ctx, httpReq)
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		recordUpstreamResult(0, err, time.Since(start))
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// 读取响应体时超过 -upstream-timeout
+		recordUpstreamResult(0, err, time.Since(start))
+		return nil, "", err
+	}
+	recordUpstreamResult(resp.StatusCode, nil, time.Since(start))
+	recordAccountResult(ctx, resp.StatusCode)
+	traceUpstreamResponse(ctx, resp)
+	trackPhase(ctx, "upstream", start)
+	if resp.StatusCode != http.StatusOK {
+		return body, resp.Header.Get("Content-Type"), newUpstreamError(resp, body)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+func convertToOpenAIResponse(cloudflareResp *CloudflareResponse, openaiReq OpenAIRequest) OpenAIResponse {
+	mode := reasoningMode(openaiReq)
 	var reasoningText string
 	var assistantMessage string
+	var refusal string
 
 	for _, output := range cloudflareResp.Output {
 		if output.Type == "reasoning" {
@@ -337,18 +1115,48 @@ func convertToOpenAIResponse(cloudflareResp *CloudflareResponse) OpenAIResponse
 		}
 		if output.Type == "message" && output.Role == "assistant" {
 			for _, content := range output.Content {
-				if content.Type == "output_text" {
+				switch content.Type {
+				case "output_text":
 					assistantMessage = content.Text
+				case "refusal":
+					refusal += content.Refusal
 				}
 			}
 		}
 	}
 
+	if isJSONMode(openaiReq) {
+		assistantMessage = stripJSONFence(assistantMessage)
+	}
+	// 停止序列只作用于回复正文，不截断推理内容
+	assistantMessage, matchedStop := truncateAtStop(assistantMessage, openaiReq.Stop)
+	usage := fillMissingUsage(cloudflareResp.Usage, openaiReq.Messages, reasoningText+assistantMessage+refusal)
+
 	finalMessage := ""
-	if reasoningText != "" {
+	if reasoningText != "" && mode == reasoningThinkTags {
 		finalMessage += fmt.Sprintf("<think>%s</think>\n", reasoningText)
 	}
 	finalMessage += assistantMessage
+	if mode != reasoningContent {
+		reasoningText = ""
+	}
+
+	// 模型发起函数调用时返回 tool_calls，没有文本内容时 content 为 null
+	var content interface{} = finalMessage
+	if refusal != "" && assistantMessage == "" {
+		content = nil
+	}
+	finishReason := cloudflareFinishReason(cloudflareResp)
+	if matchedStop != "" {
+		finishReason = "stop"
+	}
+	toolCalls := extractToolCalls(cloudflareResp.Output)
+	if len(toolCalls) > 0 {
+		finishReason = "tool_calls"
+		if assistantMessage == "" {
+			content = nil
+		}
+	}
 
 	return OpenAIResponse{
 		ID:      cloudflareResp.ID,
@@ -359,16 +1167,39 @@ func convertToOpenAIResponse(cloudflareResp *CloudflareResponse) OpenAIResponse
 			{
 				Index: 0,
 				Message: Message{
-					Role:    "assistant",
-					Content: finalMessage,
+					Role:             "assistant",
+					Content:          content,
+					ReasoningContent: reasoningText,
+					Refusal:          refusal,
+					ToolCalls:        toolCalls,
 				},
-				FinishReason: "stop",
+				FinishReason: finishReason,
 			},
 		},
 		Usage: Usage{
-			PromptTokens:     cloudflareResp.Usage.PromptTokens,
-			CompletionTokens: cloudflareResp.Usage.CompletionTokens,
-			TotalTokens:      cloudflareResp.Usage.TotalTokens,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
 		},
 	}
 }
+
+// 上游因 max_output_tokens 截断时返回 status: incomplete，对应 OpenAI 的 finish_reason: length；
+// 因内容过滤截断或模型拒绝回答（输出 refusal 内容）时为 content_filter。函数调用由调用方另行判断
+func cloudflareFinishReason(cloudflareResp *CloudflareResponse) string {
+	if cloudflareResp.Status == "incomplete" {
+		if cloudflareResp.IncompleteDetails != nil && cloudflareResp.IncompleteDetails.Reason == "content_filter" {
+			return "content_filter"
+		}
+		// 未说明原因的截断几乎都是达到了输出长度上限
+		return "length"
+	}
+	for _, output := range cloudflareResp.Output {
+		for _, content := range output.Content {
+			if content.Type == "refusal" {
+				return "content_filter"
+			}
+		}
+	}
+	return "stop"
+}
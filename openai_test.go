@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertToCloudflareRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		req  string
+		want string
+	}{
+		{
+			name: "plain messages and sampling parameters",
+			req:  `{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}],"temperature":0.2,"top_p":0.9,"max_tokens":64}`,
+			want: `{"model":"m","input":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}],"temperature":0.2,"top_p":0.9,"max_output_tokens":64}`,
+		},
+		{
+			name: "max_completion_tokens wins over max_tokens",
+			req:  `{"messages":[{"role":"user","content":"hi"}],"max_tokens":64,"max_completion_tokens":128}`,
+			want: `{"model":"m","input":[{"role":"user","content":"hi"}],"max_output_tokens":128}`,
+		},
+		{
+			name: "reasoning effort",
+			req:  `{"messages":[{"role":"user","content":"hi"}],"reasoning_effort":"low"}`,
+			want: `{"model":"m","input":[{"role":"user","content":"hi"}],"reasoning":{"effort":"low"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OpenAIRequest
+			if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, convertToCloudflareRequest(req, "m"), tt.want)
+		})
+	}
+}
+
+func TestConvertToOpenAIResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		resp string
+		want string
+	}{
+		{
+			name: "completed",
+			resp: `{"id":"resp_1","created_at":1,"model":"m","status":"completed",
+				"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"hello"}]}],
+				"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+			want: `{"id":"resp_1","object":"chat.completion","created":1,"model":"m",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],
+				"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`,
+		},
+		{
+			name: "truncated by max_output_tokens",
+			resp: `{"id":"resp_1","created_at":1,"model":"m","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},
+				"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"partial"}]}],
+				"usage":{"prompt_tokens":3,"completion_tokens":8,"total_tokens":11}}`,
+			want: `{"id":"resp_1","object":"chat.completion","created":1,"model":"m",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"partial"},"finish_reason":"length"}],
+				"usage":{"prompt_tokens":3,"completion_tokens":8,"total_tokens":11}}`,
+		},
+		{
+			name: "truncated by the content filter",
+			resp: `{"id":"resp_1","created_at":1,"model":"m","status":"incomplete","incomplete_details":{"reason":"content_filter"},
+				"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"partial"}]}],
+				"usage":{"prompt_tokens":3,"completion_tokens":8,"total_tokens":11}}`,
+			want: `{"id":"resp_1","object":"chat.completion","created":1,"model":"m",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"partial"},"finish_reason":"content_filter"}],
+				"usage":{"prompt_tokens":3,"completion_tokens":8,"total_tokens":11}}`,
+		},
+		{
+			name: "refusal",
+			resp: `{"id":"resp_1","created_at":1,"model":"m","status":"completed",
+				"output":[{"type":"message","role":"assistant","content":[{"type":"refusal","refusal":"I can't help with that."}]}],
+				"usage":{"prompt_tokens":3,"completion_tokens":6,"total_tokens":9}}`,
+			want: `{"id":"resp_1","object":"chat.completion","created":1,"model":"m",
+				"choices":[{"index":0,"message":{"role":"assistant","content":null,"refusal":"I can't help with that."},"finish_reason":"content_filter"}],
+				"usage":{"prompt_tokens":3,"completion_tokens":6,"total_tokens":9}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp CloudflareResponse
+			if err := json.Unmarshal([]byte(tt.resp), &resp); err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, convertToOpenAIResponse(&resp, OpenAIRequest{ReasoningMode: reasoningStrip}), tt.want)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// GET /：内置的聊天测试页面，用于部署后直接在浏览器里验证。页面本身不含任何密钥，
+// 请求使用页面中填写的客户端密钥（保存在浏览器的 localStorage 中）调用 /v1 接口
+func handlePlayground(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != apiPath("/") && r.URL.Path != apiPath("") {
+		writeError(w, http.StatusNotFound, "not_found", "Unknown endpoint "+r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write([]byte(strings.ReplaceAll(playgroundHTML, "{{API_BASE}}", apiPath("/v1"))))
+}
+
+const playgroundHTML = `<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>gptoss2api playground</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; display: flex; flex-direction: column; height: 100vh; }
+header { display: flex; flex-wrap: wrap; gap: 12px; align-items: center; padding: 10px 16px; border-bottom: 1px solid #ddd; background: #fafafa; }
+header input[type=password] { width: 220px; }
+#log { flex: 1; overflow-y: auto; padding: 16px; }
+.msg { max-width: 820px; margin: 0 auto 14px; white-space: pre-wrap; line-height: 1.5; }
+.role { font-size: 12px; font-weight: 600; color: #666; text-transform: uppercase; }
+.reasoning { color: #777; font-style: italic; border-left: 3px solid #ddd; padding-left: 8px; margin: 4px 0; }
+.usage { font-size: 12px; color: #888; }
+.error { color: #b00020; }
+form { display: flex; gap: 8px; padding: 12px 16px; border-top: 1px solid #ddd; }
+textarea { flex: 1; min-height: 60px; font: inherit; }
+</style>
+</head>
+<body>
+<header>
+  <strong>gptoss2api</strong>
+  <label>Key <input type="password" id="key" placeholder="client key"></label>
+  <label>Model <select id="model"></select></label>
+  <label><input type="checkbox" id="stream" checked> Stream</label>
+  <label><input type="checkbox" id="reasoning"> Show reasoning</label>
+  <button id="clear" type="button">Clear</button>
+</header>
+<div id="log"></div>
+<form id="form">
+  <textarea id="input" placeholder="Message (Ctrl+Enter to send)"></textarea>
+  <button type="submit" id="send">Send</button>
+</form>
+<script>
+const base = "{{API_BASE}}";
+const $ = id => document.getElementById(id);
+let messages = [];
+
+$("key").value = localStorage.getItem("gptoss2api-key") || "";
+$("key").onchange = () => { localStorage.setItem("gptoss2api-key", $("key").value); loadModels(); };
+$("clear").onclick = () => { messages = []; $("log").innerHTML = ""; };
+$("input").onkeydown = e => { if (e.key === "Enter" && (e.ctrlKey || e.metaKey)) $("form").requestSubmit(); };
+
+function headers() {
+  const h = { "Content-Type": "application/json" };
+  if ($("key").value) h["Authorization"] = "Bearer " + $("key").value;
+  return h;
+}
+
+async function loadModels() {
+  const select = $("model");
+  try {
+    const resp = await fetch(base + "/models", { headers: headers() });
+    const body = await resp.json();
+    if (!resp.ok) throw new Error(body.error ? body.error.message : resp.statusText);
+    select.innerHTML = "";
+    for (const m of body.data) select.add(new Option(m.id, m.id));
+  } catch (e) {
+    select.innerHTML = "";
+    select.add(new Option("(default)", ""));
+  }
+}
+
+function addMessage(role) {
+  const div = document.createElement("div");
+  div.className = "msg";
+  div.innerHTML = '<div class="role"></div><div class="reasoning" hidden></div><div class="content"></div><div class="usage"></div>';
+  div.querySelector(".role").textContent = role;
+  $("log").appendChild(div);
+  return {
+    reasoning: div.querySelector(".reasoning"),
+    content: div.querySelector(".content"),
+    usage: div.querySelector(".usage"),
+  };
+}
+
+function showUsage(el, usage, started) {
+  if (!usage) return;
+  el.textContent = usage.prompt_tokens + " prompt + " + usage.completion_tokens + " completion = " + usage.total_tokens + " tokens, " + ((Date.now() - started) / 1000).toFixed(1) + "s";
+}
+
+$("form").onsubmit = async e => {
+  e.preventDefault();
+  const text = $("input").value.trim();
+  if (!text) return;
+  $("input").value = "";
+  messages.push({ role: "user", content: text });
+  addMessage("user").content.textContent = text;
+  const out = addMessage("assistant");
+  const stream = $("stream").checked;
+  const req = {
+    messages: messages,
+    stream: stream,
+    reasoning_mode: $("reasoning").checked ? "reasoning_content" : "strip",
+  };
+  if ($("model").value) req.model = $("model").value;
+  if (stream) req.stream_options = { include_usage: true };
+  $("send").disabled = true;
+  const started = Date.now();
+  let answer = "";
+  try {
+    const resp = await fetch(base + "/chat/completions", { method: "POST", headers: headers(), body: JSON.stringify(req) });
+    if (!resp.ok) {
+      const body = await resp.json().catch(() => ({}));
+      throw new Error(body.error ? body.error.message : resp.status + " " + resp.statusText);
+    }
+    const apply = (delta) => {
+      if (delta.reasoning_content) { out.reasoning.hidden = false; out.reasoning.textContent += delta.reasoning_content; }
+      if (delta.content) { answer += delta.content; out.content.textContent = answer; }
+    };
+    if (!stream) {
+      const body = await resp.json();
+      apply(body.choices[0].message);
+      showUsage(out.usage, body.usage, started);
+    } else {
+      const reader = resp.body.getReader();
+      const decoder = new TextDecoder();
+      let buffer = "";
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buffer += decoder.decode(value, { stream: true });
+        const lines = buffer.split("\n");
+        buffer = lines.pop();
+        for (const line of lines) {
+          if (!line.startsWith("data: ") || line === "data: [DONE]") continue;
+          const chunk = JSON.parse(line.slice(6));
+          if (chunk.error) throw new Error(chunk.error.message);
+          if (chunk.choices && chunk.choices.length) apply(chunk.choices[0].delta);
+          if (chunk.usage) showUsage(out.usage, chunk.usage, started);
+        }
+        $("log").scrollTop = $("log").scrollHeight;
+      }
+    }
+    messages.push({ role: "assistant", content: answer });
+  } catch (err) {
+    out.content.classList.add("error");
+    out.content.textContent = err.message;
+    messages.pop();
+  } finally {
+    $("send").disabled = false;
+    $("log").scrollTop = $("log").scrollHeight;
+  }
+};
+
+loadModels();
+</script>
+</body>
+</html>
+`
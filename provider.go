@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider 把 OpenAI 格式的聊天请求发给一种上游：聊天接口的默认上游（defaultProvider）、
+// 别名指定的其他上游和备用上游都经过它。缓存、重复请求合并和故障转移在 Provider 之上实现，
+// 对所有上游一致
+type Provider interface {
+	// Convert 返回发给上游的请求体
+	Convert(openaiReq OpenAIRequest, model string) interface{}
+	// Call 发送非流式请求，返回 OpenAI 格式的响应
+	Call(ctx context.Context, openaiReq OpenAIRequest, model string) (OpenAIResponse, error)
+	// Stream 发送流式请求，状态码为 200 时返回的响应体由调用方读取并关闭。
+	// Cloudflare 返回 Responses API 事件，OpenAI 兼容上游返回 chat.completion.chunk
+	Stream(ctx context.Context, openaiReq OpenAIRequest, model string) (*http.Response, error)
+}
+
+// Cloudflare Responses API；设置了账号时使用该账号和令牌，否则使用默认账号
+type cloudflareProvider struct {
+	AccountID string
+	APIToken  string
+}
+
+// 聊天接口的默认上游：按租户、账号池和 -id/-token 选择账号
+var defaultProvider Provider = &cloudflareProvider{}
+
+func (p *cloudflareProvider) context(ctx context.Context) context.Context {
+	if p.AccountID == "" {
+		return ctx
+	}
+	return withAccountOverride(ctx, &poolAccount{AccountID: p.AccountID, AuthToken: p.APIToken})
+}
+
+func (p *cloudflareProvider) Convert(openaiReq OpenAIRequest, model string) interface{} {
+	return convertToCloudflareRequest(openaiReq, model)
+}
+
+func (p *cloudflareProvider) Call(ctx context.Context, openaiReq OpenAIRequest, model string) (OpenAIResponse, error) {
+	cfResp, raw, err := callCloudflareAPI(convertToCloudflareRequest(openaiReq, model), p.context(ctx))
+	if err != nil {
+		return OpenAIResponse{}, err
+	}
+	if reqLog := contextRequestLog(ctx); reqLog != nil {
+		// 打印 Cloudflare 原始响应（不转义）
+		reqLog.Body(tr("upstream_raw"), raw)
+	}
+	return convertToOpenAIResponse(cfResp, openaiReq), nil
+}
+
+// 响应体带上请求的 token 预估值，调用方读完流、拿到真实用量后用 reportStreamTokens 修正限流计数
+func (p *cloudflareProvider) Stream(ctx context.Context, openaiReq OpenAIRequest, model string) (*http.Response, error) {
+	resp, estimated, err := openCloudflareStream(convertToCloudflareRequest(openaiReq, model), p.context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &estimatedBody{ReadCloser: resp.Body, estimated: estimated}
+	return resp, nil
+}
+
+type estimatedBody struct {
+	io.ReadCloser
+	estimated int
+}
+
+func reportStreamTokens(resp *http.Response, actual int) {
+	if body, ok := resp.Body.(*estimatedBody); ok {
+		reportUpstreamTokens(actual, body.estimated)
+	}
+}
+
+// OpenAI 兼容的 /chat/completions 接口（OpenAI、Groq、vLLM、Ollama 等）
+type openAIProvider struct {
+	BaseURL string
+	APIKey  string
+}
+
+// 只在代理内生效的字段不发给上游；服务端系统提示词作为第一条 system 消息
+func (p *openAIProvider) Convert(openaiReq OpenAIRequest, model string) interface{} {
+	openaiReq.Model = model
+	openaiReq.ReasoningMode = ""
+	openaiReq.StreamChunking, openaiReq.StreamDelayMs = "", nil
+	openaiReq.Cache = ""
+	if prompt := systemPromptFor(model); prompt != "" {
+		openaiReq.Messages = append([]Message{{Role: "system", Content: prompt}}, openaiReq.Messages...)
+	}
+	return openaiReq
+}
+
+func (p *openAIProvider) Call(ctx context.Context, openaiReq OpenAIRequest, model string) (OpenAIResponse, error) {
+	openaiReq.Stream = false
+	resp, err := p.post(ctx, openaiReq, model, false)
+	if err != nil {
+		return OpenAIResponse{}, err
+	}
+	defer resp.Body.Close()
+	var openaiResp OpenAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&openaiResp); err != nil {
+		return OpenAIResponse{}, err
+	}
+	if len(openaiResp.Choices) == 0 {
+		return OpenAIResponse{}, fmt.Errorf("upstream returned no choices")
+	}
+	return openaiResp, nil
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, openaiReq OpenAIRequest, model string) (*http.Response, error) {
+	openaiReq.Stream = true
+	// 用量只在最后的用量事件中返回，计费和统计都依赖它，不论客户端是否要求
+	openaiReq.StreamOptions = &StreamOptions{IncludeUsage: true}
+	return p.post(ctx, openaiReq, model, true)
+}
+
+func (p *openAIProvider) post(ctx context.Context, openaiReq OpenAIRequest, model string, stream bool) (*http.Response, error) {
+	reqBody, _ := json.Marshal(p.Convert(openaiReq, model))
+	url := strings.TrimRight(p.BaseURL, "/") + "/chat/completions"
+	httpReq, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	traceUpstreamRequest(ctx, httpReq)
+	resp, err := upstreamHTTPClient(stream).Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	traceUpstreamResponse(ctx, resp)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newUpstreamError(resp, body)
+	}
+	return resp, nil
+}
+
+// -providers 文件中的上游，键为名称；别名目标写成 "名称:模型" 时使用对应上游
+type providerConfig struct {
+	Type      string `json:"type"`
+	BaseURL   string `json:"base_url"`
+	APIKey    string `json:"api_key"`
+	AccountID string `json:"account_id"`
+	APIToken  string `json:"api_token"`
+}
+
+var (
+	providers   = map[string]Provider{}
+	providersMu sync.RWMutex
+)
+
+func loadProviders() error {
+	loaded := map[string]Provider{}
+	if config().ProvidersFile != "" {
+		data, err := os.ReadFile(config().ProvidersFile)
+		if err != nil {
+			return err
+		}
+		var configs map[string]providerConfig
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return fmt.Errorf("invalid -providers file: %v", err)
+		}
+		for name, c := range configs {
+			if name == "" || strings.ContainsAny(name, ":/") {
+				return fmt.Errorf("invalid provider name %q", name)
+			}
+			switch c.Type {
+			case "openai":
+				if c.BaseURL == "" {
+					return fmt.Errorf("provider %q requires base_url", name)
+				}
+				loaded[name] = &openAIProvider{BaseURL: c.BaseURL, APIKey: c.APIKey}
+			case "cloudflare":
+				if (c.AccountID == "") != (c.APIToken == "") {
+					return fmt.Errorf("provider %q requires both account_id and api_token", name)
+				}
+				loaded[name] = &cloudflareProvider{AccountID: c.AccountID, APIToken: c.APIToken}
+			default:
+				return fmt.Errorf("provider %q has invalid type %q, expected openai or cloudflare", name, c.Type)
+			}
+		}
+	}
+	providersMu.Lock()
+	providers = loaded
+	providersMu.Unlock()
+	return nil
+}
+
+// 解析 "名称:模型" 形式的模型名；Cloudflare 模型名中没有冒号，不会误判
+func providerFor(model string) (Provider, string) {
+	name, upstream, ok := strings.Cut(model, ":")
+	if !ok {
+		return nil, model
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	if p, ok := providers[name]; ok {
+		return p, upstream
+	}
+	return nil, model
+}
+
+func providerSecrets() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	var secrets []string
+	for _, p := range providers {
+		switch p := p.(type) {
+		case *openAIProvider:
+			secrets = append(secrets, p.APIKey)
+		case *cloudflareProvider:
+			secrets = append(secrets, p.APIToken)
+		}
+	}
+	return secrets
+}
+
+// 模型名指定了 -providers 中的上游时：Cloudflare 上游把账号写入请求上下文，继续走默认流程；
+// 其他上游返回 Provider，由调用方改用 handleProviderChat
+func routeProvider(r *http.Request, model string) (*http.Request, string, Provider) {
+	p, upstream := providerFor(model)
+	if p == nil {
+		return r, model, nil
+	}
+	if cf, ok := p.(*cloudflareProvider); ok {
+		return r.WithContext(cf.context(r.Context())), upstream, nil
+	}
+	return r, upstream, p
+}
+
+// 别名指向非 Cloudflare 上游时的聊天请求：OpenAI 兼容上游的响应已是目标格式，流式响应原样转发
+func handleProviderChat(w http.ResponseWriter, r *http.Request, p Provider, model string, openaiReq OpenAIRequest, reqLog *requestLog) {
+	upstreamStart := time.Now()
+	if openaiReq.Stream {
+		resp, err := p.Stream(r.Context(), openaiReq, model)
+		recordModelResult(model, err, time.Since(upstreamStart))
+		if err != nil {
+			recordUsage(r, model, Usage{}, err)
+			writeUpstreamError(w, err)
+			return
+		}
+		usage := proxyFallbackStream(w, resp, wantsStreamUsage(openaiReq))
+		recordUsage(r, model, usage, nil)
+		return
+	}
+	openaiResp, err := p.Call(r.Context(), openaiReq, model)
+	recordModelResult(model, err, time.Since(upstreamStart))
+	if err != nil {
+		recordUsage(r, model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	recordUsage(r, model, openaiResp.Usage, nil)
+	applyFooter(&openaiResp, openaiReq)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(openaiResp)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// 令牌桶，按每分钟速率补充，容量即允许的突发量
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	rate     float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute, burst float64) *tokenBucket {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &tokenBucket{
+		capacity: burst,
+		tokens:   burst,
+		rate:     perMinute / 60,
+		last:     time.Now(),
+	}
+}
+
+func (b *tokenBucket) refillLocked() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// 预留 n 个令牌，返回需要等待的时间；余额允许为负，由后续补充抵消。
+// 单次最多预留整个桶的容量，估算偏大的请求至多让后面的请求等一个补满周期
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked()
+	b.tokens -= min(n, b.capacity)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 归还预留但未使用的令牌，例如客户端在排队期间断开
+func (b *tokenBucket) refund(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked()
+	b.tokens += min(n, b.capacity)
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// 不排队的限流：令牌足够时立即扣除，否则不扣除并返回需要等待的时间；同时返回剩余令牌数
+func (b *tokenBucket) take(n float64) (time.Duration, float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked()
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, b.tokens
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second)), b.tokens
+}
+
+var upstreamLimiter struct {
+	mu       sync.Mutex
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+// 实例级上游限速，与 Cloudflare 账号限额对齐，平滑突发流量。重新加载配置时再次调用，
+// 速率改变的桶重新创建，未改变的保留当前余额
+func initUpstreamLimiter() {
+	upstreamLimiter.mu.Lock()
+	defer upstreamLimiter.mu.Unlock()
+	upstreamLimiter.requests = resizeBucket(upstreamLimiter.requests, config().UpstreamRPM)
+	upstreamLimiter.tokens = resizeBucket(upstreamLimiter.tokens, config().UpstreamTPM)
+}
+
+func resizeBucket(b *tokenBucket, perMinute float64) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	if b != nil && b.rate == perMinute/60 {
+		return b
+	}
+	return newTokenBucket(perMinute, 0)
+}
+
+func upstreamBuckets() (requests, tokens *tokenBucket) {
+	upstreamLimiter.mu.Lock()
+	defer upstreamLimiter.mu.Unlock()
+	return upstreamLimiter.requests, upstreamLimiter.tokens
+}
+
+// 调用上游前排队等待令牌，estimatedTokens 为按请求体估算的 token 数
+func waitUpstreamSlot(ctx context.Context, estimatedTokens int) error {
+	defer trackPhase(ctx, "queue", time.Now())
+	if store.Shared() {
+		err := waitSharedUpstreamSlot(ctx, estimatedTokens)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		// Redis 不可用时退回本地令牌桶，避免限流组件拖垮请求
+		logf(slog.LevelWarn, tr("redis_limit_fallback"), err)
+	}
+
+	requestBucket, tokenBucket := upstreamBuckets()
+	var wait time.Duration
+	if requestBucket != nil {
+		wait = requestBucket.reserve(1)
+	}
+	if tokenBucket != nil {
+		if w := tokenBucket.reserve(float64(estimatedTokens)); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	metrics.inc("gptoss2api_upstream_throttled_total")
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// 请求不会再发往上游，预留的令牌留给后面排队的请求
+		if requestBucket != nil {
+			requestBucket.refund(1)
+		}
+		if tokenBucket != nil {
+			tokenBucket.refund(float64(estimatedTokens))
+		}
+		return ctx.Err()
+	}
+}
+
+// 拿到真实用量后修正预估值
+func reportUpstreamTokens(actual, estimated int) {
+	if actual <= estimated {
+		return
+	}
+	if store.Shared() && config().UpstreamTPM > 0 {
+		if _, err := incrWindow("upstream:tokens", actual-estimated, time.Minute); err == nil {
+			return
+		}
+	}
+	if _, tokenBucket := upstreamBuckets(); tokenBucket != nil {
+		tokenBucket.reserve(float64(actual - estimated))
+	}
+}
+
+func waitSharedUpstreamSlot(ctx context.Context, estimatedTokens int) error {
+	if config().UpstreamRPM > 0 {
+		if err := waitSharedWindow(ctx, "upstream:requests", 1, config().UpstreamRPM); err != nil {
+			return err
+		}
+	}
+	if config().UpstreamTPM > 0 {
+		if err := waitSharedWindow(ctx, "upstream:tokens", estimatedTokens, config().UpstreamTPM); err != nil {
+			if config().UpstreamRPM > 0 {
+				// 请求数已经计入窗口，撤回
+				incrWindow("upstream:requests", -1, time.Minute)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// 多副本部署时使用 Redis 按分钟固定窗口计数，限额在整个集群内生效；
+// 超出时撤回本次计数并等待下一个窗口。与本地令牌桶一样，单次计数不超过整个窗口的限额
+func waitSharedWindow(ctx context.Context, name string, n int, limit float64) error {
+	n = max(min(n, int(limit)), 1)
+	for {
+		count, err := incrWindow(name, n, time.Minute)
+		if err != nil {
+			return err
+		}
+		// 单次请求超过整个窗口限额时直接放行，避免永远等待
+		if float64(count) <= limit || count == int64(n) {
+			return nil
+		}
+		if _, err := incrWindow(name, -n, time.Minute); err != nil {
+			return err
+		}
+
+		metrics.inc("gptoss2api_upstream_throttled_total")
+		wait := time.Minute - time.Duration(time.Now().UnixNano()%int64(time.Minute))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// 粗略估算：约 4 个字节一个 token
+func estimateTokens(body []byte) int {
+	return len(body)/4 + 1
+}
+
+// run 接口的请求体中音频、图片和蒙版以 base64 或字节数组传递，不是 token，
+// 只按其中的文本估算，否则一次大文件上传会占满 -upstream-tpm
+var binaryPayloadFields = map[string]bool{"audio": true, "image": true, "mask": true}
+
+func estimateRunTokens(payload interface{}) int {
+	data, _ := json.Marshal(payload)
+	var value interface{}
+	json.Unmarshal(data, &value)
+	return payloadTextLength(value)/4 + 1
+}
+
+func payloadTextLength(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []interface{}:
+		total := 0
+		for _, item := range v {
+			total += payloadTextLength(item)
+		}
+		return total
+	case map[string]interface{}:
+		total := 0
+		for key, item := range v {
+			if !binaryPayloadFields[key] {
+				total += payloadTextLength(item)
+			}
+		}
+		return total
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateRunTokens(t *testing.T) {
+	audio := strings.Repeat("A", 4<<20)
+	tests := []struct {
+		name    string
+		payload interface{}
+		want    int
+	}{
+		{name: "text", payload: map[string]interface{}{"text": []string{"12345678", "1234"}}, want: 4},
+		{name: "base64 audio", payload: map[string]interface{}{"audio": audio, "task": "transcribe", "initial_prompt": "1234"}, want: 4},
+		{name: "byte arrays", payload: map[string]interface{}{"image": []int{1, 2, 3}, "mask": []int{4}, "prompt": "12345678"}, want: 3},
+	}
+	for _, tt := range tests {
+		if got := estimateRunTokens(tt.payload); got != tt.want {
+			t.Errorf("%s: estimateRunTokens = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBucketReserveIsCapped(t *testing.T) {
+	b := newTokenBucket(600, 0)
+	if wait := b.reserve(1e9); wait > time.Minute {
+		t.Errorf("oversized reservation waits %v, want at most one refill period", wait)
+	}
+	b.refund(1e9)
+	if b.tokens > b.capacity || b.tokens < b.capacity-1 {
+		t.Errorf("tokens after refund = %v, want about %v", b.tokens, b.capacity)
+	}
+}
+
+func TestWaitUpstreamSlotRefundsOnCancel(t *testing.T) {
+	// 最先注册，在恢复参数之后执行
+	t.Cleanup(initUpstreamLimiter)
+	withConfig(t, func(c *Config) { c.UpstreamRPM = 60 })
+	initUpstreamLimiter()
+	requests, _ := upstreamBuckets()
+	requests.reserve(60)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := waitUpstreamSlot(ctx, 1); err == nil {
+		t.Fatal("expected the request to wait")
+	}
+	if requests.tokens < 0 {
+		t.Errorf("reserved request was not refunded: %v", requests.tokens)
+	}
+}
+
+func TestInitUpstreamLimiterOnReload(t *testing.T) {
+	// 最先注册，在恢复参数之后执行
+	t.Cleanup(initUpstreamLimiter)
+	withConfig(t, func(c *Config) { c.UpstreamRPM = 60 })
+	initUpstreamLimiter()
+	first, tokens := upstreamBuckets()
+	if first == nil || tokens != nil {
+		t.Fatalf("buckets %v %v", first, tokens)
+	}
+
+	initUpstreamLimiter()
+	if same, _ := upstreamBuckets(); same != first {
+		t.Error("unchanged rate should keep the bucket")
+	}
+	withConfig(t, func(c *Config) {
+		c.UpstreamRPM = 120
+		c.UpstreamTPM = 1000
+	})
+	initUpstreamLimiter()
+	if changed, tokens := upstreamBuckets(); changed == first || changed.capacity != 120 || tokens == nil {
+		t.Error("changed rates should rebuild the buckets")
+	}
+	withConfig(t, func(c *Config) { c.UpstreamRPM, c.UpstreamTPM = 0, 0 })
+	initUpstreamLimiter()
+	if requests, tokens := upstreamBuckets(); requests != nil || tokens != nil {
+		t.Error("disabled limits should remove the buckets")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "fmt"
+
+// 推理内容的输出方式：think-tags 包在 <think></think> 中放入正文（默认），
+// reasoning_content 放入单独的 reasoning_content 字段（DeepSeek 风格），strip 直接丢弃
+const (
+	reasoningThinkTags = "think-tags"
+	reasoningContent   = "reasoning_content"
+	reasoningStrip     = "strip"
+)
+
+func validateReasoningMode(mode string) error {
+	switch mode {
+	case "", reasoningThinkTags, reasoningContent, reasoningStrip:
+		return nil
+	}
+	return fmt.Errorf("reasoning_mode must be one of think-tags, reasoning_content or strip")
+}
+
+// 请求中的 reasoning_mode 优先于 -reasoning-mode；JSON 模式下正文前的 <think> 标签会破坏解析，
+// 未在请求中指定时改为 strip
+func reasoningMode(openaiReq OpenAIRequest) string {
+	if openaiReq.ReasoningMode != "" {
+		return openaiReq.ReasoningMode
+	}
+	mode := config().ReasoningMode
+	if mode == "" {
+		mode = reasoningThinkTags
+	}
+	if mode == reasoningThinkTags && isJSONMode(openaiReq) {
+		return reasoningStrip
+	}
+	return mode
+}
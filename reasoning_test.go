@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReasoningModes(t *testing.T) {
+	const resp = `{"id":"resp_1","created_at":1,"model":"m","status":"completed",
+		"output":[{"type":"reasoning","content":[{"type":"reasoning_text","text":"thinking"}]},
+			{"type":"message","role":"assistant","content":[{"type":"output_text","text":"hello"}]}],
+		"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`
+	tests := []struct {
+		name       string
+		configMode string
+		req        OpenAIRequest
+		want       string
+	}{
+		{name: "think tags by default", want: `{"role":"assistant","content":"<think>thinking</think>\nhello"}`},
+		{name: "reasoning_content", req: OpenAIRequest{ReasoningMode: reasoningContent}, want: `{"role":"assistant","content":"hello","reasoning_content":"thinking"}`},
+		{name: "strip", req: OpenAIRequest{ReasoningMode: reasoningStrip}, want: `{"role":"assistant","content":"hello"}`},
+		{name: "configured default", configMode: reasoningContent, want: `{"role":"assistant","content":"hello","reasoning_content":"thinking"}`},
+		{name: "request overrides configured default", configMode: reasoningContent, req: OpenAIRequest{ReasoningMode: reasoningThinkTags}, want: `{"role":"assistant","content":"<think>thinking</think>\nhello"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.ReasoningMode = tt.configMode })
+			var cfResp CloudflareResponse
+			if err := json.Unmarshal([]byte(resp), &cfResp); err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, convertToOpenAIResponse(&cfResp, tt.req).Choices[0].Message, tt.want)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// 处理函数中的 panic（例如上游返回空 choices 时的越界访问）只影响当前请求：
+// 记录带调用栈的错误日志，尚未写出响应时返回 OpenAI 格式的 500 错误，
+// 流式响应已经开始时追加一个错误事件，进程继续运行
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// 处理函数主动中止连接，交给 net/http 处理
+				panic(v)
+			}
+			metrics.inc("gptoss2api_panics_total")
+			logf(slog.LevelError, tr("handler_panic"), r.Method, r.URL.Path, v, debug.Stack())
+			if rec.status == 0 {
+				writeError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
+				return
+			}
+			if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+				w.Write([]byte("data: "))
+				json.NewEncoder(w).Encode(errorEnvelope(http.StatusInternalServerError, "internal_error", "Internal server error", ""))
+				w.Write([]byte("\n"))
+				rec.Flush()
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 极简 Redis 客户端，只实现共享存储需要的 RESP 命令收发
+type redisClient struct {
+	mu     sync.Mutex
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+var redisConn *redisClient
+
+func initRedis() {
+	redisConn = &redisClient{addr: config().RedisAddr}
+	if _, err := redisConn.do("PING"); err != nil {
+		logf(slog.LevelWarn, tr("redis_failed"), err)
+		return
+	}
+	log.Printf(tr("redis_connected"), config().RedisAddr)
+}
+
+func (c *redisClient) connectLocked() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if config().RedisPassword != "" {
+		if _, err := c.roundTripLocked("AUTH", config().RedisPassword); err != nil {
+			c.closeLocked()
+			return err
+		}
+	}
+	if config().RedisDB != 0 {
+		if _, err := c.roundTripLocked("SELECT", strconv.Itoa(config().RedisDB)); err != nil {
+			c.closeLocked()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) closeLocked() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = nil
+	c.reader = nil
+}
+
+// 执行命令，连接断开时自动重连一次
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			if err := c.connectLocked(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := c.roundTripLocked(args...)
+		if _, isRedisErr := err.(redisError); err == nil || isRedisErr {
+			return reply, err
+		}
+		c.closeLocked()
+	}
+	return nil, fmt.Errorf("redis: connection to %s lost", c.addr)
+}
+
+func (c *redisClient) roundTripLocked(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	return c.readReplyLocked()
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisClient) readReplyLocked() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReplyLocked(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 收到 SIGHUP（或开启 -watch-config 后配置文件发生变化）时重新读取配置文件和环境变量，
+// 重建客户端密钥、上游凭据、账号池、模型别名、限额、租户和改写规则。进程和监听端口不变，
+// 进行中的请求和 SSE 流不受影响。命令行参数的优先级仍然最高，不会被覆盖；
+// 从配置文件中删除的参数保留当前值，不会恢复为默认值
+func startConfigReload() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			reloadConfig()
+		}
+	}()
+
+	if !config().WatchConfig || config().ConfigFile == "" {
+		return
+	}
+	go func() {
+		var modTime time.Time
+		if info, err := os.Stat(config().ConfigFile); err == nil {
+			modTime = info.ModTime()
+		}
+		for {
+			time.Sleep(config().CredentialPoll)
+			info, err := os.Stat(config().ConfigFile)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			reloadConfig()
+		}
+	}()
+}
+
+// 串行化重新加载配置和管理接口对运行中配置的修改
+var configMu sync.Mutex
+
+// 配置有误时保留旧配置继续运行；各项按顺序加载，出错时已加载的项恢复为旧值
+func reloadConfig() bool {
+	configMu.Lock()
+	defer configMu.Unlock()
+	previousFlags := flagValues
+	previous := config()
+	if err := applyConfigSources(previous.ConfigFile); err != nil {
+		flagValues = previousFlags
+		logf(slog.LevelError, tr("config_reload_failed"), err)
+		return false
+	}
+	publishConfig()
+	steps := []func() error{
+		func() error { return validateReasoningMode(config().ReasoningMode) },
+		func() error { return validateContextTrim(config().ContextTrim) },
+		func() error { return validateAuthMethods(config().AuthMethods) },
+		loadIPFilters,
+		initCredentials,
+		loadAccountPool,
+		loadFailover,
+		loadKeyLimits,
+		loadTenants,
+		loadRules,
+		loadProviders,
+		loadModelAliases,
+		loadSystemPrompts,
+		loadModelDefaults,
+		func() error { initUpstreamLimiter(); return nil },
+		func() error { return setLogLevel(config().LogLevel) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			logf(slog.LevelError, tr("config_reload_failed"), err)
+			flagValues = previousFlags
+			currentConfig.Store(previous)
+			for _, undo := range steps[:i] {
+				undo()
+			}
+			return false
+		}
+	}
+	metrics.inc("gptoss2api_config_reloads_total")
+	log.Print(tr("config_reloaded"))
+	if config().Warmup {
+		// 令牌或账号可能已经更换，在后台重新预热
+		go warmupUpstream()
+	}
+	return true
+}
+
+// 管理接口修改运行中的配置：改写参数值后发布新的快照。之后重新加载时，配置文件或环境变量中
+// 设置了同一参数的会覆盖这里的修改，需要保留的修改由管理接口的 ?persist=true 写回配置文件
+func updateConfig(apply func(*Config)) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	apply(&flagValues)
+	publishConfig()
+}
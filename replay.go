@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 保存下来可供重放的一次聊天请求，按响应 ID 存入共享存储
+type replayRecord struct {
+	Request  json.RawMessage `json:"request"`
+	Model    string          `json:"model"`
+	Response string          `json:"response"`
+	Created  time.Time       `json:"created"`
+}
+
+func replayKey(id string) string {
+	return "replay:" + id
+}
+
+// 在 -replay-ttl 时间内保留请求体（已应用改写规则）和模型输出
+func saveReplayRecord(id string, body []byte, model, response string) {
+	if config().ReplayTTL <= 0 || id == "" {
+		return
+	}
+	record, _ := json.Marshal(replayRecord{
+		Request:  body,
+		Model:    model,
+		Response: response,
+		Created:  time.Now(),
+	})
+	store.Set(replayKey(id), string(record), config().ReplayTTL)
+}
+
+func authorizeAdmin(r *http.Request) bool {
+	if config().AdminKey == "" {
+		return false
+	}
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(config().AdminKey)) == 1
+}
+
+// POST /admin/replay/{id}：以非流式方式重新执行保存的请求，可通过 {"model": "..."} 换用其他模型，
+// 返回原始输出和重放输出便于对比
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/admin/replay/")
+	value, ok, err := store.Get(replayKey(id))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "replay_not_found", "No stored request with this ID")
+		return
+	}
+	var record replayRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Stored request is corrupted")
+		return
+	}
+
+	var options struct {
+		Model string `json:"model"`
+	}
+	json.NewDecoder(r.Body).Decode(&options)
+	model := record.Model
+	if options.Model != "" {
+		// 管理员指定的模型名原样使用，只展开别名
+		model = options.Model
+		if target, ok := lookupModelAlias(model); ok {
+			model = target
+		}
+	}
+
+	var openaiReq OpenAIRequest
+	if err := json.Unmarshal(record.Request, &openaiReq); err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Stored request is corrupted")
+		return
+	}
+	openaiReq.Stream = false
+
+	start := time.Now()
+	cfResp, _, err := callCloudflareAPI(convertToCloudflareRequest(openaiReq, model), r.Context())
+	if err != nil {
+		writeUpstreamError(w, err)
+		return
+	}
+	replayResp := convertToOpenAIResponse(cfResp, openaiReq)
+	replayed, _ := replayResp.Choices[0].Message.Content.(string)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]interface{}{
+		"id":             id,
+		"original_model": record.Model,
+		"replay_model":   model,
+		"original":       record.Response,
+		"replay":         replayed,
+		"identical":      replayed == record.Response,
+		"latency_ms":     time.Since(start).Milliseconds(),
+		"usage":          replayResp.Usage,
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每百万 token 的美元价格（输入、输出），用于估算费用
+var modelPrices = map[string][2]float64{
+	"@cf/openai/gpt-oss-120b": {0.35, 0.75},
+	"@cf/openai/gpt-oss-20b":  {0.20, 0.30},
+}
+
+type usageTotals struct {
+	Requests         int     `json:"requests"`
+	Errors           int     `json:"errors"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+func (t *usageTotals) add(usage Usage, cost float64, failed bool) {
+	t.Requests++
+	if failed {
+		t.Errors++
+	}
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.CostUSD += cost
+}
+
+type usageReport struct {
+	mu     sync.Mutex
+	since  time.Time
+	total  usageTotals
+	keys   map[string]*usageTotals
+	models map[string]*usageTotals
+}
+
+var reports = &usageReport{
+	since:  time.Now(),
+	keys:   make(map[string]*usageTotals),
+	models: make(map[string]*usageTotals),
+}
+
+func estimateCost(model string, usage Usage) float64 {
+	price, ok := modelPrices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price[0] + float64(usage.CompletionTokens)*price[1]) / 1e6
+}
+
+// 报告中不出现完整密钥，只保留首尾几位
+func maskKey(key string) string {
+	if key == "" {
+		return "anonymous"
+	}
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+// 具名密钥以 ID 标识，其他密钥只保留首尾几位
+func usageLabel(identity string) string {
+	if isClientKeyID(identity) {
+		return identity
+	}
+	return maskKey(identity)
+}
+
+// 每个请求结束时调用一次：扣减按密钥的每日额度、写入用量明细并计入周期报告
+func recordUsage(r *http.Request, model string, usage Usage, err error) {
+	identity := clientIdentity(r)
+	chargeKeyTokens(identity, usage.TotalTokens)
+	record := usageRecord{
+		Time:             time.Now(),
+		RequestID:        requestID(r.Context()),
+		Key:              usageLabel(identity),
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		LatencyMs:        requestElapsed(r.Context()).Milliseconds(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	appendUsageRecord(record)
+	dashboard.recordRequest(record.Key, usage.TotalTokens, err != nil)
+	noteAccessUsage(r.Context(), model, usage.TotalTokens)
+
+	if config().ReportInterval <= 0 {
+		return
+	}
+	cost := estimateCost(model, usage)
+	label := usageLabel(identity)
+
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+	reports.total.add(usage, cost, err != nil)
+	if reports.keys[label] == nil {
+		reports.keys[label] = &usageTotals{}
+	}
+	reports.keys[label].add(usage, cost, err != nil)
+	if reports.models[model] == nil {
+		reports.models[model] = &usageTotals{}
+	}
+	reports.models[model].add(usage, cost, err != nil)
+}
+
+type rankedUsage struct {
+	Name string `json:"name"`
+	usageTotals
+}
+
+type usageSummary struct {
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Total     usageTotals   `json:"total"`
+	ErrorRate float64       `json:"error_rate"`
+	TopKeys   []rankedUsage `json:"top_keys"`
+	TopModels []rankedUsage `json:"top_models"`
+}
+
+// 按 token 总量排序，只保留前 10 名
+func topUsage(items map[string]*usageTotals) []rankedUsage {
+	ranked := make([]rankedUsage, 0, len(items))
+	for name, totals := range items {
+		ranked = append(ranked, rankedUsage{Name: name, usageTotals: *totals})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].PromptTokens+ranked[i].CompletionTokens > ranked[j].PromptTokens+ranked[j].CompletionTokens
+	})
+	if len(ranked) > 10 {
+		ranked = ranked[:10]
+	}
+	return ranked
+}
+
+// 生成当前周期的汇总并清零，开始新的周期
+func (u *usageReport) rotate() usageSummary {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	summary := usageSummary{
+		From:      u.since,
+		To:        now,
+		Total:     u.total,
+		TopKeys:   topUsage(u.keys),
+		TopModels: topUsage(u.models),
+	}
+	if u.total.Requests > 0 {
+		summary.ErrorRate = float64(u.total.Errors) / float64(u.total.Requests)
+	}
+	u.since = now
+	u.total = usageTotals{}
+	u.keys = make(map[string]*usageTotals)
+	u.models = make(map[string]*usageTotals)
+	return summary
+}
+
+func (s usageSummary) text() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[gptoss2api] usage %s ~ %s\n", s.From.Format("2006-01-02 15:04"), s.To.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&sb, "requests=%d errors=%d (%.1f%%) prompt_tokens=%d completion_tokens=%d cost≈$%.4f\n",
+		s.Total.Requests, s.Total.Errors, s.ErrorRate*100, s.Total.PromptTokens, s.Total.CompletionTokens, s.Total.CostUSD)
+	for _, k := range s.TopKeys {
+		fmt.Fprintf(&sb, "key %s: requests=%d tokens=%d cost≈$%.4f\n", k.Name, k.Requests, k.PromptTokens+k.CompletionTokens, k.CostUSD)
+	}
+	for _, m := range s.TopModels {
+		fmt.Fprintf(&sb, "model %s: requests=%d errors=%d tokens=%d\n", m.Name, m.Requests, m.Errors, m.PromptTokens+m.CompletionTokens)
+	}
+	return sb.String()
+}
+
+// 周期对齐到 UTC 整点（例如 24h 对齐到每天零点），到点后写入文件和/或 webhook
+func startUsageReports() {
+	if config().ReportInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			next := time.Now().Truncate(config().ReportInterval).Add(config().ReportInterval)
+			time.Sleep(time.Until(next))
+			deliverUsageReport(reports.rotate())
+		}
+	}()
+}
+
+func deliverUsageReport(summary usageSummary) {
+	if config().ReportFile != "" {
+		line, _ := json.Marshal(summary)
+		f, err := os.OpenFile(config().ReportFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logf(slog.LevelError, tr("report_failed"), err)
+		} else {
+			f.Write(append(line, '\n'))
+			f.Close()
+		}
+	}
+	if config().ReportWebhook != "" {
+		generic := map[string]interface{}{"report": summary}
+		if err := postWebhook(config().ReportWebhook, summary.text(), generic); err != nil {
+			logf(slog.LevelError, tr("report_failed"), err)
+		}
+	}
+	log.Printf(tr("report_done"), summary.Total.Requests)
+}
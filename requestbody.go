@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 限制请求体大小，-max-body-size 为 0 时不限制。声明的 Content-Length 超出时直接返回 413，
+// 其余情况由处理函数读取请求体时通过 readRequestBody 发现超限
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(config().MaxBodySize) << 20
+		if limit <= 0 || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			metrics.inc("gptoss2api_body_too_large_total")
+			writeBodyTooLarge(w)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeBodyTooLarge(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("Request body exceeds the maximum size of %d MB", config().MaxBodySize))
+}
+
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// 读取完整的请求体，超限时返回 413，读取失败时返回 400
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		return body, true
+	}
+	if isBodyTooLarge(err) {
+		metrics.inc("gptoss2api_body_too_large_total")
+		writeBodyTooLarge(w)
+	} else {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Failed to read request body")
+	}
+	return nil, false
+}
+
+// 把 json.Unmarshal 的错误转成客户端可读的说明：字段类型不对时指出字段路径，
+// 语法错误时给出出错的位置
+func writeJSONError(w http.ResponseWriter, err error) {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		param := jsonFieldParam(typeErr.Field)
+		writeErrorParam(w, http.StatusBadRequest, "invalid_type", fmt.Sprintf("Invalid type for %s: expected %s, got %s", param, jsonTypeName(typeErr.Type), typeErr.Value), param)
+	case errors.As(err, &typeErr):
+		writeError(w, http.StatusBadRequest, "invalid_json", "Request body must be a JSON object")
+	case errors.As(err, &syntaxErr):
+		writeError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid JSON at offset %d: %s", syntaxErr.Offset, syntaxErr.Error()))
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+	}
+}
+
+// messages.0.content -> messages[0].content，与其他校验错误的 param 写法一致
+func jsonFieldParam(field string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a valid value"
+}
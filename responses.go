@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// POST /v1/responses：上游本身就是 Responses API，请求体只替换模型名和输出长度后原样转发，
+// 响应（包括流式事件）也原样返回，同时经过代理的认证、限额和指标统计
+func handleResponses(w http.ResponseWriter, r *http.Request) {
+	rec, reqLog := startRequestLog(w, r)
+	defer reqLog.finish(rec)
+	w = rec
+	if !authorizeClient(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if rejectIfKeyLimited(w, r) {
+		return
+	}
+
+	body, ok := readRequestBody(w, r)
+	if !ok {
+		return
+	}
+	reqLog.Body(tr("user_request"), string(body))
+
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if req["input"] == nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_request", "input is required", "input")
+		return
+	}
+	requested, _ := req["model"].(string)
+	model := resolveModel(r.Context(), requested)
+	req["model"] = model
+	if prompt := systemPromptFor(model); prompt != "" {
+		// 原生 Responses 请求统一使用 instructions 字段注入
+		instructions, _ := req["instructions"].(string)
+		req["instructions"] = joinInstructions(prompt, instructions)
+	}
+	stream, _ := req["stream"].(bool)
+
+	// 复用聊天接口的 max_tokens 默认值和上限
+	var limits OpenAIRequest
+	if n, ok := req["max_output_tokens"].(float64); ok {
+		maxTokens := int(n)
+		limits.MaxTokens = &maxTokens
+	}
+	applyMaxTokensPolicy(r, &limits)
+	if limits.MaxTokens != nil {
+		req["max_output_tokens"] = *limits.MaxTokens
+	}
+
+	user, _ := req["user"].(string)
+	setAccountAffinity(r, user, responsesAffinityMessages(req))
+
+	if rejectIfCircuitOpen(w) {
+		return
+	}
+	if accountBudgetExhausted(r.Context()) {
+		writeError(w, http.StatusTooManyRequests, "account_budget_exhausted", "Daily neuron allowance for the upstream account is exhausted")
+		return
+	}
+	if stream {
+		key := clientIdentity(r)
+		if !streams.acquire(key) {
+			writeError(w, http.StatusTooManyRequests, "too_many_streams", "Too many concurrent streams for this API key")
+			return
+		}
+		defer streams.release(key)
+	}
+
+	reqBody, _ := json.Marshal(req)
+	upstreamStart := time.Now()
+	var resp *http.Response
+	var estimated int
+	err := retryUpstream(r.Context(), func() error {
+		var err error
+		resp, estimated, err = postCloudflareResponses(r.Context(), reqBody, stream)
+		return err
+	})
+	if err != nil {
+		recordModelResult(model, err, time.Since(upstreamStart))
+		recordUsage(r, model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if !stream {
+		raw, err := io.ReadAll(resp.Body)
+		recordModelResult(model, err, time.Since(upstreamStart))
+		if err != nil {
+			recordUsage(r, model, Usage{}, err)
+			writeUpstreamError(w, err)
+			return
+		}
+		reqLog.Body(tr("upstream_raw"), string(raw))
+		var cfResp CloudflareResponse
+		json.Unmarshal(raw, &cfResp)
+		var output strings.Builder
+		for _, item := range cfResp.Output {
+			for _, content := range item.Content {
+				output.WriteString(content.Text)
+			}
+		}
+		usage := fillMissingResponsesUsage(cfResp.Usage, req, output.String())
+		if usage != cfResp.Usage {
+			// 把估算的用量写回响应，客户端看到的 usage 与统计一致
+			var patched map[string]interface{}
+			if json.Unmarshal(raw, &patched) == nil {
+				patched["usage"] = usage
+				raw, _ = json.Marshal(patched)
+			}
+		}
+		recordResponsesUsage(r, model, usage, estimated)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(raw)
+		return
+	}
+
+	defer trackPhase(r.Context(), "streaming", time.Now())
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	var final *CloudflareResponse
+	var content strings.Builder
+	heartbeat := startSSEHeartbeat(w, ssePing)
+	defer heartbeat.stop()
+	readErr := readCloudflareEvents(resp.Body, func(event cloudflareStreamEvent, data string) bool {
+		// response.created 等事件到达后就不再需要心跳；推理阶段的增量事件仍会保持连接
+		heartbeat.stop()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		w.(http.Flusher).Flush()
+		switch event.Type {
+		case "response.output_text.delta", "response.reasoning_text.delta":
+			content.WriteString(event.Delta)
+		case "response.completed", "response.incomplete":
+			final = event.Response
+			if final == nil {
+				final = &CloudflareResponse{}
+			}
+			reqLog.Body(tr("upstream_raw"), data)
+		case "response.failed", "error":
+			err = fmt.Errorf("API stream failed: %s", data)
+		}
+		return final == nil && err == nil && r.Context().Err() == nil
+	})
+	if err == nil {
+		err = readErr
+	}
+	if r.Context().Err() != nil {
+		recordModelResult(model, nil, time.Since(upstreamStart))
+		recordClientDisconnect(r.Context(), r, reqLog, model, nil, content.String())
+		return
+	}
+	recordModelResult(model, err, time.Since(upstreamStart))
+	if err != nil {
+		recordUsage(r, model, Usage{}, err)
+		reqLog.Printf(tr("upstream_raw"), err.Error())
+		return
+	}
+	recordResponsesUsage(r, model, fillMissingResponsesUsage(final.Usage, req, content.String()), estimated)
+}
+
+// Responses 请求的会话指纹：instructions 和 input 的第一项，多轮对话把历史放在 input 中时开头不变
+func responsesAffinityMessages(req map[string]interface{}) []Message {
+	var messages []Message
+	if instructions, ok := req["instructions"].(string); ok && instructions != "" {
+		messages = append(messages, Message{Role: "system", Content: instructions})
+	}
+	input := req["input"]
+	if items, ok := input.([]interface{}); ok && len(items) > 0 {
+		input = items[0]
+	}
+	return append(messages, Message{Role: "user", Content: input})
+}
+
+// 原样转发的请求没有解析成消息，上游缺少用量时按 instructions 和 input 的文本估算
+func fillMissingResponsesUsage(usage CloudflareUsage, req map[string]interface{}, completion string) CloudflareUsage {
+	input, ok := req["input"].(string)
+	if !ok {
+		data, _ := json.Marshal(req["input"])
+		input = string(data)
+	}
+	instructions, _ := req["instructions"].(string)
+	return fillMissingUsage(usage, []Message{{Role: "system", Content: instructions}, {Role: "user", Content: input}}, completion)
+}
+
+func recordResponsesUsage(r *http.Request, model string, cfUsage CloudflareUsage, estimated int) {
+	usage := Usage{
+		PromptTokens:     cfUsage.PromptTokens,
+		CompletionTokens: cfUsage.CompletionTokens,
+		TotalTokens:      cfUsage.TotalTokens,
+	}
+	reportUpstreamTokens(usage.TotalTokens, estimated)
+	recordUsage(r, model, usage, nil)
+	recordNeurons(r.Context(), model, usage)
+}
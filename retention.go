@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// 清理重放记录中早于 cutoff 的条目。存储自身的 TTL 只在写入时生效，
+// 调小 -replay-ttl 后已有记录也会在下次清理时按新策略删除
+func purgeReplayRecords(cutoff time.Time) (int, error) {
+	keys, err := store.Keys("replay:")
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, key := range keys {
+		value, ok, err := store.Get(key)
+		if err != nil || !ok {
+			continue
+		}
+		var record replayRecord
+		if json.Unmarshal([]byte(value), &record) == nil && !record.Created.Before(cutoff) {
+			continue
+		}
+		if store.Delete(key) == nil {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// 重写用量报告文件，只保留周期结束时间不早于 cutoff 的行
+func purgeReportFile(cutoff time.Time) (int, error) {
+	if config().ReportFile == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(config().ReportFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var kept strings.Builder
+	purged := 0
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var summary usageSummary
+		if json.Unmarshal(scanner.Bytes(), &summary) == nil && summary.To.Before(cutoff) {
+			purged++
+			continue
+		}
+		kept.WriteString(scanner.Text() + "\n")
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	tmp := config().ReportFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(kept.String()), 0644); err != nil {
+		return 0, err
+	}
+	return purged, os.Rename(tmp, config().ReportFile)
+}
+
+func purgeUsageRecords(cutoff time.Time) (int, error) {
+	purged, err := rewriteUsageLedger(func(record usageRecord) bool {
+		return !record.Time.Before(cutoff)
+	})
+	if err != nil || !store.Shared() {
+		return purged, err
+	}
+	shared, err := purgeSharedUsageRecords(cutoff)
+	return purged + shared, err
+}
+
+// 键中带有写入时间，不必读取记录内容
+func purgeSharedUsageRecords(cutoff time.Time) (int, error) {
+	keys, err := store.Keys("usage:")
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, key := range keys {
+		var nanos int64
+		if _, err := fmt.Sscanf(key, "usage:%d:", &nanos); err != nil || !time.Unix(0, nanos).Before(cutoff) {
+			continue
+		}
+		if store.Delete(key) == nil {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// 按 -replay-ttl、-report-retention 和 -usage-retention 执行一次清理，未配置的数据保持不动
+func applyRetention() {
+	now := time.Now()
+	var replays, reportLines, usageRecords int
+	var err error
+	if config().ReplayTTL > 0 {
+		if replays, err = purgeReplayRecords(now.Add(-config().ReplayTTL)); err != nil {
+			logf(slog.LevelError, tr("retention_failed"), err)
+		}
+	}
+	if config().ReportRetention > 0 {
+		if reportLines, err = purgeReportFile(now.Add(-config().ReportRetention)); err != nil {
+			logf(slog.LevelError, tr("retention_failed"), err)
+		}
+	}
+	if config().UsageRetention > 0 {
+		if usageRecords, err = purgeUsageRecords(now.Add(-config().UsageRetention)); err != nil {
+			logf(slog.LevelError, tr("retention_failed"), err)
+		}
+	}
+	if replays > 0 || reportLines > 0 || usageRecords > 0 {
+		log.Printf(tr("retention_purged"), replays, reportLines, usageRecords)
+	}
+}
+
+func startRetention() {
+	if config().RetentionInterval <= 0 || (config().ReplayTTL <= 0 && config().ReportRetention <= 0 && config().UsageRetention <= 0) {
+		return
+	}
+	go func() {
+		for range time.Tick(config().RetentionInterval) {
+			applyRetention()
+		}
+	}()
+}
+
+// POST /admin/purge?older_than=24h&target=replay|reports|usage|all：立即清理早于指定时间的数据，
+// older_than=0 清理全部
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		writeUnauthorized(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	olderThan, err := time.ParseDuration(r.URL.Query().Get("older_than"))
+	if err != nil || olderThan < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_older_than", "older_than must be a duration such as 24h or 0")
+		return
+	}
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = "all"
+	}
+	if target != "all" && target != "replay" && target != "reports" && target != "usage" {
+		writeError(w, http.StatusBadRequest, "invalid_target", "target must be replay, reports, usage or all")
+		return
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	result := map[string]interface{}{"cutoff": cutoff}
+	if target == "all" || target == "replay" {
+		n, err := purgeReplayRecords(cutoff)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "store_error", err.Error())
+			return
+		}
+		result["replay_records"] = n
+	}
+	if target == "all" || target == "reports" {
+		n, err := purgeReportFile(cutoff)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "purge_failed", err.Error())
+			return
+		}
+		result["report_lines"] = n
+	}
+	if target == "all" || target == "usage" {
+		n, err := purgeUsageRecords(cutoff)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "purge_failed", err.Error())
+			return
+		}
+		result["usage_records"] = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
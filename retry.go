@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry-After 超过该时长时不再等待，直接把 429 返回给客户端
+const maxRetryAfter = 30 * time.Second
+
+// 解析 Retry-After，支持秒数和 HTTP 日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// 只重试 429、5xx 和网络错误；客户端取消或超时不重试
+func retryableUpstreamError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		return upErr.Status == http.StatusTooManyRequests || upErr.Status >= 500
+	}
+	return true
+}
+
+// 指数退避加全抖动；上游给出 Retry-After 时至少等待该时长
+func retryDelay(attempt int, err error) (time.Duration, bool) {
+	backoff := config().RetryBackoff << uint(attempt)
+	if backoff <= 0 || backoff > 10*time.Second {
+		backoff = 10 * time.Second
+	}
+	delay := time.Duration(rand.Int63n(int64(backoff)) + 1)
+
+	var upErr *upstreamError
+	if errors.As(err, &upErr) && upErr.RetryAfter > 0 {
+		if upErr.RetryAfter > maxRetryAfter {
+			return 0, false
+		}
+		if upErr.RetryAfter > delay {
+			delay = upErr.RetryAfter
+		}
+	}
+	return delay, true
+}
+
+// 按 -max-retries 重试上游调用，call 每次都要重新构造请求；
+// 流式请求只在向客户端写出任何数据之前重试
+func retryUpstream(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= config().MaxRetries || ctx.Err() != nil || !retryableUpstreamError(err) {
+			return err
+		}
+		delay, ok := retryDelay(attempt, err)
+		if !ok {
+			return err
+		}
+		countRetry(ctx)
+		metrics.inc("gptoss2api_upstream_retries_total")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
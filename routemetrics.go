@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 单个请求各阶段（排队、上游、转换、流式输出）的耗时，用于慢请求日志
+type requestPhases struct {
+	start   time.Time
+	mu      sync.Mutex
+	order   []string
+	phases  map[string]time.Duration
+	retries int
+}
+
+type phasesContextKey struct{}
+
+// 记录从 start 到现在的阶段耗时，同名阶段累加；可直接 defer trackPhase(ctx, "queue", time.Now())
+func trackPhase(ctx context.Context, name string, start time.Time) {
+	p, _ := ctx.Value(phasesContextKey{}).(*requestPhases)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.phases[name]; !ok {
+		p.order = append(p.order, name)
+	}
+	p.phases[name] += time.Since(start)
+}
+
+func countRetry(ctx context.Context) {
+	if p, _ := ctx.Value(phasesContextKey{}).(*requestPhases); p != nil {
+		p.mu.Lock()
+		p.retries++
+		p.mu.Unlock()
+	}
+}
+
+func requestRetries(ctx context.Context) int {
+	p, _ := ctx.Value(phasesContextKey{}).(*requestPhases)
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.retries
+}
+
+// 从收到请求到现在的耗时
+func requestElapsed(ctx context.Context) time.Duration {
+	p, _ := ctx.Value(phasesContextKey{}).(*requestPhases)
+	if p == nil {
+		return 0
+	}
+	return time.Since(p.start)
+}
+
+func (p *requestPhases) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parts := make([]string, 0, len(p.order)+1)
+	for _, name := range p.order {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, p.phases[name].Round(time.Millisecond)))
+	}
+	if p.retries > 0 {
+		parts = append(parts, fmt.Sprintf("retries=%d", p.retries))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 按路由和方法统计请求数和耗时，路由取注册的路径模式，避免任意路径造成指标基数膨胀；
+// 超过 -slow-request 的请求记录包含各阶段耗时的警告日志
+func routeMetricsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "other"
+		}
+		phases := &requestPhases{start: start, phases: make(map[string]time.Duration)}
+		r = r.WithContext(context.WithValue(r.Context(), phasesContextKey{}, phases))
+		rec := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				// 处理函数中途中止（例如客户端断开）时没有写出状态码
+				status = 499
+			}
+			elapsed := time.Since(start)
+			metrics.inc("gptoss2api_http_requests_total", "route", route, "method", r.Method, "status", fmt.Sprint(status))
+			metrics.observe("gptoss2api_http_request_duration_seconds", elapsed, "route", route, "method", r.Method)
+			if config().SlowRequest > 0 && elapsed >= config().SlowRequest {
+				logf(slog.LevelWarn, tr("slow_request"), r.Method, route, status, elapsed.Round(time.Millisecond), phases)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+)
+
+// 请求改写规则：按客户端密钥、模型和请求头匹配，改写模型、增删参数或注入系统提示词
+type RuleMatch struct {
+	Key     string            `json:"key,omitempty"`
+	Model   string            `json:"model,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type Rule struct {
+	Match        RuleMatch              `json:"match"`
+	SetModel     string                 `json:"set_model,omitempty"`
+	Set          map[string]interface{} `json:"set,omitempty"`
+	Defaults     map[string]interface{} `json:"defaults,omitempty"`
+	Strip        []string               `json:"strip,omitempty"`
+	SystemPrompt string                 `json:"system_prompt,omitempty"`
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   []Rule
+)
+
+func loadRules() error {
+	var loaded []Rule
+	if config().RulesFile != "" {
+		data, err := os.ReadFile(config().RulesFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return err
+		}
+	}
+	rulesMu.Lock()
+	rules = loaded
+	rulesMu.Unlock()
+	return nil
+}
+
+// model 和请求头的值支持 path.Match 通配符，空条件视为匹配
+func (m RuleMatch) matches(r *http.Request, req map[string]interface{}) bool {
+	if m.Key != "" && m.Key != clientIdentity(r) && m.Key != presentedClientKey(r) {
+		return false
+	}
+	if m.Model != "" {
+		model, _ := req["model"].(string)
+		if ok, _ := path.Match(m.Model, model); !ok {
+			return false
+		}
+	}
+	for name, pattern := range m.Headers {
+		if ok, _ := path.Match(pattern, r.Header.Get(name)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// 按顺序应用所有匹配的规则，返回改写后的请求体；解析失败时原样返回，由后续解析报错
+func applyRules(r *http.Request, body []byte) []byte {
+	rulesMu.RLock()
+	rules := rules
+	rulesMu.RUnlock()
+	if len(rules) == 0 {
+		return body
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return body
+	}
+
+	changed := false
+	for _, rule := range rules {
+		if !rule.Match.matches(r, req) {
+			continue
+		}
+		changed = true
+		if rule.SetModel != "" {
+			req["model"] = rule.SetModel
+		}
+		for _, key := range rule.Strip {
+			delete(req, key)
+		}
+		for key, value := range rule.Defaults {
+			if _, ok := req[key]; !ok {
+				req[key] = value
+			}
+		}
+		for key, value := range rule.Set {
+			req[key] = value
+		}
+		if rule.SystemPrompt != "" {
+			messages, _ := req["messages"].([]interface{})
+			system := map[string]interface{}{"role": "system", "content": rule.SystemPrompt}
+			req["messages"] = append([]interface{}{system}, messages...)
+		}
+	}
+	if !changed {
+		return body
+	}
+
+	rewritten, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// 启动 HTTP 服务，收到 SIGINT/SIGTERM 后停止接受新连接，等待进行中的请求（包括 SSE 流）
+// 在 -shutdown-timeout 内结束后再退出
+func runServer(handler http.Handler, listeners []net.Listener) {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config().ReadTimeout,
+		WriteTimeout:      config().WriteTimeout,
+		IdleTimeout:       config().IdleTimeout,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		log.Printf(tr("shutdown_started"), sig, config().ShutdownTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config().ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			// 超时仍未结束的连接直接关闭
+			logf(slog.LevelWarn, tr("shutdown_forced"), err)
+			srv.Close()
+		}
+		close(done)
+	}()
+
+	if err := serveListeners(srv, listeners); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	log.Print(tr("shutdown_done"))
+}
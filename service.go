@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const serviceName = "gptoss2api"
+
+const systemdUnitTemplate = `[Unit]
+Description=Cloudflare Workers AI OpenAI Compatible API
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+%sWorkingDirectory=%s
+ExecStart=%s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// gptoss2api service install/uninstall/start/stop，install 之后的参数会原样写入服务的启动命令
+func runService(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gptoss2api service install|uninstall|start|stop [proxy flags...]")
+		os.Exit(2)
+	}
+	if runtime.GOOS != "linux" {
+		fmt.Fprintf(os.Stderr, "service management is only supported with systemd on linux (current: %s)\n", runtime.GOOS)
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installSystemdService(args[1:])
+	case "uninstall":
+		err = uninstallSystemdService()
+	case "start", "stop", "restart", "status":
+		err = systemctl(args[0], serviceName)
+	default:
+		err = fmt.Errorf("unknown service action: %s", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func systemdUnitPath() string {
+	return filepath.Join("/etc/systemd/system", serviceName+".service")
+}
+
+func installSystemdService(proxyArgs []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, _ = filepath.EvalSymlinks(exe)
+
+	command := []string{quoteSystemdArg(exe)}
+	for _, arg := range proxyArgs {
+		command = append(command, quoteSystemdArg(arg))
+	}
+	// 启动参数中的相对路径（-config、-token-file 等）相对于执行 install 时的目录解析，
+	// 服务以 sudo 之前的用户身份运行，与直接在命令行启动时的权限一致
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	userLine := ""
+	if name := serviceUser(); name != "" {
+		userLine = "User=" + name + "\n"
+	}
+	unit := fmt.Sprintf(systemdUnitTemplate, userLine, strings.ReplaceAll(cwd, "%", "%%"), strings.Join(command, " "))
+
+	// 启动参数中可能包含令牌，限制单元文件权限
+	if err := os.WriteFile(systemdUnitPath(), []byte(unit), 0600); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl("enable", serviceName); err != nil {
+		return err
+	}
+	fmt.Printf("installed %s, run `gptoss2api service start` to start it\n", systemdUnitPath())
+	return nil
+}
+
+// sudo 执行时取 SUDO_USER，否则取当前用户；root 不写 User=
+func serviceUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" && name != "root" {
+		return name
+	}
+	if u, err := user.Current(); err == nil && u.Uid != "0" {
+		return u.Username
+	}
+	return ""
+}
+
+func uninstallSystemdService() error {
+	// 服务可能未运行，停止失败不影响卸载
+	systemctl("disable", "--now", serviceName)
+	if err := os.Remove(systemdUnitPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// systemd 的 ExecStart 中 % 是占位符前缀，含空白或引号的参数需要加引号
+func quoteSystemdArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return strconv.Quote(arg)
+}
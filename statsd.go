@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+)
+
+// StatsD/DogStatsD 导出器，与 /metrics 共用同一套指标
+type statsdSink struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+var statsd = &statsdSink{}
+
+func startStatsd() {
+	if config().StatsdAddr == "" {
+		return
+	}
+	conn, err := net.Dial("udp", config().StatsdAddr)
+	if err != nil {
+		logf(slog.LevelWarn, tr("statsd_failed"), err)
+		return
+	}
+	statsd.mu.Lock()
+	statsd.conn = conn
+	statsd.mu.Unlock()
+	log.Printf(tr("statsd_started"), config().StatsdAddr)
+}
+
+// metricType 为 c（计数）、g（仪表）或 ms（耗时）
+func (s *statsdSink) send(name string, value float64, metricType string, labels []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+
+	metric := config().StatsdPrefix + strings.TrimPrefix(name, "gptoss2api_")
+	var tags []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		if config().StatsdDogstatsd {
+			tags = append(tags, labels[i]+":"+labels[i+1])
+		} else {
+			// 原生 StatsD 不支持标签，拼接到指标名中
+			metric += "." + labels[i+1]
+		}
+	}
+
+	line := fmt.Sprintf("%s:%g|%s", metric, value, metricType)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	// UDP 发送失败不影响请求处理
+	s.conn.Write([]byte(line))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 与 OpenAI 一致，最多 4 个停止序列
+const maxStopSequences = 4
+
+// stop 参数可以是单个字符串，也可以是字符串数组
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*s = nil
+		if single != "" {
+			*s = StopSequences{single}
+		}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = nil
+	for _, item := range list {
+		if item != "" {
+			*s = append(*s, item)
+		}
+	}
+	return nil
+}
+
+func validateStopSequences(stops StopSequences) error {
+	if len(stops) > maxStopSequences {
+		return fmt.Errorf("stop accepts at most %d sequences", maxStopSequences)
+	}
+	return nil
+}
+
+// Cloudflare Responses API 没有 stop 参数，由代理在最早出现的停止序列处截断，同时返回命中的停止序列
+func truncateAtStop(text string, stops []string) (string, string) {
+	cut, matched := -1, ""
+	for _, stop := range stops {
+		if i := strings.Index(text, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut, matched = i, stop
+		}
+	}
+	if cut < 0 {
+		return text, ""
+	}
+	return text[:cut], matched
+}
+
+// 流式输出时停止序列可能跨越多个增量，末尾可能是某个停止序列前缀的部分先扣住，确认不匹配后再发出
+type stopMatcher struct {
+	stops   []string
+	pending string
+	stopped bool
+	matched string
+}
+
+// 返回可以立即发出的文本；遇到停止序列时丢弃其后的内容并标记 stopped
+func (m *stopMatcher) feed(text string) string {
+	if m.stopped {
+		return ""
+	}
+	m.pending += text
+	if out, matched := truncateAtStop(m.pending, m.stops); matched != "" {
+		m.pending = ""
+		m.stopped = true
+		m.matched = matched
+		return out
+	}
+	keep := 0
+	for _, stop := range m.stops {
+		for k := min(len(stop)-1, len(m.pending)); k > keep; k-- {
+			if strings.HasSuffix(m.pending, stop[:k]) {
+				keep = k
+				break
+			}
+		}
+	}
+	out := m.pending[:len(m.pending)-keep]
+	m.pending = m.pending[len(m.pending)-keep:]
+	return out
+}
+
+// 上游结束后发出扣住的剩余文本
+func (m *stopMatcher) flush() string {
+	out := m.pending
+	m.pending = ""
+	return out
+}
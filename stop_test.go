@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTruncateAtStop(t *testing.T) {
+	tests := []struct {
+		text        string
+		stops       []string
+		want, match string
+	}{
+		{"hello world", nil, "hello world", ""},
+		{"hello world", []string{"xyz"}, "hello world", ""},
+		{"hello world", []string{"world", "lo"}, "hel", "lo"},
+		{"a\n\nb", []string{"\n\n"}, "a", "\n\n"},
+	}
+	for _, tt := range tests {
+		got, match := truncateAtStop(tt.text, tt.stops)
+		if got != tt.want || match != tt.match {
+			t.Errorf("truncateAtStop(%q, %q) = %q, %q; want %q, %q", tt.text, tt.stops, got, match, tt.want, tt.match)
+		}
+	}
+}
+
+func TestStopMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		stops   []string
+		chunks  []string
+		want    []string
+		matched string
+		flushed string
+	}{
+		{
+			name:   "no stop sequences",
+			chunks: []string{"a", "b"},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:    "stop inside one chunk",
+			stops:   []string{"END"},
+			chunks:  []string{"one END two", "three"},
+			want:    []string{"one ", ""},
+			matched: "END",
+		},
+		{
+			name:    "stop split across chunks is held back",
+			stops:   []string{"END"},
+			chunks:  []string{"one E", "N", "D two"},
+			want:    []string{"one ", "", ""},
+			matched: "END",
+		},
+		{
+			name:    "prefix that turns out not to be a stop",
+			stops:   []string{"END"},
+			chunks:  []string{"one E", "Nx", "tail E"},
+			want:    []string{"one ", "ENx", "tail "},
+			flushed: "E",
+		},
+		{
+			name:    "earliest of several stops",
+			stops:   []string{"bar", "foo"},
+			chunks:  []string{"xx fo", "o bar"},
+			want:    []string{"xx ", ""},
+			matched: "foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &stopMatcher{stops: tt.stops}
+			var got []string
+			for _, chunk := range tt.chunks {
+				got = append(got, m.feed(chunk))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("feed outputs %q, want %q", got, tt.want)
+			}
+			if m.matched != tt.matched || m.stopped != (tt.matched != "") {
+				t.Errorf("matched %q (stopped %v), want %q", m.matched, m.stopped, tt.matched)
+			}
+			if flushed := m.flush(); flushed != tt.flushed {
+				t.Errorf("flush %q, want %q", flushed, tt.flushed)
+			}
+		})
+	}
+}
+
+func TestConvertToOpenAIResponseStop(t *testing.T) {
+	var resp CloudflareResponse
+	json.Unmarshal([]byte(`{"id":"resp_1","created_at":1,"model":"m","status":"incomplete",
+		"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"one two END three"}]}],
+		"usage":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}`), &resp)
+	got := convertToOpenAIResponse(&resp, OpenAIRequest{ReasoningMode: reasoningStrip, Stop: StopSequences{"END"}})
+	assertJSON(t, got.Choices[0], `{"index":0,"message":{"role":"assistant","content":"one two "},"finish_reason":"stop"}`)
+}
+
+func TestStopSequencesJSON(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: `"END"`, want: []string{"END"}},
+		{raw: `""`},
+		{raw: `["a","","b"]`, want: []string{"a", "b"}},
+		{raw: `5`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got StopSequences
+		err := json.Unmarshal([]byte(tt.raw), &got)
+		if (err != nil) != tt.wantErr || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("unmarshal %s = %q, %v", tt.raw, got, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 有状态子系统共用的存储接口，-store 选择内存或 Redis 实现；
+// 内存实现只在单实例内有效，Redis 实现可在多副本间共享
+type Store interface {
+	Get(key string) (string, bool, error)
+	Set(key, value string, ttl time.Duration) error
+	Delete(key string) error
+	// Incr 原子地增加数值并刷新过期时间，返回增加后的值
+	Incr(key string, delta float64, ttl time.Duration) (float64, error)
+	// Keys 列出以 prefix 开头的键（不含 Redis 键前缀）
+	Keys(prefix string) ([]string, error)
+	Shared() bool
+}
+
+var store Store = newMemoryStore()
+
+func initStore() error {
+	backend := config().Store
+	if backend == "" && config().RedisAddr != "" {
+		backend = "redis"
+	}
+	switch backend {
+	case "", "memory":
+		return nil
+	case "redis":
+		if config().RedisAddr == "" {
+			return fmt.Errorf("-store=redis requires -redis-addr")
+		}
+		initRedis()
+		store = &redisStore{client: redisConn}
+		return nil
+	default:
+		return fmt.Errorf("unsupported store backend: %s (memory or redis)", backend)
+	}
+}
+
+type memoryItem struct {
+	value   string
+	expires time.Time
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{items: make(map[string]memoryItem)}
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.sweep()
+		}
+	}()
+	return s
+}
+
+func (s *memoryStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, item := range s.items {
+		if !item.expires.IsZero() && now.After(item.expires) {
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *memoryStore) getLocked(key string) (memoryItem, bool) {
+	item, ok := s.items[key]
+	if ok && !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(s.items, key)
+		return memoryItem{}, false
+	}
+	return item, ok
+}
+
+func (s *memoryStore) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.getLocked(key)
+	return item.value, ok, nil
+}
+
+func (s *memoryStore) Set(key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := memoryItem{value: value}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	s.items[key] = item
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return nil
+}
+
+func (s *memoryStore) Incr(key string, delta float64, ttl time.Duration) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, _ := s.getLocked(key)
+	current, _ := strconv.ParseFloat(item.value, 64)
+	current += delta
+	item.value = strconv.FormatFloat(current, 'f', -1, 64)
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	s.items[key] = item
+	return current, nil
+}
+
+func (s *memoryStore) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.items {
+		if _, ok := s.getLocked(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) Shared() bool { return false }
+
+type redisStore struct {
+	client *redisClient
+}
+
+func (s *redisStore) Get(key string) (string, bool, error) {
+	reply, err := s.client.do("GET", config().RedisPrefix+key)
+	if err != nil || reply == nil {
+		return "", false, err
+	}
+	value, _ := reply.(string)
+	return value, true, nil
+}
+
+func (s *redisStore) Set(key, value string, ttl time.Duration) error {
+	args := []string{"SET", config().RedisPrefix + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := s.client.do(args...)
+	return err
+}
+
+func (s *redisStore) Delete(key string) error {
+	_, err := s.client.do("DEL", config().RedisPrefix+key)
+	return err
+}
+
+func (s *redisStore) Incr(key string, delta float64, ttl time.Duration) (float64, error) {
+	reply, err := s.client.do("INCRBYFLOAT", config().RedisPrefix+key, strconv.FormatFloat(delta, 'f', -1, 64))
+	if err != nil {
+		return 0, err
+	}
+	if ttl > 0 {
+		if _, err := s.client.do("PEXPIRE", config().RedisPrefix+key, strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+			return 0, err
+		}
+	}
+	value, _ := reply.(string)
+	return strconv.ParseFloat(value, 64)
+}
+
+// 使用 SCAN 分批遍历，避免 KEYS 阻塞 Redis
+func (s *redisStore) Keys(prefix string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.client.do("SCAN", cursor, "MATCH", config().RedisPrefix+prefix+"*", "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		parts, _ := reply.([]interface{})
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply")
+		}
+		cursor, _ = parts[0].(string)
+		batch, _ := parts[1].([]interface{})
+		for _, item := range batch {
+			if key, ok := item.(string); ok {
+				keys = append(keys, strings.TrimPrefix(key, config().RedisPrefix))
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+func (s *redisStore) Shared() bool { return true }
+
+// 固定窗口计数：在当前窗口内增加 n，返回增加后的总数
+func incrWindow(name string, n int, window time.Duration) (int64, error) {
+	bucket := time.Now().UnixNano() / int64(window)
+	count, err := store.Incr(fmt.Sprintf("%s:%d", name, bucket), float64(n), window*2)
+	return int64(count), err
+}
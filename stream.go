@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cloudflare Responses API 流式事件，只解析转换需要的字段
+type cloudflareStreamEvent struct {
+	Type      string                `json:"type"`
+	Delta     string                `json:"delta"`
+	Response  *CloudflareResponse   `json:"response"`
+	Item      *CloudflareOutputItem `json:"item"`
+	ItemID    string                `json:"item_id"`
+	Arguments string                `json:"arguments"`
+}
+
+// 以 stream: true 调用 Cloudflare Responses API，返回的响应体由调用方关闭；
+// 同时返回请求体的 token 估算值，供拿到真实用量后修正限流计数。
+// 拿到上游响应头之前还没有向客户端写出任何数据，可以安全重试
+func openCloudflareStream(req CloudflareRequest, ctx context.Context) (*http.Response, int, error) {
+	var resp *http.Response
+	var estimated int
+	err := retryUpstream(ctx, func() error {
+		var err error
+		resp, estimated, err = openCloudflareStreamOnce(req, ctx)
+		return err
+	})
+	return resp, estimated, err
+}
+
+func openCloudflareStreamOnce(req CloudflareRequest, ctx context.Context) (*http.Response, int, error) {
+	req.Stream = true
+	reqBody, _ := json.Marshal(req)
+	return postCloudflareResponses(ctx, reqBody, true)
+}
+
+// 向 Cloudflare Responses API 发送已编码的请求体，状态码为 200 时由调用方读取并关闭响应体
+func postCloudflareResponses(ctx context.Context, reqBody []byte, stream bool) (*http.Response, int, error) {
+	url := workersAIURL(ctx, "v1/responses")
+
+	httpReq, _ := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(reqBody)))
+	httpReq.Header.Set("Authorization", "Bearer "+upstreamAuthToken(ctx))
+	httpReq.Header.Set("Content-Type", "application/json")
+	setGatewayHeaders(ctx, httpReq)
+	if stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	if chaosEnabled() {
+		if status, err := chaosUpstreamFault(ctx); err != nil {
+			recordUpstreamResult(status, err, 0)
+			return nil, 0, err
+		}
+	}
+	estimated := estimateTokens(reqBody)
+	if err := waitUpstreamSlot(ctx, estimated); err != nil {
+		return nil, 0, err
+	}
+
+	client := upstreamHTTPClient(stream)
+	traceUpstreamRequest(ctx, httpReq)
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		recordUpstreamResult(0, err, time.Since(start))
+		return nil, 0, err
+	}
+	recordUpstreamResult(resp.StatusCode, nil, time.Since(start))
+	recordAccountResult(ctx, resp.StatusCode)
+	traceUpstreamResponse(ctx, resp)
+	trackPhase(ctx, "upstream", start)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, 0, newUpstreamError(resp, body)
+	}
+	return resp, estimated, nil
+}
+
+// 逐个读取上游 SSE 事件，handle 返回 false 时停止读取；上游在完成之前结束时返回错误
+func readCloudflareEvents(body io.Reader, handle func(event cloudflareStreamEvent, data string) bool) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if err == io.EOF {
+				return fmt.Errorf("upstream stream ended before completion")
+			}
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return fmt.Errorf("upstream stream ended before completion")
+		}
+
+		var event cloudflareStreamEvent
+		if json.Unmarshal([]byte(data), &event) != nil {
+			continue
+		}
+		if !handle(event, data) {
+			return nil
+		}
+	}
+}
+
+// 客户端中途断开时请求上下文被取消，上游连接随之关闭，Cloudflare 不再继续生成；
+// 已生成的部分仍会计费，按已转发的内容估算用量
+func recordClientDisconnect(ctx context.Context, r *http.Request, reqLog *requestLog, model string, messages []Message, completion string) {
+	metrics.inc("gptoss2api_client_disconnects_total", "model", model)
+	reqLog.Printf(tr("client_disconnected"), len(completion))
+	estimated := estimateUsage(messages, completion)
+	usage := Usage{
+		PromptTokens:     estimated.PromptTokens,
+		CompletionTokens: estimated.CompletionTokens,
+		TotalTokens:      estimated.TotalTokens,
+	}
+	recordUsage(r, model, usage, ctx.Err())
+	recordNeurons(ctx, model, usage)
+}
+
+func wantsStreamUsage(openaiReq OpenAIRequest) bool {
+	return openaiReq.StreamOptions != nil && openaiReq.StreamOptions.IncludeUsage
+}
+
+// 按 OpenAI chat.completion.chunk 格式逐块写出 SSE
+type chunkWriter struct {
+	w       http.ResponseWriter
+	id      string
+	model   string
+	created int64
+	started bool
+	// n > 1 时每个选项的第一个数据块各自携带角色
+	roleSent map[int]bool
+	// stream_options.include_usage：每个数据块带 "usage": null，结束后单独发送用量块
+	includeUsage bool
+	heartbeat    *sseHeartbeat
+}
+
+func (c *chunkWriter) write(event interface{}) {
+	c.heartbeat.stop()
+	c.w.Write([]byte("data: "))
+	enc := json.NewEncoder(c.w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(event)
+	c.w.Write([]byte("\n"))
+	c.w.(http.Flusher).Flush()
+}
+
+func (c *chunkWriter) send(delta map[string]interface{}, finishReason interface{}, extra map[string]interface{}) {
+	c.sendChoice(0, delta, finishReason, extra)
+}
+
+func (c *chunkWriter) sendChoice(index int, delta map[string]interface{}, finishReason interface{}, extra map[string]interface{}) {
+	if !c.roleSent[index] {
+		// 第一个数据块只携带角色，与 OpenAI 保持一致
+		if c.roleSent == nil {
+			c.roleSent = make(map[int]bool)
+		}
+		c.roleSent[index] = true
+		c.started = true
+		c.sendChoice(index, map[string]interface{}{"role": "assistant"}, nil, nil)
+	}
+	event := map[string]interface{}{
+		"id":      c.id,
+		"object":  "chat.completion.chunk",
+		"created": c.created,
+		"model":   c.model,
+		"choices": []map[string]interface{}{
+			{
+				"delta":         delta,
+				"index":         index,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	if c.includeUsage {
+		event["usage"] = nil
+	}
+	for k, v := range extra {
+		event[k] = v
+	}
+	c.write(event)
+}
+
+// OpenAI SDK 期望的用量块：choices 为空数组，位于 [DONE] 之前
+func (c *chunkWriter) sendUsage(usage Usage) {
+	c.write(map[string]interface{}{
+		"id":      c.id,
+		"object":  "chat.completion.chunk",
+		"created": c.created,
+		"model":   c.model,
+		"choices": []interface{}{},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     usage.PromptTokens,
+			"completion_tokens": usage.CompletionTokens,
+			"total_tokens":      usage.TotalTokens,
+		},
+	})
+}
+
+// 真正的流式转发：上游每产生一段文本就转换为 chat.completion.chunk 发给客户端，
+// 转换由 relayChoiceStream 完成，与 n > 1 的流式聊天相同
+func streamChatCompletion(w http.ResponseWriter, r *http.Request, openaiReq OpenAIRequest, cfReq CloudflareRequest, body []byte, requestStart time.Time, reqLog *requestLog) {
+	ctx := r.Context()
+	upstreamStart := time.Now()
+	var resp *http.Response
+	err := primaryAvailable()
+	if err == nil {
+		resp, err = defaultProvider.Stream(ctx, openaiReq, cfReq.Model)
+		if err != nil {
+			recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+		}
+	}
+	backend := backendPrimary
+	if shouldFailover(ctx, err) {
+		// 还没有向客户端输出任何内容，可以整体改用备用上游
+		reqLog.Printf(tr("failover"), err)
+		backend = backendFallback
+		provider, model := fallbackProvider(), fallbackModel(openaiReq, cfReq)
+		resp, err = provider.Stream(ctx, openaiReq, model)
+		if _, ok := provider.(*cloudflareProvider); ok {
+			// Cloudflare 备用上游同样返回 Responses API 事件，沿用下面的转换流程
+			cfReq.Model = model
+		} else if err == nil {
+			w.Header().Set("X-Upstream-Backend", backend)
+			usage := proxyFallbackStream(w, resp, wantsStreamUsage(openaiReq))
+			recordUsage(r, cfReq.Model, usage, nil)
+			return
+		}
+	}
+	w.Header().Set("X-Upstream-Backend", backend)
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+	defer trackPhase(ctx, "streaming", time.Now())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	out := &chunkWriter{
+		w:         w,
+		id:        fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		model:     cfReq.Model,
+		created:   time.Now().Unix(),
+		heartbeat: startSSEHeartbeat(w, ssePing),
+	}
+	defer out.heartbeat.stop()
+	out.includeUsage = wantsStreamUsage(openaiReq)
+	var ttft time.Duration
+	chunks := 0
+	// 总长度未知，只在前 64 个数据块中随机选择断开位置
+	dropAt := chaosStreamDropPoint(64)
+	send := func(index int, delta map[string]interface{}, finishReason interface{}, extra map[string]interface{}) {
+		if finishReason == nil {
+			if chunks == dropAt {
+				// 故障注入：模拟流中途断开
+				panic(http.ErrAbortHandler)
+			}
+			if chunks == 0 {
+				ttft = time.Since(requestStart)
+			}
+			chunks++
+		}
+		out.sendChoice(index, delta, finishReason, extra)
+	}
+	hooks := &relayHooks{
+		onEvent: func(event cloudflareStreamEvent, data string) {
+			switch event.Type {
+			case "response.created":
+				if event.Response != nil && event.Response.ID != "" {
+					out.id = event.Response.ID
+				}
+				if event.Response != nil && event.Response.Model != "" {
+					out.model = event.Response.Model
+				}
+			case "response.completed", "response.incomplete":
+				reqLog.Body(tr("upstream_raw"), data)
+			}
+		},
+	}
+	if config().Timings {
+		hooks.finishExtra = func(usage Usage) map[string]interface{} {
+			timings := newTimings(r, time.Since(upstreamStart), usage.CompletionTokens)
+			timings.TTFTMs = ttft.Milliseconds()
+			return map[string]interface{}{"x_timings": timings}
+		}
+	}
+
+	usage, content, err := relayChoiceStream(ctx, resp, 0, openaiReq, send, hooks)
+	if ctx.Err() != nil {
+		// 客户端已断开，不再写入
+		recordModelResult(cfReq.Model, nil, time.Since(upstreamStart))
+		recordClientDisconnect(ctx, r, reqLog, cfReq.Model, openaiReq.Messages, content)
+		return
+	}
+	recordModelResult(cfReq.Model, err, time.Since(upstreamStart))
+
+	if err != nil {
+		recordUsage(r, cfReq.Model, Usage{}, err)
+		reqLog.Printf(tr("upstream_raw"), err.Error())
+		if !out.started && !out.heartbeat.wrote() {
+			writeUpstreamError(w, err)
+		} else {
+			// 响应头已发出，只能以 SSE 数据块的形式通知客户端
+			status, code, message := upstreamErrorStatus(err)
+			out.write(errorEnvelope(status, code, message, ""))
+		}
+		return
+	}
+
+	reportStreamTokens(resp, usage.TotalTokens)
+	saveReplayRecord(out.id, body, cfReq.Model, content)
+	recordUsage(r, cfReq.Model, usage, nil)
+	if backend == backendPrimary {
+		recordNeurons(ctx, cfReq.Model, usage)
+	}
+	// 结束块已经发出，客户端要求时再单独发送用量块
+	if out.includeUsage {
+		out.sendUsage(usage)
+	}
+
+	// 发送 [DONE] 标记
+	w.Write([]byte("data: [DONE]\n\n"))
+	w.(http.Flusher).Flush()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCloudflareEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "stops after the completed event",
+			body: "event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\"}}\n\n" +
+				"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"hi\"}\n\n" +
+				"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"status\":\"completed\"}}\n\n" +
+				"data: {\"type\":\"ignored\"}\n\n",
+			want: []string{"response.created", "response.output_text.delta:hi", "response.completed"},
+		},
+		{
+			name: "comments, CRLF and malformed data are skipped",
+			body: ": keep-alive\r\n\r\ndata:{\"type\":\"response.output_text.delta\",\"delta\":\"a\"}\r\n\r\ndata: not json\r\n\r\n" +
+				"data: {\"type\":\"response.completed\",\"response\":{}}\r\n\r\n",
+			want: []string{"response.output_text.delta:a", "response.completed"},
+		},
+		{
+			name:    "stream ends before completion",
+			body:    "data: {\"type\":\"response.output_text.delta\",\"delta\":\"a\"}\n\n",
+			want:    []string{"response.output_text.delta:a"},
+			wantErr: "upstream stream ended before completion",
+		},
+		{
+			name:    "[DONE] before completion",
+			body:    "data: {\"type\":\"response.output_text.delta\",\"delta\":\"a\"}\n\ndata: [DONE]\n\n",
+			want:    []string{"response.output_text.delta:a"},
+			wantErr: "upstream stream ended before completion",
+		},
+		{
+			name: "last line without trailing newline",
+			body: "data: {\"type\":\"response.completed\",\"response\":{}}",
+			want: []string{"response.completed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := readCloudflareEvents(strings.NewReader(tt.body), func(event cloudflareStreamEvent, data string) bool {
+				if event.Delta != "" {
+					got = append(got, event.Type+":"+event.Delta)
+				} else {
+					got = append(got, event.Type)
+				}
+				return event.Type != "response.completed"
+			})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// 按客户端密钥统计同时打开的流式响应数量
+type streamLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+var streams = &streamLimiter{active: make(map[string]int)}
+
+func (l *streamLimiter) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if config().MaxStreamsPerKey > 0 && l.active[key] >= config().MaxStreamsPerKey {
+		return false
+	}
+	l.active[key]++
+	metrics.set("gptoss2api_active_streams", float64(l.total()))
+	return true
+}
+
+func (l *streamLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[key]--
+	if l.active[key] <= 0 {
+		delete(l.active, key)
+	}
+	metrics.set("gptoss2api_active_streams", float64(l.total()))
+}
+
+func (l *streamLimiter) total() int {
+	n := 0
+	for _, c := range l.active {
+		n += c
+	}
+	return n
+}
+
+// 客户端身份取请求中携带的密钥对应的 ID，未配置密钥时所有请求共享同一身份。
+// Basic 认证的用户名由客户端随意填写，不作为身份，否则换个用户名就能绕过按身份的限额和统计
+func clientIdentity(r *http.Request) string {
+	if id := requestKeyID(r); id != "" {
+		return id
+	}
+	return presentedClientKey(r)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+)
+
+const (
+	systemPromptPrepend      = "prepend"
+	systemPromptInstructions = "instructions"
+)
+
+// 服务端统一注入的系统提示词：-system-prompt 对所有模型生效，-system-prompts 文件按模型覆盖
+// （键为上游模型名，支持 path.Match 通配符，值为空字符串表示该模型不注入）
+var systemPrompts struct {
+	mu       sync.RWMutex
+	byModel  map[string]string
+	patterns []string
+}
+
+func loadSystemPrompts() error {
+	if config().SystemPromptMode != systemPromptPrepend && config().SystemPromptMode != systemPromptInstructions {
+		return fmt.Errorf("invalid -system-prompt-mode %q, expected %s or %s", config().SystemPromptMode, systemPromptPrepend, systemPromptInstructions)
+	}
+	byModel := map[string]string{}
+	if config().SystemPromptsFile != "" {
+		data, err := os.ReadFile(config().SystemPromptsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &byModel); err != nil {
+			return fmt.Errorf("invalid -system-prompts file: %v", err)
+		}
+	}
+	var patterns []string
+	for pattern := range byModel {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid -system-prompts pattern %q", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	systemPrompts.mu.Lock()
+	systemPrompts.byModel = byModel
+	systemPrompts.patterns = patterns
+	systemPrompts.mu.Unlock()
+	return nil
+}
+
+// 精确匹配优先，其次是通配符，都没有时使用 -system-prompt
+func systemPromptFor(model string) string {
+	systemPrompts.mu.RLock()
+	defer systemPrompts.mu.RUnlock()
+	if prompt, ok := systemPrompts.byModel[model]; ok {
+		return prompt
+	}
+	for _, pattern := range systemPrompts.patterns {
+		if ok, _ := path.Match(pattern, model); ok {
+			return systemPrompts.byModel[pattern]
+		}
+	}
+	return config().SystemPrompt
+}
+
+// 默认作为第一条 system 消息放在客户端消息之前；instructions 模式下放入 Responses API 的 instructions 字段，
+// 客户端自己的 system 消息仍保留在 input 中
+func applySystemPrompt(cfReq *CloudflareRequest) {
+	prompt := systemPromptFor(cfReq.Model)
+	if prompt == "" {
+		return
+	}
+	if config().SystemPromptMode == systemPromptInstructions {
+		cfReq.Instructions = joinInstructions(prompt, cfReq.Instructions)
+		return
+	}
+	input, _ := cfReq.Input.([]map[string]interface{})
+	system := map[string]interface{}{"role": "system", "content": prompt}
+	cfReq.Input = append([]map[string]interface{}{system}, input...)
+}
+
+func joinInstructions(prompt, instructions string) string {
+	if instructions == "" {
+		return prompt
+	}
+	return prompt + "\n\n" + instructions
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestApplySystemPrompt(t *testing.T) {
+	req := OpenAIRequest{Messages: []Message{{Role: "user", Content: "hi"}}}
+
+	withConfig(t, func(c *Config) { c.SystemPrompt = "You are helpful." })
+	assertJSON(t, convertToCloudflareRequest(req, "m"),
+		`{"model":"m","input":[{"role":"system","content":"You are helpful."},{"role":"user","content":"hi"}]}`)
+
+	withConfig(t, func(c *Config) { c.SystemPromptMode = systemPromptInstructions })
+	assertJSON(t, convertToCloudflareRequest(req, "m"),
+		`{"model":"m","instructions":"You are helpful.","input":[{"role":"user","content":"hi"}]}`)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 按 Host 区分的租户配置，未填写的字段沿用全局配置
+type Tenant struct {
+	AccountID        string  `json:"account_id"`
+	AuthToken        string  `json:"token"`
+	Model            string  `json:"model"`
+	ClientKey        string  `json:"client_key"`
+	NeuronDailyLimit float64 `json:"neuron_daily_limit"`
+	DefaultMaxTokens int     `json:"default_max_tokens"`
+	MaxTokensCap     int     `json:"max_tokens_cap"`
+}
+
+type tenantContextKey struct{}
+
+var (
+	tenantsMu sync.RWMutex
+	tenants   map[string]Tenant
+)
+
+func loadTenants() error {
+	loaded := map[string]Tenant{}
+	if config().TenantsFile != "" {
+		data, err := os.ReadFile(config().TenantsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return err
+		}
+	}
+	byHost := make(map[string]Tenant, len(loaded))
+	for host, tenant := range loaded {
+		byHost[strings.ToLower(host)] = tenant
+	}
+	tenantsMu.Lock()
+	tenants = byHost
+	tenantsMu.Unlock()
+	return nil
+}
+
+func currentTenants() map[string]Tenant {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+	return tenants
+}
+
+// 根据 Host 头把租户配置放入请求上下文，未匹配的域名使用全局配置
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenants := currentTenants()
+		if len(tenants) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tenant, ok := tenants[host]; ok {
+			r = r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, &tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestTenant(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant
+}
+
+func upstreamAccountID(ctx context.Context) string {
+	if a := accountOverride(ctx); a != nil {
+		return a.AccountID
+	}
+	if t := requestTenant(ctx); t != nil && t.AccountID != "" {
+		return t.AccountID
+	}
+	if a := pooledAccount(ctx); a != nil {
+		return a.AccountID
+	}
+	return config().AccountID
+}
+
+func upstreamAuthToken(ctx context.Context) string {
+	if a := accountOverride(ctx); a != nil {
+		return a.AuthToken
+	}
+	if t := requestTenant(ctx); t != nil && t.AuthToken != "" {
+		return t.AuthToken
+	}
+	if a := pooledAccount(ctx); a != nil && a.AuthToken != "" {
+		return a.AuthToken
+	}
+	return currentAuthToken()
+}
+
+func upstreamModel(ctx context.Context) string {
+	if t := requestTenant(ctx); t != nil && t.Model != "" {
+		return t.Model
+	}
+	return config().Model
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// 直接以 HTTPS 对外提供服务时使用的证书。证书文件按 -credential-poll 间隔检查，
+// certbot/lego 等工具续期后自动换用新证书，无需重启
+type certReloader struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+var serverCert *certReloader
+
+func initTLS() error {
+	if config().TLSCert == "" && config().TLSKey == "" {
+		return nil
+	}
+	if config().TLSCert == "" || config().TLSKey == "" {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	serverCert = &certReloader{}
+	if err := serverCert.load(); err != nil {
+		return err
+	}
+	go serverCert.watch()
+	return nil
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(config().TLSCert, config().TLSKey)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) watch() {
+	modTimes := map[string]time.Time{}
+	changed := func() bool {
+		result := false
+		for _, path := range []string{config().TLSCert, config().TLSKey} {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if !info.ModTime().Equal(modTimes[path]) {
+				result = result || !modTimes[path].IsZero()
+				modTimes[path] = info.ModTime()
+			}
+		}
+		return result
+	}
+	changed()
+	for {
+		time.Sleep(config().CredentialPoll)
+		if !changed() {
+			continue
+		}
+		// 证书和私钥可能不是同时写入的，不匹配时保留旧证书，下次变化时再试
+		if err := c.load(); err != nil {
+			logf(slog.LevelWarn, tr("tls_reload_failed"), err)
+			continue
+		}
+		log.Printf(tr("tls_reloaded"), config().TLSCert)
+	}
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
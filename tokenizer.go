@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 可选的本地 BPE 分词器：-tokenizer-file 指定 tiktoken 格式的词表（如 o200k_base.tiktoken，
+// 每行为 base64 编码的 token 和它的 rank），用于在上游没有返回用量时计算 token 数。
+// 未设置时使用 estimateTextTokens 中按字符数的粗略估算
+type bpeTokenizer struct {
+	ranks map[string]int
+}
+
+var tokenizer *bpeTokenizer
+
+// o200k_base 的预分词规则。RE2 不支持 (?!\S)，末尾的 \s+(?!\S) 由 splitPretokens 单独处理
+var o200kPattern = regexp.MustCompile(`^(?:` +
+	`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+	`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+	`|\p{N}{1,3}` +
+	`| ?[^\s\p{L}\p{N}]+[\r\n/]*` +
+	`|\s*[\r\n]+` +
+	`|\s+)`)
+
+func loadTokenizer() error {
+	if config().TokenizerFile == "" {
+		tokenizer = nil
+		return nil
+	}
+	file, err := os.Open(config().TokenizerFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(text, " ")
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		n, err2 := strconv.Atoi(rank)
+		if !ok || err != nil || err2 != nil {
+			return fmt.Errorf("invalid -tokenizer-file line %d", line)
+		}
+		ranks[string(decoded)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(ranks) == 0 {
+		return fmt.Errorf("-tokenizer-file %s contains no tokens", config().TokenizerFile)
+	}
+	tokenizer = &bpeTokenizer{ranks: ranks}
+	log.Printf(tr("tokenizer_loaded"), config().TokenizerFile, len(ranks))
+	return nil
+}
+
+func (t *bpeTokenizer) count(text string) int {
+	total := 0
+	for _, piece := range splitPretokens(text) {
+		if _, ok := t.ranks[piece]; ok {
+			total++
+			continue
+		}
+		total += t.mergeCount([]byte(piece))
+	}
+	return total
+}
+
+// 按 tiktoken 的规则逐段匹配。纯空白片段后面紧跟非空白字符时，最后一个空白字符留给下一段，
+// 与原规则中的 \s+(?!\S) 等价
+func splitPretokens(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := o200kPattern.FindStringIndex(text)
+		end := 1
+		if loc != nil && loc[1] > 0 {
+			end = loc[1]
+		}
+		if end < len(text) && strings.TrimSpace(text[:end]) == "" {
+			if next, _ := utf8.DecodeRuneInString(text[end:]); !unicode.IsSpace(next) {
+				if _, size := utf8.DecodeLastRuneInString(text[:end]); end > size {
+					end -= size
+				}
+			}
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// 字节级 BPE：反复合并 rank 最小的相邻片段，直到没有可合并的片段，返回剩余片段数
+func (t *bpeTokenizer) mergeCount(piece []byte) int {
+	parts := make([]string, len(piece))
+	for i := range piece {
+		parts[i] = string(piece[i : i+1])
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(parts)-1; i++ {
+			rank, ok := t.ranks[parts[i]+parts[i+1]]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return len(parts)
+}
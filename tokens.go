@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// 每条消息在对话模板中的固定开销（角色标记等）
+const tokensPerMessage = 4
+
+// 估算文本 token 数：加载了 -tokenizer-file 时按词表精确计算；
+// 否则粗略估算，ASCII 约 4 个字符一个 token，CJK 等非 ASCII 字符约一个字符一个 token
+func estimateTextTokens(text string) int {
+	if tokenizer != nil {
+		return tokenizer.count(text)
+	}
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// 提前结束的流拿不到上游用量，按请求消息和已输出的文本估算
+func estimateUsage(messages []Message, completion string) CloudflareUsage {
+	prompt := countMessageTokens(messages)
+	output := estimateTextTokens(completion)
+	return CloudflareUsage{PromptTokens: prompt, CompletionTokens: output, TotalTokens: prompt + output}
+}
+
+// 上游偶尔返回全为 0 或缺失的 usage，此时按请求消息和输出文本在本地计算，
+// 保证响应中的 usage 和用量统计不为空
+func fillMissingUsage(usage CloudflareUsage, messages []Message, completion string) CloudflareUsage {
+	if usage.TotalTokens > 0 || usage.PromptTokens > 0 || usage.CompletionTokens > 0 {
+		return usage
+	}
+	metrics.inc("gptoss2api_usage_estimated_total")
+	return estimateUsage(messages, completion)
+}
+
+func countMessageTokens(messages []Message) int {
+	total := 3
+	for _, msg := range messages {
+		total += tokensPerMessage + estimateTextTokens(msg.Role)
+		switch content := msg.Content.(type) {
+		case string:
+			total += estimateTextTokens(content)
+		case nil:
+		default:
+			// 内容分段等结构化内容按 JSON 文本估算
+			data, _ := json.Marshal(content)
+			total += estimateTextTokens(string(data))
+		}
+	}
+	return total
+}
+
+// 与 OpenAI 的 context_length_exceeded 错误保持一致的提示
+func checkContextLength(model string, openaiReq OpenAIRequest) error {
+	caps, ok := modelCapabilities[model]
+	if !ok || caps.ContextWindow <= 0 {
+		return nil
+	}
+	promptTokens := countMessageTokens(openaiReq.Messages)
+	completionTokens := 0
+	if openaiReq.MaxCompletionTokens != nil {
+		completionTokens = *openaiReq.MaxCompletionTokens
+	} else if openaiReq.MaxTokens != nil {
+		completionTokens = *openaiReq.MaxTokens
+	}
+	if promptTokens+completionTokens <= caps.ContextWindow {
+		return nil
+	}
+	return fmt.Errorf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+		caps.ContextWindow, promptTokens+completionTokens, promptTokens, completionTokens)
+}
+
+// 客户端未指定时使用默认 max_tokens，超过上限时截断到上限；
+// -key-limits 中按密钥的配置优先于租户配置，租户配置优先于全局配置
+func applyMaxTokensPolicy(r *http.Request, openaiReq *OpenAIRequest) {
+	defaultMax, maxCap := config().DefaultMaxTokens, config().MaxTokensCap
+	if t := requestTenant(r.Context()); t != nil {
+		if t.DefaultMaxTokens > 0 {
+			defaultMax = t.DefaultMaxTokens
+		}
+		if t.MaxTokensCap > 0 {
+			maxCap = t.MaxTokensCap
+		}
+	}
+	if id := requestKeyID(r); id != "" {
+		limit := keyLimitFor(id)
+		if limit.DefaultMaxTokens > 0 {
+			defaultMax = limit.DefaultMaxTokens
+		}
+		if limit.MaxTokensCap > 0 {
+			maxCap = limit.MaxTokensCap
+		}
+	}
+
+	var effective int
+	switch {
+	case openaiReq.MaxCompletionTokens != nil:
+		effective = *openaiReq.MaxCompletionTokens
+	case openaiReq.MaxTokens != nil:
+		effective = *openaiReq.MaxTokens
+	case defaultMax > 0:
+		effective = defaultMax
+	default:
+		return
+	}
+	if maxCap > 0 && effective > maxCap {
+		effective = maxCap
+	}
+	openaiReq.MaxTokens = &effective
+	openaiReq.MaxCompletionTokens = nil
+}
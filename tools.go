@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// OpenAI 函数调用相关结构
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Chat Completions 的工具定义嵌套在 function 中，Responses API 则是扁平结构
+func convertToolsToCloudflare(tools []Tool) []interface{} {
+	var cfTools []interface{}
+	for _, tool := range tools {
+		if tool.Type != "function" {
+			continue
+		}
+		cfTool := map[string]interface{}{
+			"type": "function",
+			"name": tool.Function.Name,
+		}
+		if tool.Function.Description != "" {
+			cfTool["description"] = tool.Function.Description
+		}
+		if len(tool.Function.Parameters) > 0 {
+			cfTool["parameters"] = tool.Function.Parameters
+		}
+		if tool.Function.Strict != nil {
+			cfTool["strict"] = *tool.Function.Strict
+		}
+		cfTools = append(cfTools, cfTool)
+	}
+	return cfTools
+}
+
+// "auto"/"none"/"required" 原样转发，指定函数时转换为 Responses API 的扁平格式
+func convertToolChoice(choice interface{}) interface{} {
+	m, ok := choice.(map[string]interface{})
+	if !ok {
+		return choice
+	}
+	if fn, ok := m["function"].(map[string]interface{}); ok {
+		return map[string]interface{}{"type": "function", "name": fn["name"]}
+	}
+	return choice
+}
+
+// 把一条聊天消息转换为 Responses API 的输入项：助手发起的工具调用转为 function_call，
+// 工具返回结果转为 function_call_output
+func convertMessageToInput(msg Message) []map[string]interface{} {
+	switch {
+	case msg.Role == "tool":
+		output, _ := normalizeContent(msg.Content).(string)
+		if output == "" && msg.Content != nil {
+			data, _ := json.Marshal(msg.Content)
+			output = string(data)
+		}
+		return []map[string]interface{}{{
+			"type":    "function_call_output",
+			"call_id": msg.ToolCallID,
+			"output":  output,
+		}}
+	case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+		var items []map[string]interface{}
+		if text, _ := normalizeContent(msg.Content).(string); text != "" {
+			items = append(items, map[string]interface{}{"role": msg.Role, "content": text})
+		}
+		for _, call := range msg.ToolCalls {
+			items = append(items, map[string]interface{}{
+				"type":      "function_call",
+				"call_id":   call.ID,
+				"name":      call.Function.Name,
+				"arguments": call.Function.Arguments,
+			})
+		}
+		return items
+	}
+	return []map[string]interface{}{{
+		"role":    msg.Role,
+		"content": normalizeContent(msg.Content),
+	}}
+}
+
+func extractToolCalls(output []CloudflareOutputItem) []ToolCall {
+	var calls []ToolCall
+	for _, item := range output {
+		if item.Type != "function_call" {
+			continue
+		}
+		id := item.CallID
+		if id == "" {
+			id = item.ID
+		}
+		calls = append(calls, ToolCall{
+			ID:   id,
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      item.Name,
+				Arguments: item.Arguments,
+			},
+		})
+	}
+	return calls
+}
+
+// 把上游的函数调用事件转换为 OpenAI 的增量格式：response.output_item.added 时下发
+// index、id、type 和函数名（arguments 为空字符串），之后每个 response.function_call_arguments.delta
+// 只带 index 和参数片段。LangChain 等客户端按 index 拼接参数，依赖这种逐段格式
+type toolCallStreamer struct {
+	indexes  map[string]int
+	streamed map[string]bool
+	emitted  map[string]bool
+}
+
+func newToolCallStreamer() *toolCallStreamer {
+	return &toolCallStreamer{indexes: make(map[string]int), streamed: make(map[string]bool), emitted: make(map[string]bool)}
+}
+
+// 返回需要发给客户端的 tool_calls 增量，与函数调用无关的事件返回 nil
+func (s *toolCallStreamer) event(event cloudflareStreamEvent) []map[string]interface{} {
+	switch event.Type {
+	case "response.output_item.added":
+		item := event.Item
+		if item == nil || item.Type != "function_call" {
+			return nil
+		}
+		id := item.CallID
+		if id == "" {
+			id = item.ID
+		}
+		index := len(s.indexes)
+		s.indexes[item.ID] = index
+		s.emitted[id] = true
+		if item.Arguments != "" {
+			s.streamed[item.ID] = true
+		}
+		return []map[string]interface{}{{
+			"index":    index,
+			"id":       id,
+			"type":     "function",
+			"function": ToolCallFunction{Name: item.Name, Arguments: item.Arguments},
+		}}
+	case "response.function_call_arguments.delta":
+		index, ok := s.indexes[event.ItemID]
+		if !ok || event.Delta == "" {
+			return nil
+		}
+		s.streamed[event.ItemID] = true
+		return []map[string]interface{}{{"index": index, "function": map[string]interface{}{"arguments": event.Delta}}}
+	case "response.function_call_arguments.done":
+		// 上游没有发送参数增量时一次性补发完整参数
+		index, ok := s.indexes[event.ItemID]
+		if !ok || s.streamed[event.ItemID] || event.Arguments == "" {
+			return nil
+		}
+		s.streamed[event.ItemID] = true
+		return []map[string]interface{}{{"index": index, "function": map[string]interface{}{"arguments": event.Arguments}}}
+	}
+	return nil
+}
+
+// 上游只在最终响应中给出的函数调用（没有对应的流式事件）在结束前整体下发，index 接在已下发的之后
+func (s *toolCallStreamer) finish(calls []ToolCall) []map[string]interface{} {
+	var deltas []map[string]interface{}
+	for _, call := range calls {
+		if s.emitted[call.ID] {
+			continue
+		}
+		s.emitted[call.ID] = true
+		deltas = append(deltas, map[string]interface{}{
+			"index":    len(s.indexes) + len(deltas),
+			"id":       call.ID,
+			"type":     call.Type,
+			"function": call.Function,
+		})
+	}
+	return deltas
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertToolsToCloudflare(t *testing.T) {
+	tests := []struct {
+		name string
+		req  string
+		want string
+	}{
+		{
+			name: "tools are flattened and a named tool_choice is converted",
+			req: `{"messages":[{"role":"user","content":"weather?"}],
+				"tools":[{"type":"function","function":{"name":"get_weather","description":"Look up the weather","parameters":{"type":"object"},"strict":true}}],
+				"tool_choice":{"type":"function","function":{"name":"get_weather"}},"parallel_tool_calls":false}`,
+			want: `{"model":"m","input":[{"role":"user","content":"weather?"}],
+				"tools":[{"type":"function","name":"get_weather","description":"Look up the weather","parameters":{"type":"object"},"strict":true}],
+				"tool_choice":{"type":"function","name":"get_weather"},"parallel_tool_calls":false}`,
+		},
+		{
+			name: "string tool_choice is passed through",
+			req:  `{"messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function","function":{"name":"f"}}],"tool_choice":"required"}`,
+			want: `{"model":"m","input":[{"role":"user","content":"hi"}],"tools":[{"type":"function","name":"f"}],"tool_choice":"required"}`,
+		},
+		{
+			name: "tool call history becomes function_call items",
+			req: `{"messages":[{"role":"user","content":"weather?"},
+				{"role":"assistant","content":"checking","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+				{"role":"tool","tool_call_id":"call_1","content":"sunny"}]}`,
+			want: `{"model":"m","input":[{"role":"user","content":"weather?"},
+				{"role":"assistant","content":"checking"},
+				{"type":"function_call","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Paris\"}"},
+				{"type":"function_call_output","call_id":"call_1","output":"sunny"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OpenAIRequest
+			if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, convertToCloudflareRequest(req, "m"), tt.want)
+		})
+	}
+}
+
+func TestConvertFunctionCallResponse(t *testing.T) {
+	var resp CloudflareResponse
+	json.Unmarshal([]byte(`{"id":"resp_1","created_at":1,"model":"m","status":"completed",
+		"output":[{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Paris\"}"}],
+		"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}`), &resp)
+	assertJSON(t, convertToOpenAIResponse(&resp, OpenAIRequest{}), `{"id":"resp_1","object":"chat.completion","created":1,"model":"m",
+		"choices":[{"index":0,"message":{"role":"assistant","content":null,
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}],
+		"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}`)
+}
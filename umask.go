@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// 在创建套接字文件之前设置 umask，文件一出现就是 0660；先 Listen 再 chmod 时，
+// 中间有一段时间任何本地用户都可以连入。umask 是进程级的，监听只在启动时打开，
+// 此时还没有其他 goroutine 创建文件
+func listenUnixSocket(path string) (net.Listener, error) {
+	old := syscall.Umask(0117)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
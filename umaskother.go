@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// 没有 umask 的平台上只能在创建后修改权限
+func listenUnixSocket(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 调用上游使用的 HTTP 客户端，进程内共享以复用 keep-alive 连接。非流式请求在上游生成完毕后
+// 才返回响应头，总时长和响应头超时基本一致；流式请求很快返回响应头，之后持续输出，因此两者分别设置
+var upstreamClients struct {
+	once   sync.Once
+	plain  *http.Client
+	stream *http.Client
+}
+
+// 推理请求都发往 api.cloudflare.com（或 AI Gateway），每个主机的空闲连接数就是连接池大小；
+// 默认值 2 在并发请求下会不断新建 TLS 连接
+func newUpstreamTransport(headerTimeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{Timeout: config().UpstreamConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 egressProxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     config().UpstreamHTTP2,
+		MaxIdleConns:          config().UpstreamMaxIdleConns * 2,
+		MaxIdleConnsPerHost:   config().UpstreamMaxIdleConns,
+		IdleConnTimeout:       config().UpstreamIdleTimeout,
+		TLSHandshakeTimeout:   config().UpstreamConnectTimeout,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: headerTimeout,
+	}
+	if !config().UpstreamHTTP2 {
+		// 非 nil 的空映射会关闭 HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// -proxy 指定出站代理（http、https 或 socks5），未指定时使用 HTTPS_PROXY/HTTP_PROXY/NO_PROXY 环境变量
+func egressProxy(req *http.Request) (*url.URL, error) {
+	if config().Proxy == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	return url.Parse(config().Proxy)
+}
+
+func validateEgressProxy() error {
+	if config().Proxy == "" {
+		return nil
+	}
+	u, err := url.Parse(config().Proxy)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid -proxy %q", config().Proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	}
+	return fmt.Errorf("invalid -proxy scheme %q, expected http, https or socks5", u.Scheme)
+}
+
+// 超时为 0 表示不限制
+func upstreamHTTPClient(stream bool) *http.Client {
+	upstreamClients.once.Do(func() {
+		var plain, stream http.RoundTripper = newUpstreamTransport(config().UpstreamHeaderTimeout), newUpstreamTransport(config().UpstreamStreamHeaderTimeout)
+		if config().Mock {
+			plain, stream = &mockTransport{next: plain}, &mockTransport{next: stream}
+		}
+		if config().CassetteMode != "" {
+			plain, stream = &cassetteTransport{next: plain}, &cassetteTransport{next: stream}
+		}
+		upstreamClients.plain = &http.Client{Transport: plain, Timeout: config().UpstreamTimeout}
+		upstreamClients.stream = &http.Client{Transport: stream, Timeout: config().UpstreamStreamTimeout}
+	})
+	if stream {
+		return upstreamClients.stream
+	}
+	return upstreamClients.plain
+}
+
+// 连接、响应头和总时长超时都视为上游超时，返回 504
+func isUpstreamTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 图片最大字节数，超过时拒绝请求，避免把过大的图片下载到内存中
+const maxVisionImageBytes = 10 << 20
+
+// gpt-oss 不支持图片输入。消息中带有 image_url 分段、而所选模型登记为不支持图片时，
+// 改用 -vision-model 通过 Workers AI 的 run 接口生成回复；-vision-model 为空时仍按模型能力返回 400
+func useVisionModel(model string, messages []Message) bool {
+	if config().VisionModel == "" || !hasImageParts(messages) {
+		return false
+	}
+	if model == config().VisionModel {
+		return true
+	}
+	caps, ok := modelCapabilities[model]
+	return ok && !caps.Vision
+}
+
+func hasImageParts(messages []Message) bool {
+	for _, msg := range messages {
+		parts, _ := msg.Content.([]interface{})
+		for _, part := range parts {
+			if p, _ := part.(map[string]interface{}); p != nil {
+				if partType, _ := p["type"].(string); imagePartTypes[partType] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Llama 3.2 Vision 等模型每个请求只接受一张图片，图片以字节数组放在 image 字段中，消息只保留文本
+func convertVisionRequest(ctx context.Context, openaiReq OpenAIRequest) (map[string]interface{}, error) {
+	var messages []map[string]interface{}
+	var imageURL string
+	for _, msg := range openaiReq.Messages {
+		content, _ := normalizeContent(msg.Content).(string)
+		if parts, ok := msg.Content.([]interface{}); ok {
+			var texts []string
+			for _, part := range parts {
+				p, _ := part.(map[string]interface{})
+				if partType, _ := p["type"].(string); imagePartTypes[partType] {
+					if imageURL != "" {
+						return nil, fmt.Errorf("the vision model accepts only one image per request")
+					}
+					imageURL = partImageURL(p)
+					continue
+				}
+				text, _ := partText(p)
+				texts = append(texts, text)
+			}
+			content = strings.Join(texts, "\n")
+		}
+		messages = append(messages, map[string]interface{}{"role": msg.Role, "content": content})
+	}
+
+	image, err := loadVisionImage(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]interface{}{"messages": messages, "image": bytesToIntArray(image)}
+	if openaiReq.MaxCompletionTokens != nil {
+		payload["max_tokens"] = *openaiReq.MaxCompletionTokens
+	} else if openaiReq.MaxTokens != nil {
+		payload["max_tokens"] = *openaiReq.MaxTokens
+	}
+	if openaiReq.Temperature != nil {
+		payload["temperature"] = *openaiReq.Temperature
+	}
+	if openaiReq.TopP != nil {
+		payload["top_p"] = *openaiReq.TopP
+	}
+	return payload, nil
+}
+
+// 支持 base64 的 data URL 和 http(s) 地址；远程图片经出站代理下载
+func loadVisionImage(ctx context.Context, imageURL string) ([]byte, error) {
+	if strings.HasPrefix(imageURL, "data:") {
+		header, data, ok := strings.Cut(strings.TrimPrefix(imageURL, "data:"), ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return nil, fmt.Errorf("image data URLs must be base64 encoded")
+		}
+		image, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 image data")
+		}
+		if len(image) > maxVisionImageBytes {
+			return nil, fmt.Errorf("image exceeds %d MB", maxVisionImageBytes>>20)
+		}
+		return image, nil
+	}
+	if !strings.HasPrefix(imageURL, "http://") && !strings.HasPrefix(imageURL, "https://") {
+		return nil, fmt.Errorf("image_url must be an http(s) URL or a base64 data URL")
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image_url")
+	}
+	resp, err := upstreamHTTPClient(false).Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: HTTP %d", resp.StatusCode)
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxVisionImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %v", err)
+	}
+	if len(image) > maxVisionImageBytes {
+		return nil, fmt.Errorf("image exceeds %d MB", maxVisionImageBytes>>20)
+	}
+	return image, nil
+}
+
+// 视觉模型的回复一次性返回；流式请求以单个数据块发送全部内容
+func handleVisionChat(w http.ResponseWriter, r *http.Request, openaiReq OpenAIRequest, reqLog *requestLog) {
+	model := config().VisionModel
+	payload, err := convertVisionRequest(r.Context(), openaiReq)
+	if err != nil {
+		writeErrorParam(w, http.StatusBadRequest, "invalid_image", err.Error(), "messages")
+		return
+	}
+
+	upstreamStart := time.Now()
+	body, _, err := callCloudflareRun(r.Context(), model, payload)
+	recordModelResult(model, err, time.Since(upstreamStart))
+	if err != nil {
+		recordUsage(r, model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	reqLog.Body(tr("upstream_raw"), string(body))
+	var cfResp struct {
+		Result struct {
+			Response string          `json:"response"`
+			Usage    CloudflareUsage `json:"usage"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &cfResp); err != nil {
+		recordUsage(r, model, Usage{}, err)
+		writeUpstreamError(w, err)
+		return
+	}
+	filled := fillMissingUsage(cfResp.Result.Usage, openaiReq.Messages, cfResp.Result.Response)
+	usage := Usage{PromptTokens: filled.PromptTokens, CompletionTokens: filled.CompletionTokens, TotalTokens: filled.TotalTokens}
+	recordUsage(r, model, usage, nil)
+	recordNeurons(r.Context(), model, usage)
+
+	text, _ := truncateAtStop(cfResp.Result.Response, openaiReq.Stop)
+	openaiResp := OpenAIResponse{
+		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []Choice{{Message: Message{Role: "assistant", Content: text}, FinishReason: "stop"}},
+		Usage:   usage,
+	}
+	applyFooter(&openaiResp, openaiReq)
+
+	if !openaiReq.Stream {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(openaiResp)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	out := &chunkWriter{w: w, id: openaiResp.ID, model: model, created: openaiResp.Created}
+	if openaiReq.StreamOptions != nil {
+		out.includeUsage = openaiReq.StreamOptions.IncludeUsage
+	}
+	out.send(map[string]interface{}{"content": openaiResp.Choices[0].Message.Content}, nil, nil)
+	out.send(map[string]interface{}{}, "stop", nil)
+	if out.includeUsage {
+		out.sendUsage(usage)
+	}
+	w.Write([]byte("data: [DONE]\n\n"))
+	w.(http.Flusher).Flush()
+}